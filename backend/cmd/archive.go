@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/archive"
+	"github.com/sardap/TuneNeutral/backend/pkg/client"
+)
+
+func runArchiveCommand(command, file, serverUrl, accessToken, mode string) error {
+	if accessToken == "" {
+		return fmt.Errorf("access-token must be set")
+	}
+	c := client.New(serverUrl, accessToken)
+
+	switch command {
+	case "export":
+		return downloadExport(c, file)
+	case "import":
+		return uploadExport(c, file, client.ImportMode(mode))
+	}
+
+	return fmt.Errorf("unknown command %s", command)
+}
+
+func downloadExport(c *client.Client, file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := c.Export(context.Background(), f); err != nil {
+		return err
+	}
+
+	// Re-read what landed on disk so a truncated download is caught now
+	// rather than at import time
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	_, manifest, err := archive.Read(f, info.Size())
+	if err != nil {
+		return err
+	}
+
+	return printJSON(manifest)
+}
+
+func uploadExport(c *client.Client, file string, mode client.ImportMode) error {
+	if mode != client.ImportMerge && mode != client.ImportReplace {
+		return fmt.Errorf("import-mode must be %s or %s", client.ImportMerge, client.ImportReplace)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, _, err := archive.Read(f, info.Size()); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	report, err := c.Import(context.Background(), f, mode)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(report)
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/backup"
+)
+
+func runBackupCommand(command, file, serverUrl, adminToken string) error {
+	switch command {
+	case "backup":
+		return downloadBackup(file, serverUrl, adminToken)
+	case "restore":
+		return uploadBackup(file, serverUrl, adminToken)
+	case "verify":
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		manifest, err := backup.Verify(f)
+		if err != nil {
+			return err
+		}
+		return printManifest(manifest)
+	}
+
+	return fmt.Errorf("unknown command %s", command)
+}
+
+func adminRequest(method, url, adminToken string, body io.Reader) (*http.Response, error) {
+	if adminToken == "" {
+		return nil, fmt.Errorf("admin-token must be set")
+	}
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return resp, nil
+}
+
+func downloadBackup(file, serverUrl, adminToken string) error {
+	resp, err := adminRequest(http.MethodGet, serverUrl+"/admin/backup", adminToken, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return err
+	}
+
+	// Re-read what landed on disk so a truncated download is caught now
+	// rather than at restore time
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	manifest, err := backup.Verify(f)
+	if err != nil {
+		return err
+	}
+
+	return printManifest(manifest)
+}
+
+func uploadBackup(file, serverUrl, adminToken string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := backup.Verify(f); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	resp, err := adminRequest(http.MethodPost, serverUrl+"/admin/restore", adminToken, f)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Result *backup.Manifest `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return err
+	}
+
+	return printManifest(body.Result)
+}
+
+func printManifest(manifest *backup.Manifest) error {
+	return printJSON(manifest)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"math/rand"
+	"os"
 	"time"
 
 	"github.com/namsral/flag"
@@ -12,21 +13,77 @@ import (
 	"github.com/sardap/TuneNeutral/backend/pkg/router"
 )
 
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags] [serve|backup <file>|restore <file>|verify <file>|export <file>|import <file>]\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
 func main() {
 	rand.Seed(time.Now().UnixMicro())
 
 	cfg := &config.Config{}
+	var serverUrl, accessToken, importMode string
+	var cookieAuthSecret, cookieEncryptSecret, cookieSecrets string
 
 	flag.StringVar(&cfg.ClientId, "spotify-client-id", "", "spotify client id")
 	flag.StringVar(&cfg.ClientSecret, "spotify-client-secret", "", "spotify client secert")
 	flag.StringVar(&cfg.Scheme, "scheme", "http", "http scheme")
 	flag.StringVar(&cfg.Domain, "domain", "localhost:8080", "domain")
 	flag.StringVar(&cfg.DatabasePath, "database-path", "database", "database path")
+	flag.IntVar(&cfg.TrackCacheSize, "track-cache-size", 10000, "number of tracks kept in memory, 0 disables the cache")
 	flag.StringVar(&cfg.WebsiteFilesPath, "website-file-path", "", "static website file path")
-	flag.StringVar(&cfg.CookieAuthSecert, "cookie_auth_secret", "", "")
-	flag.StringVar(&cfg.CookieEyncSecert, "cookie-enyc-secret", "", "")
+	flag.StringVar(&cookieSecrets, "cookie-secrets", "", "comma separated auth:encrypt cookie secret pairs, newest first")
+	flag.StringVar(&cookieAuthSecret, "cookie_auth_secret", "", "auth secret of the oldest cookie secret pair")
+	flag.StringVar(&cookieEncryptSecret, "cookie-enyc-secret", "", "encrypt secret of the oldest cookie secret pair")
+	flag.StringVar(&cfg.AdminToken, "admin-token", "", "token for the /admin endpoints, admin endpoints are disabled when empty")
+	flag.StringVar(&cfg.BackupDir, "backup-dir", "", "directory for scheduled backups, scheduled backups are disabled when empty")
+	flag.DurationVar(&cfg.BackupInterval, "backup-interval", 24*time.Hour, "time between scheduled backups")
+	flag.IntVar(&cfg.BackupRetention, "backup-retention", 7, "number of scheduled backups to keep")
+	flag.DurationVar(&cfg.CallbackDelay, "callback-delay", time.Second, "added to every oauth callback to make guessing states expensive")
+	flag.StringVar(&serverUrl, "server-url", "http://localhost:8080", "running server used by the backup, restore, export and import commands")
+	flag.StringVar(&accessToken, "access-token", "", "personal access token used by the export and import commands")
+	flag.StringVar(&importMode, "import-mode", "merge", "merge keeps existing data on import, replace removes it first")
+	flag.Usage = usage
 	flag.Parse()
 
+	secrets, err := config.ParseCookieSecrets(cookieSecrets)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cookie-secrets: %v\n", err)
+		os.Exit(2)
+	}
+	if cookieAuthSecret != "" || cookieEncryptSecret != "" {
+		secrets = append(secrets, config.CookieSecret{Auth: cookieAuthSecret, Encrypt: cookieEncryptSecret})
+	}
+	cfg.CookieSecrets = secrets
+
+	switch flag.Arg(0) {
+	case "", "serve":
+		serve(cfg)
+	case "backup", "restore", "verify":
+		if flag.NArg() != 2 {
+			usage()
+			os.Exit(2)
+		}
+		if err := runBackupCommand(flag.Arg(0), flag.Arg(1), serverUrl, cfg.AdminToken); err != nil {
+			fmt.Fprintf(os.Stderr, "%s failed: %v\n", flag.Arg(0), err)
+			os.Exit(1)
+		}
+	case "export", "import":
+		if flag.NArg() != 2 {
+			usage()
+			os.Exit(2)
+		}
+		if err := runArchiveCommand(flag.Arg(0), flag.Arg(1), serverUrl, accessToken, importMode); err != nil {
+			fmt.Fprintf(os.Stderr, "%s failed: %v\n", flag.Arg(0), err)
+			os.Exit(1)
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func serve(cfg *config.Config) {
 	if err := cfg.Valid(); err != nil {
 		fmt.Printf("Error starting %v", err)
 		return
@@ -34,5 +91,6 @@ func main() {
 
 	dbConn := db.ConnectDb(cfg)
 	defer dbConn.Close()
+	go router.PullBadIps(dbConn)
 	router.CreateRouter(cfg, dbConn).Run(":8080")
 }
@@ -1,7 +1,7 @@
 package api
 
 import (
-	"fmt"
+	"errors"
 	"math/rand"
 	"sort"
 	"time"
@@ -14,17 +14,19 @@ import (
 	"github.com/zmb3/spotify"
 )
 
-var (
-	ErrServerError = fmt.Errorf("internal server error")
-	ErrNotFound    = fmt.Errorf("not found")
-)
-
 const (
-	Userkey  = "user"
+	Userkey  = models.SessionUserKey
 	TokenKey = "token"
 	StateKey = "state"
 )
 
+const (
+	// PlaylistLength is how many tracks the generator picks
+	PlaylistLength = 10
+	// CooldownDays is how long a track sits out after being in a playlist
+	CooldownDays = 7
+)
+
 func Logout(session sessions.Session) error {
 	session.Delete(Userkey)
 	session.Delete(TokenKey)
@@ -33,13 +35,33 @@ func Logout(session sessions.Session) error {
 	return nil
 }
 
-func GetPlaylists(db *db.Database, userId string) ([]*models.MoodPlaylist, error) {
-	playlists, err := db.GetMoodPlaylists(userId)
+// ConsumeAuthState returns the auth state and makes sure it can't be used
+// again. Unknown, expired or used states are ErrNotFound.
+func ConsumeAuthState(db *db.Database, state string) (*models.AuthState, error) {
+	authState, err := db.ConsumeAuthState(state)
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, Internal(err)
+	}
+
+	return authState, nil
+}
+
+// GetPlaylists returns a page of the user's playlists and the cursor for the
+// next page, which is empty on the last page.
+func GetPlaylists(dbConn *db.Database, userId string, query db.MoodPlaylistQuery) ([]*models.MoodPlaylist, string, error) {
+	playlists, more, err := dbConn.QueryMoodPlaylists(userId, query)
 	if err != nil {
-		return nil, ErrServerError
+		return nil, "", Internal(err)
+	}
+
+	nextCursor := ""
+	if more {
+		nextCursor = playlists[len(playlists)-1].Date.Format(models.DateFormat)
 	}
 
-	return playlists, nil
+	return playlists, nextCursor, nil
 }
 
 func GetPlaylist(db *db.Database, userId string, date string) (*models.MoodPlaylist, error) {
@@ -48,12 +70,31 @@ func GetPlaylist(db *db.Database, userId string, date string) (*models.MoodPlayl
 		if err == badger.ErrKeyNotFound {
 			return nil, ErrNotFound
 		}
-		return nil, ErrServerError
+		return nil, Internal(err)
 	}
 
 	return playlist, nil
 }
 
+func GetTracks(db *db.Database, ids []string) ([]*models.Track, []string, error) {
+	tracks, missing, err := db.GetTracks(ids...)
+	if err != nil {
+		return nil, nil, Internal(err)
+	}
+
+	return tracks, missing, nil
+}
+
+// spotifyError maps an error from the spotify client, keeping
+// ErrUnauthorized so the user is sent back to log in.
+func spotifyError(err error) error {
+	if errors.Is(err, ErrUnauthorized) {
+		return ErrUnauthorized.WithCause(err)
+	}
+
+	return Internal(err)
+}
+
 func transformValence(valence float32) float32 {
 	return valence - 0.5
 }
@@ -72,24 +113,23 @@ func fetchNextUserTracks(db *db.Database, userId string, client SpotifyClient) e
 		return nil
 	}
 
-	userTracks, err := db.GetUserTracks(userId)
+	scan, err := db.GetLibraryScan(userId)
 	if err == badger.ErrKeyNotFound {
-		userTracks = &models.UserTracks{
-			UserId:   userId,
-			TrackIds: make(map[string]models.MinTrack),
-		}
+		scan = &models.LibraryScan{}
+	} else if err != nil {
+		return err
 	}
 
 	limit := 20
 	opts := &spotify.Options{
-		Offset: &userTracks.LastOffset,
+		Offset: &scan.LastOffset,
 		Limit:  &limit,
 	}
 	userTracksResponse, err := client.CurrentUsersTracksOpt(opts)
 	if err != nil {
 		return err
 	}
-	userTracks.LastOffset += limit
+	scan.LastOffset += limit
 
 	featuresMap := make(map[string]*spotify.AudioFeatures)
 	featuresToFetch := make([]spotify.ID, 0)
@@ -106,9 +146,10 @@ func fetchNextUserTracks(db *db.Database, userId string, client SpotifyClient) e
 		featuresMap[feature.ID.String()] = feature
 	}
 
+	pageTracks := make(map[string]models.MinTrack)
 	for _, track := range userTracksResponse.Tracks {
-		if userTracks.LastTrackScanned != nil && string(track.ID) == *userTracks.LastTrackScanned {
-			userTracks.LastOffset = userTracksResponse.Total + 1
+		if scan.LastTrackScanned != nil && string(track.ID) == *scan.LastTrackScanned {
+			scan.LastOffset = userTracksResponse.Total + 1
 			break
 		}
 
@@ -138,19 +179,21 @@ func fetchNextUserTracks(db *db.Database, userId string, client SpotifyClient) e
 
 		db.PutTrack(&modelTrack)
 
-		userTracks.TrackIds[modelTrack.Id] = models.MinTrack{Valence: modelTrack.Valence, Energy: modelTrack.Energy}
+		pageTracks[modelTrack.Id] = models.MinTrack{Valence: modelTrack.Valence, Energy: modelTrack.Energy}
 	}
 
-	if userTracks.LastOffset >= userTracksResponse.Total {
-		userTracks.CompletedScan = true
-		userTracks.LastOffset = 0
+	if scan.LastOffset >= userTracksResponse.Total {
+		scan.CompletedScan = true
+		scan.LastOffset = 0
 		lastTrack := string(userTracksResponse.Tracks[len(userTracksResponse.Tracks)-1].ID)
-		userTracks.LastTrackScanned = &lastTrack
+		scan.LastTrackScanned = &lastTrack
 	}
 
-	db.SetUserTracks(userId, userTracks)
+	if err := db.SaveLibraryPage(userId, scan, pageTracks); err != nil {
+		return err
+	}
 
-	if !userTracks.CompletedScan && len(userTracks.TrackIds) < 1000 {
+	if !scan.CompletedScan && db.LibraryTrackCount(userId) < 1000 {
 		return fetchNextUserTracks(db, userId, client)
 	}
 
@@ -163,79 +206,79 @@ func feelNothingYet(mood models.Mood) bool {
 	return mood > models.MoodNothing-models.Mood(0.05) && mood < models.MoodNothing+models.Mood(0.05)
 }
 
-func GenerateMoodPlaylist(
-	dbConn *db.Database, userId string, client SpotifyClient,
-	startMood models.Mood, date time.Time, note string,
-) (*models.MoodPlaylist, error) {
-	if err := fetchNextUserTracks(dbConn, userId, client); err != nil {
-		return nil, err
-	}
+// recentTracks are the tracks in userId's playlists during the cooldown before
+// date, they sit out of new playlists.
+func recentTracks(dbConn *db.Database, userId string, date time.Time, into map[string]interface{}) {
+	playlists, _ := dbConn.GetMoodPlaylitsBetweenDates(userId, date.AddDate(0, 0, -CooldownDays), date)
 
-	userTracks, _ := dbConn.GetUserTracks(userId)
-
-	ignoreTracks := make(map[string]interface{})
-	{
-		playlists, _ := dbConn.GetMoodPlaylitsBetweenDates(userId, date.Add(-(24 * 7 * time.Hour)), date)
-
-		for _, playlist := range playlists {
-			for _, track := range playlist.Tracks {
-				ignoreTracks[track] = nil
-			}
+	for _, playlist := range playlists {
+		for _, track := range playlist.Tracks {
+			into[track] = nil
 		}
-
 	}
+}
+
+// moodBucket returns the candidate tracks in a mood category.
+type moodBucket func(mood models.Mood) (map[string]models.MinTrack, error)
 
+// walkMoods picks up to PlaylistLength tracks from bucket that take startMood
+// towards nothing, skipping ignoreTracks. Returns the picked track ids in
+// playing order and the mood the walk ends at.
+func walkMoods(startMood models.Mood, bucket moodBucket, ignoreTracks map[string]interface{}) ([]string, models.Mood, error) {
 	type entry struct {
 		id      string
 		valence float32
 		energy  float32
 	}
-	entries := make([]*entry, 0)
-	for id, minTrack := range userTracks.TrackIds {
-		if _, ok := ignoreTracks[id]; ok {
-			continue
-		}
-
-		entries = append(entries, &entry{
-			id:      id,
-			valence: minTrack.Valence,
-			energy:  minTrack.Energy,
-		})
-	}
-
-	result := &models.MoodPlaylist{
-		Date: date,
-		Note: &note,
-	}
 
 	feelNothing := false
 
+	// Buckets are only read the first time the walk below needs them
+	var bucketErr error
 	valSteps := make(map[models.Mood][]*entry)
-	for _, entry := range entries {
-		valSteps[models.ValenceMoodCategory(transformValence(entry.valence))] =
-			append(valSteps[models.ValenceMoodCategory(transformValence(entry.valence))], entry)
-	}
+	entries := func(mood models.Mood) []*entry {
+		if entries, ok := valSteps[mood]; ok {
+			return entries
+		}
+
+		tracks, err := bucket(mood)
+		if err != nil {
+			bucketErr = err
+		}
+
+		entries := make([]*entry, 0, len(tracks))
+		for id, minTrack := range tracks {
+			if _, ok := ignoreTracks[id]; ok {
+				continue
+			}
 
-	for mood := range valSteps {
-		rand.Shuffle(len(valSteps[mood]), func(i, j int) {
-			valSteps[mood][i], valSteps[mood][j] = valSteps[mood][j], valSteps[mood][i]
+			entries = append(entries, &entry{
+				id:      id,
+				valence: minTrack.Valence,
+				energy:  minTrack.Energy,
+			})
+		}
+
+		rand.Shuffle(len(entries), func(i, j int) {
+			entries[i], entries[j] = entries[j], entries[i]
 		})
+
+		valSteps[mood] = entries
+		return entries
 	}
 
 	var selectedTracks []*entry
 
-	result.StartMood = float32(startMood)
-
 	mood := startMood
 
-	for len(selectedTracks) < 10 {
+	for len(selectedTracks) < PlaylistLength {
 		if feelNothingYet(mood) {
 			feelNothing = true
 		}
 
 		moodCategory := models.ValenceMoodCategory(float32(mood)).Opposite()
 
-		for !feelNothing && len(valSteps[moodCategory]) <= 0 && !feelNothingYet(moodCategory) {
+		for !feelNothing && len(entries(moodCategory)) <= 0 && !feelNothingYet(moodCategory) {
 			if mood >= models.MoodNothing {
 				moodCategory += 0.125
 			} else {
@@ -243,11 +286,12 @@ func GenerateMoodPlaylist(
 			}
 		}
 
-		if moodCategory == models.MoodNothing && len(valSteps[moodCategory]) <= 0 {
+		// Only reachable with an empty bucket once the walk is at nothing
+		if len(entries(moodCategory)) <= 0 {
 			break
 		}
 
-		entry := valSteps[moodCategory][0]
+		entry := entries(moodCategory)[0]
 
 		nextMood := mood + (models.Mood(transformValence(entry.valence)) / 4)
 		valSteps[moodCategory] = append(valSteps[moodCategory][:0], valSteps[moodCategory][0+1:]...)
@@ -260,6 +304,10 @@ func GenerateMoodPlaylist(
 		mood = nextMood
 	}
 
+	if bucketErr != nil {
+		return nil, 0, bucketErr
+	}
+
 	sort.Slice(selectedTracks, func(i, j int) bool {
 		if startMood > models.MoodNothing {
 			return selectedTracks[i].valence < selectedTracks[j].valence
@@ -268,11 +316,40 @@ func GenerateMoodPlaylist(
 		return selectedTracks[i].valence > selectedTracks[j].valence
 	})
 
+	var result []string
 	for _, track := range selectedTracks {
-		result.Tracks = append(result.Tracks, track.id)
+		result = append(result, track.id)
+	}
+
+	return result, mood, nil
+}
+
+func GenerateMoodPlaylist(
+	dbConn *db.Database, userId string, client SpotifyClient,
+	startMood models.Mood, date time.Time, note string,
+) (*models.MoodPlaylist, error) {
+	if err := fetchNextUserTracks(dbConn, userId, client); err != nil {
+		return nil, err
+	}
+
+	ignoreTracks := make(map[string]interface{})
+	recentTracks(dbConn, userId, date, ignoreTracks)
+
+	bucket := func(mood models.Mood) (map[string]models.MinTrack, error) {
+		return dbConn.GetLibraryMoodBucket(userId, mood)
+	}
+	tracks, mood, err := walkMoods(startMood, bucket, ignoreTracks)
+	if err != nil {
+		return nil, Internal(err)
 	}
 
-	result.EndMood = float32(models.ValenceMoodCategory(float32(mood)))
+	result := &models.MoodPlaylist{
+		Date:      date,
+		Note:      &note,
+		StartMood: float32(startMood),
+		Tracks:    tracks,
+		EndMood:   float32(models.ValenceMoodCategory(float32(mood))),
+	}
 
 	dbConn.SetMoodPlaylist(userId, result)
 
@@ -281,8 +358,10 @@ func GenerateMoodPlaylist(
 
 func UpdateSpotifyPlaylist(dbConn *db.Database, client SpotifyClient, userId string, date string) error {
 	playlist, err := dbConn.GetMoodPlaylist(userId, date)
-	if err != nil {
+	if err == badger.ErrKeyNotFound {
 		return ErrNotFound
+	} else if err != nil {
+		return Internal(err)
 	}
 
 	playlistId, err := dbConn.GetSpotifyPlaylist(userId)
@@ -290,19 +369,19 @@ func UpdateSpotifyPlaylist(dbConn *db.Database, client SpotifyClient, userId str
 		if err == badger.ErrKeyNotFound {
 			resp, err := client.CreatePlaylistForUser(userId, "tune neutral", "Playlist for tune neutral", true)
 			if err != nil {
-				return ErrServerError
+				return spotifyError(err)
 			}
 			playlistId = string(resp.ID)
 			dbConn.SetSpotifyPlaylist(userId, playlistId)
 		} else {
-			return ErrNotFound
+			return Internal(err)
 		}
 	}
 
 	{
 		tracksResp, err := client.GetPlaylistTracks(spotify.ID(playlistId))
 		if err != nil {
-			return ErrServerError
+			return spotifyError(err)
 		}
 
 		var ids []spotify.ID
@@ -328,63 +407,39 @@ func UpdateSpotifyPlaylist(dbConn *db.Database, client SpotifyClient, userId str
 }
 
 func RemoveTrackFromUser(dbConn *db.Database, userId string, trackId string) error {
-	userTracks, err := dbConn.GetUserTracks(userId)
-	if err != nil {
-		return ErrNotFound
-	}
-
-	if userTracks.IgnoredTracks == nil {
-		userTracks.IgnoredTracks = map[string]interface{}{}
-	}
-	userTracks.IgnoredTracks[trackId] = nil
-
-	_, ok := userTracks.TrackIds[trackId]
-	if !ok {
+	err := dbConn.RemoveLibraryTrack(userId, trackId)
+	if err == badger.ErrKeyNotFound {
 		return ErrNotFound
+	} else if err == badger.ErrConflict {
+		return ErrConflict
+	} else if err != nil {
+		return Internal(err)
 	}
-	delete(userTracks.TrackIds, trackId)
-
-	dbConn.SetUserTracks(userId, userTracks)
 
 	return nil
 }
 
 func UnremoveTrackFromUser(dbConn *db.Database, userId string, trackId string) error {
-	userTracks, err := dbConn.GetUserTracks(userId)
-	if err != nil {
-		return ErrNotFound
-	}
-
-	if userTracks.IgnoredTracks == nil {
-		userTracks.IgnoredTracks = map[string]interface{}{}
-	}
-	_, ok := userTracks.IgnoredTracks[trackId]
-	if !ok {
-		return ErrNotFound
-	}
-	delete(userTracks.IgnoredTracks, trackId)
-
-	track, err := dbConn.GetTrack(trackId)
-	if err != nil {
+	err := dbConn.UnremoveLibraryTrack(userId, trackId)
+	if err == badger.ErrKeyNotFound {
 		return ErrNotFound
+	} else if err == badger.ErrConflict {
+		return ErrConflict
+	} else if err != nil {
+		return Internal(err)
 	}
-	userTracks.TrackIds[trackId] = models.MinTrack{Valence: track.Valence, Energy: track.Energy}
-
-	dbConn.SetUserTracks(userId, userTracks)
 
 	return nil
 }
 
 func GetRemovedTracksForUser(dbConn *db.Database, userId string) ([]string, error) {
-	userTracks, err := dbConn.GetUserTracks(userId)
-	if err != nil {
+	if _, err := dbConn.GetLibraryScan(userId); err != nil {
 		return nil, ErrNotFound
 	}
 
-	var trackIds []string
-
-	for trackId := range userTracks.IgnoredTracks {
-		trackIds = append(trackIds, trackId)
+	trackIds, err := dbConn.GetIgnoredTracks(userId)
+	if err != nil {
+		return nil, Internal(err)
 	}
 
 	return trackIds, nil
@@ -395,6 +450,11 @@ func ClearUserData(dbConn *db.Database, userId string) error {
 	dbConn.ClearMoodPlaylists(userId)
 	dbConn.ClearUserFetchLock(userId)
 	dbConn.ClearSpotifyPlaylist(userId)
+	dbConn.DeleteUserSessions(userId)
+	dbConn.ClearUserScopes(userId)
+	dbConn.DeleteUserAccessTokens(userId)
+	dbConn.DeleteUserShareLinks(userId)
+	LeaveGroups(dbConn, userId)
 
 	return nil
 }
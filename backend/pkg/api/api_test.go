@@ -1,7 +1,10 @@
 package api_test
 
 import (
+	"fmt"
+	"math/rand"
 	"path"
+	"sync"
 	"testing"
 	"time"
 
@@ -525,3 +528,249 @@ func TestGenerateMoodPlaylist(t *testing.T) {
 		assert.ErrorIs(t, err, scenario.expectedErr)
 	}
 }
+
+func TestGenerateMoodPlaylistContinuousMoods(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	client := newMockSpotifyClient()
+	var tracks []spotify.SavedTrack
+	var features []*spotify.AudioFeatures
+	for i := 0; i < 40; i++ {
+		id := fmt.Sprintf("track%d", i)
+		tracks = append(tracks, savedTrack(id))
+		features = append(features, &spotify.AudioFeatures{
+			ID:      spotify.ID(id),
+			Valence: float32(i) / 40,
+			Energy:  0.5,
+		})
+	}
+	mockLibrary(client, tracks, features)
+
+	for _, startMood := range []models.Mood{
+		models.MoodMin, -0.3, -0.2, -0.07, -0.04, 0, 0.01, 0.06, 0.19, 0.33, models.MoodMax,
+	} {
+		dbConn := newDatabase(t)
+
+		// Run
+		playlist, err := api.GenerateMoodPlaylist(dbConn, userId, client, startMood, easyParseDate("2022-01-06"), "")
+
+		assert.NoError(t, err)
+		assert.NotEmpty(t, playlist.Tracks, "start mood %v", startMood)
+		assert.Equal(t, float32(startMood), playlist.StartMood)
+
+		dbConn.Close()
+	}
+
+	// Opposite is defined for every mood, not only the categories
+	assert.Equal(t, models.Mood(0.3), models.Mood(-0.3).Opposite())
+	assert.Equal(t, models.MoodHappy, models.MoodDepressed.Opposite())
+	assert.Equal(t, models.EnergySad, models.EnergyGood.Opposite())
+}
+
+func savedTrack(id string) spotify.SavedTrack {
+	return spotify.SavedTrack{
+		FullTrack: spotify.FullTrack{
+			SimpleTrack: spotify.SimpleTrack{
+				ID:   spotify.ID(id),
+				Name: id,
+			},
+			Album: spotify.SimpleAlbum{
+				ID: "tune",
+			},
+		},
+	}
+}
+
+func mockLibrary(client *mockSpotifyClient, tracks []spotify.SavedTrack, features []*spotify.AudioFeatures) {
+	client.currentUsersTracksOpt = func(o *spotify.Options) (*spotify.SavedTrackPage, error) {
+		result := &spotify.SavedTrackPage{}
+		result.Limit = *o.Limit
+		result.Offset = *o.Offset
+		result.Total = len(tracks)
+
+		end := *o.Offset + *o.Limit
+		if end > len(tracks) {
+			end = len(tracks)
+		}
+		result.Tracks = tracks[*o.Offset:end]
+
+		return result, nil
+	}
+
+	client.getAudioFeatures = func(ids ...spotify.ID) ([]*spotify.AudioFeatures, error) {
+		result := make([]*spotify.AudioFeatures, 0)
+		for _, id := range ids {
+			for _, feature := range features {
+				if id == feature.ID {
+					result = append(result, feature)
+					break
+				}
+			}
+		}
+		return result, nil
+	}
+}
+
+func TestSyncKeepsRemovedTracksRemoved(t *testing.T) {
+	t.Parallel()
+
+	client := newMockSpotifyClient()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	dbConn.SetUserTracks(userId, &models.UserTracks{
+		UserId:        userId,
+		TrackIds:      map[string]models.MinTrack{},
+		IgnoredTracks: map[string]interface{}{"please": nil},
+	})
+	mockLibrary(
+		client,
+		[]spotify.SavedTrack{savedTrack("please"), savedTrack("hire"), savedTrack("me")},
+		[]*spotify.AudioFeatures{
+			{ID: "please", Valence: 0.2},
+			{ID: "hire", Valence: 0.3},
+			{ID: "me", Valence: 0.5},
+		},
+	)
+
+	// Run
+	_, err := api.GenerateMoodPlaylist(dbConn, userId, client, models.MoodNothing, easyParseDate("2000-01-20"), "")
+	assert.NoError(t, err)
+
+	userTracks, err := dbConn.GetUserTracks(userId)
+	assert.NoError(t, err)
+	assert.Len(t, userTracks.TrackIds, 2)
+	assert.NotContains(t, userTracks.TrackIds, "please")
+	assert.Contains(t, userTracks.IgnoredTracks, "please")
+	assert.True(t, userTracks.CompletedScan)
+}
+
+func TestConcurrentLibraryMutations(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+
+	const trackCount = 64
+	tracksMap := make(map[string]models.MinTrack)
+	for i := 0; i < trackCount; i++ {
+		id := fmt.Sprintf("track-%d", i)
+		tracksMap[id] = models.MinTrack{}
+		dbConn.PutTrack(&models.Track{Id: id, Name: id})
+	}
+	dbConn.SetUserTracks(userId, &models.UserTracks{
+		UserId:   userId,
+		TrackIds: tracksMap,
+	})
+
+	// Run
+	// Every goroutine removes a different track so none of the removals may be
+	// lost to a concurrent write of the same user's library
+	var wg sync.WaitGroup
+	for id := range tracksMap {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			assert.NoError(t, api.RemoveTrackFromUser(dbConn, userId, id))
+		}(id)
+	}
+	wg.Wait()
+
+	userTracks, err := dbConn.GetUserTracks(userId)
+	assert.NoError(t, err)
+	assert.Len(t, userTracks.TrackIds, 0)
+	assert.Len(t, userTracks.IgnoredTracks, trackCount)
+
+	// Hammer a single track from both directions, it must end up in exactly
+	// one of the library or the ignored tracks
+	for i := 0; i < 32; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			err := api.UnremoveTrackFromUser(dbConn, userId, "track-0")
+			if err != nil {
+				assert.ErrorIs(t, err, api.ErrNotFound)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			err := api.RemoveTrackFromUser(dbConn, userId, "track-0")
+			if err != nil {
+				assert.ErrorIs(t, err, api.ErrNotFound)
+			}
+		}()
+	}
+	wg.Wait()
+
+	userTracks, err = dbConn.GetUserTracks(userId)
+	assert.NoError(t, err)
+	_, inLibrary := userTracks.TrackIds["track-0"]
+	_, ignored := userTracks.IgnoredTracks["track-0"]
+	assert.NotEqual(t, inLibrary, ignored)
+	assert.Equal(t, trackCount, len(userTracks.TrackIds)+len(userTracks.IgnoredTracks))
+}
+
+func newBenchmarkLibrary(b *testing.B, size int) *db.Database {
+	cfg := &config.Config{
+		DatabasePath: path.Join(b.TempDir(), "database"),
+	}
+	dbConn := db.ConnectDb(cfg)
+
+	tracksMap := make(map[string]models.MinTrack, size)
+	for i := 0; i < size; i++ {
+		tracksMap[fmt.Sprintf("track-%d", i)] = models.MinTrack{
+			Valence: rand.Float32(),
+			Energy:  rand.Float32(),
+		}
+	}
+	dbConn.SetUserTracks(userId, &models.UserTracks{
+		UserId:        userId,
+		TrackIds:      tracksMap,
+		CompletedScan: true,
+	})
+	dbConn.SetUserFetchLock(userId)
+
+	return dbConn
+}
+
+// BenchmarkLoadFullLibrary is the work every generation did before the mood
+// index, load the whole library and bucket every track.
+func BenchmarkLoadFullLibrary(b *testing.B) {
+	dbConn := newBenchmarkLibrary(b, 10000)
+	defer dbConn.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tracks, _ := dbConn.GetLibraryTracks(userId)
+		buckets := make(map[models.Mood][]string)
+		for id, track := range tracks {
+			mood := models.TrackMood(track.Valence)
+			buckets[mood] = append(buckets[mood], id)
+		}
+	}
+}
+
+func BenchmarkLoadMoodBucket(b *testing.B) {
+	dbConn := newBenchmarkLibrary(b, 10000)
+	defer dbConn.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dbConn.GetLibraryMoodBucket(userId, models.MoodSad)
+	}
+}
+
+func BenchmarkGenerateMoodPlaylist(b *testing.B) {
+	dbConn := newBenchmarkLibrary(b, 10000)
+	defer dbConn.Close()
+	client := newMockSpotifyClient()
+	date := easyParseDate("2000-01-20")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		api.GenerateMoodPlaylist(dbConn, userId, client, models.MoodGood, date, "")
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/apitypes"
+)
+
+type ErrorCode = apitypes.ErrorCode
+
+const (
+	CodeBadRequest   = apitypes.CodeBadRequest
+	CodeInvalid      = apitypes.CodeInvalid
+	CodeUnauthorized = apitypes.CodeUnauthorized
+	CodeForbidden    = apitypes.CodeForbidden
+	CodeNotFound     = apitypes.CodeNotFound
+	CodeConflict     = apitypes.CodeConflict
+	CodeServerError  = apitypes.CodeServerError
+)
+
+type FieldError = apitypes.FieldError
+
+// Error is an error that can be shown to the client. Two Errors match with
+// errors.Is when their codes do, so the Err sentinels below match every error
+// of their kind.
+type Error struct {
+	Code    ErrorCode         `json:"code"`
+	Message string            `json:"message"`
+	Details []FieldError      `json:"details,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+	// cause is logged but never shown to the client
+	cause error
+}
+
+var (
+	ErrServerError = NewError(CodeServerError, "internal server error")
+	ErrNotFound    = NewError(CodeNotFound, "not found")
+	ErrConflict    = NewError(CodeConflict, "conflict")
+	// ErrUnauthorized means the user's spotify authorisation is no longer
+	// usable and they need to log in again.
+	ErrUnauthorized = NewError(CodeUnauthorized, "unauthorized")
+	ErrBadRequest   = NewError(CodeBadRequest, "bad request")
+	ErrForbidden    = NewError(CodeForbidden, "forbidden")
+)
+
+func NewError(code ErrorCode, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Internal hides err from the client behind a generic server error.
+func Internal(err error) *Error {
+	return &Error{Code: CodeServerError, Message: ErrServerError.Message, cause: err}
+}
+
+// Invalid is a request that failed validation.
+func Invalid(details ...FieldError) *Error {
+	return &Error{Code: CodeInvalid, Message: "request is invalid", Details: details}
+}
+
+// WithCause returns a copy of e that records err as what caused it.
+func (e *Error) WithCause(err error) *Error {
+	result := *e
+	result.cause = err
+	return &result
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.cause)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// ErrorCode lets errors from other packages, such as the API client, match
+// Errors with errors.Is without importing this package.
+func (e *Error) ErrorCode() ErrorCode {
+	return e.Code
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// AsError finds the Error in err's chain, anything else is a server error.
+func AsError(err error) *Error {
+	var result *Error
+	if errors.As(err, &result) {
+		return result
+	}
+	return Internal(err)
+}
@@ -0,0 +1,73 @@
+package api_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorMatching(t *testing.T) {
+	t.Parallel()
+
+	type scenario struct {
+		err          error
+		expectedCode api.ErrorCode
+		matches      error
+	}
+	scenarios := []scenario{
+		{
+			err:          api.ErrNotFound,
+			expectedCode: api.CodeNotFound,
+			matches:      api.ErrNotFound,
+		},
+		{
+			err:          api.NewError(api.CodeNotFound, "no playlist for that date"),
+			expectedCode: api.CodeNotFound,
+			matches:      api.ErrNotFound,
+		},
+		{
+			err:          fmt.Errorf("refreshing: %w", api.ErrUnauthorized.WithCause(errors.New("revoked"))),
+			expectedCode: api.CodeUnauthorized,
+			matches:      api.ErrUnauthorized,
+		},
+		{
+			err:          api.Invalid(api.FieldError{Field: "date", Message: "bad"}),
+			expectedCode: api.CodeInvalid,
+		},
+		{
+			err:          errors.New("disk on fire"),
+			expectedCode: api.CodeServerError,
+			matches:      api.ErrServerError,
+		},
+	}
+	for _, scenario := range scenarios {
+		// Run
+		apiErr := api.AsError(scenario.err)
+
+		assert.Equal(t, scenario.expectedCode, apiErr.Code)
+		if scenario.matches != nil {
+			assert.ErrorIs(t, apiErr, scenario.matches)
+		}
+		assert.NotErrorIs(t, apiErr, api.ErrConflict)
+	}
+}
+
+func TestErrorHidesCause(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	cause := errors.New("badger: disk on fire")
+
+	// Run
+	apiErr := api.Internal(cause)
+	encoded, err := json.Marshal(apiErr)
+
+	assert.NoError(t, err)
+	assert.NotContains(t, string(encoded), "disk on fire")
+	assert.Contains(t, apiErr.Error(), "disk on fire")
+	assert.ErrorIs(t, apiErr, cause)
+}
@@ -0,0 +1,439 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/sardap/TuneNeutral/backend/pkg/db"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+)
+
+const (
+	// GroupInvitePrefix starts every group invite code
+	GroupInvitePrefix = "tng_"
+
+	// GroupModeIntersection only uses tracks every member has
+	GroupModeIntersection = "intersection"
+	// GroupModeUnion uses tracks any member has
+	GroupModeUnion = "union"
+
+	MaxGroupMembers    = 8
+	maxGroupNameLength = 64
+)
+
+var GroupModes = []string{GroupModeIntersection, GroupModeUnion}
+
+var (
+	ErrGroupFull = NewError(CodeConflict, "group is full")
+	// ErrNoConsent is a member asking for a group playlist before letting
+	// the group use their own library
+	ErrNoConsent = NewError(CodeForbidden, "consent to the group using your library first")
+	// ErrTooFewConsents is a group playlist with only one library to draw on
+	ErrTooFewConsents = NewError(CodeConflict, "at least two members have to consent")
+)
+
+func validGroupName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxGroupNameLength {
+		return "", Invalid(FieldError{
+			Field:   "name",
+			Message: fmt.Sprintf("must be 1 to %d characters", maxGroupNameLength),
+		})
+	}
+	return name, nil
+}
+
+// CreateGroup makes a group with userId as its owner and only member. Like
+// every member the owner still has to consent before their library is used.
+func CreateGroup(dbConn *db.Database, userId, name string) (*models.Group, error) {
+	name, err := validGroupName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, Internal(err)
+	}
+
+	now := time.Now()
+	group := &models.Group{
+		Id:        hex.EncodeToString(id),
+		Name:      name,
+		OwnerId:   userId,
+		CreatedAt: now,
+		Members:   []models.GroupMember{{UserId: userId, JoinedAt: now}},
+	}
+	if err := dbConn.PutGroup(group); err != nil {
+		return nil, Internal(err)
+	}
+
+	return group, nil
+}
+
+// ListGroups returns the groups userId is in, oldest first.
+func ListGroups(dbConn *db.Database, userId string) ([]*models.Group, error) {
+	groups, err := dbConn.GetUserGroups(userId)
+	if err != nil {
+		return nil, Internal(err)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].CreatedAt.Before(groups[j].CreatedAt)
+	})
+	if groups == nil {
+		groups = []*models.Group{}
+	}
+
+	return groups, nil
+}
+
+// GetGroup returns the group, groups userId isn't in are ErrNotFound.
+func GetGroup(dbConn *db.Database, userId, groupId string) (*models.Group, error) {
+	group, err := dbConn.GetGroup(groupId)
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, Internal(err)
+	}
+
+	if group.Member(userId) == nil {
+		return nil, ErrNotFound
+	}
+
+	return group, nil
+}
+
+// updateGroup applies update to a group userId is in.
+func updateGroup(dbConn *db.Database, userId, groupId string, update func(group *models.Group) error) (*models.Group, error) {
+	group, err := dbConn.UpdateGroup(groupId, func(group *models.Group) error {
+		if group.Member(userId) == nil {
+			return ErrNotFound
+		}
+		return update(group)
+	})
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrNotFound
+	} else if _, ok := err.(*Error); ok {
+		return nil, err
+	} else if err != nil {
+		return nil, Internal(err)
+	}
+
+	return group, nil
+}
+
+// CreateGroupInvite makes a new invite code for the group, replacing the last
+// one. Only the owner can invite. The code is only available now, only its
+// hash is stored.
+func CreateGroupInvite(dbConn *db.Database, userId, groupId string) (string, error) {
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return "", Internal(err)
+	}
+	raw := GroupInvitePrefix + hex.EncodeToString(secret)
+
+	_, err := updateGroup(dbConn, userId, groupId, func(group *models.Group) error {
+		if group.OwnerId != userId {
+			return ErrForbidden
+		}
+		group.InviteHash = hashAccessToken(raw)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return raw, nil
+}
+
+// JoinGroup adds userId to the group code invites to. Joining doesn't give
+// consent, that is asked for separately.
+func JoinGroup(dbConn *db.Database, userId, code string) (*models.Group, error) {
+	if !strings.HasPrefix(code, GroupInvitePrefix) {
+		return nil, ErrNotFound
+	}
+
+	invited, err := dbConn.GetGroupByInvite(hashAccessToken(code))
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, Internal(err)
+	}
+
+	group, err := dbConn.UpdateGroup(invited.Id, func(group *models.Group) error {
+		if group.Member(userId) != nil {
+			return nil
+		}
+		if len(group.Members) >= MaxGroupMembers {
+			return ErrGroupFull
+		}
+		group.Members = append(group.Members, models.GroupMember{UserId: userId, JoinedAt: time.Now()})
+		return nil
+	})
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrNotFound
+	} else if err == ErrGroupFull {
+		return nil, err
+	} else if err != nil {
+		return nil, Internal(err)
+	}
+
+	return group, nil
+}
+
+// SetGroupConsent gives or withdraws userId's consent to the group using
+// their library.
+func SetGroupConsent(dbConn *db.Database, userId, groupId string, consent bool) (*models.Group, error) {
+	return updateGroup(dbConn, userId, groupId, func(group *models.Group) error {
+		member := group.Member(userId)
+		if !consent {
+			member.ConsentedAt = time.Time{}
+		} else if member.ConsentedAt.IsZero() {
+			member.ConsentedAt = time.Now()
+		}
+		return nil
+	})
+}
+
+// RemoveGroupMember takes memberId out of the group. Members can leave, the
+// owner can remove anyone. The group is deleted when the owner leaves.
+func RemoveGroupMember(dbConn *db.Database, userId, groupId, memberId string) error {
+	group, err := GetGroup(dbConn, userId, groupId)
+	if err != nil {
+		return err
+	}
+	if userId != memberId && userId != group.OwnerId {
+		return ErrForbidden
+	}
+	if group.Member(memberId) == nil {
+		return ErrNotFound
+	}
+
+	if memberId == group.OwnerId {
+		err := dbConn.DeleteGroup(groupId)
+		if err == badger.ErrKeyNotFound {
+			return ErrNotFound
+		} else if err != nil {
+			return Internal(err)
+		}
+		return nil
+	}
+
+	_, err = updateGroup(dbConn, userId, groupId, func(group *models.Group) error {
+		for i, member := range group.Members {
+			if member.UserId == memberId {
+				group.Members = append(group.Members[:i], group.Members[i+1:]...)
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+	return err
+}
+
+// LeaveGroups takes userId out of every group they are in.
+func LeaveGroups(dbConn *db.Database, userId string) error {
+	groups, err := ListGroups(dbConn, userId)
+	if err != nil {
+		return err
+	}
+
+	for _, group := range groups {
+		err := RemoveGroupMember(dbConn, userId, group.Id, userId)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ValidateGroupPlaylist checks a request to generate a group playlist and
+// returns its date. An empty date is today, an empty mode is
+// GroupModeIntersection. Every problem found is reported in one Invalid
+// error.
+func ValidateGroupPlaylist(mode string, moods map[string]float32, date string, now time.Time) (string, time.Time, error) {
+	var problems []FieldError
+
+	if mode == "" {
+		mode = GroupModeIntersection
+	} else if mode != GroupModeIntersection && mode != GroupModeUnion {
+		problems = append(problems, FieldError{
+			Field:   "mode",
+			Message: "must be one of " + strings.Join(GroupModes, ", "),
+		})
+	}
+
+	if len(moods) == 0 {
+		problems = append(problems, FieldError{Field: "moods", Message: "at least one member's mood is required"})
+	}
+	for _, mood := range moods {
+		if models.Mood(mood) < models.MoodMin || models.Mood(mood) > models.MoodMax {
+			problems = append(problems, FieldError{
+				Field:   "moods",
+				Message: fmt.Sprintf("must be between %g and %g", models.MoodMin, models.MoodMax),
+			})
+			break
+		}
+	}
+
+	today, _ := time.Parse(models.DateFormat, now.Format(models.DateFormat))
+	result := today
+	if date != "" {
+		parsed, err := time.Parse(models.DateFormat, date)
+		if err != nil {
+			problems = append(problems, FieldError{Field: "date", Message: "must be formatted as YYYY-MM-DD"})
+		} else if parsed.After(today.Add(futureDateSlack)) {
+			problems = append(problems, FieldError{Field: "date", Message: "can't be in the future"})
+		}
+		result = parsed
+	}
+
+	if len(problems) > 0 {
+		return "", time.Time{}, Invalid(problems...)
+	}
+
+	return mode, result, nil
+}
+
+// blendedBucket draws a mood category from the libraries of members. In
+// GroupModeIntersection a track has to be in every library.
+func blendedBucket(dbConn *db.Database, members []string, mode string) moodBucket {
+	return func(mood models.Mood) (map[string]models.MinTrack, error) {
+		var result map[string]models.MinTrack
+		for _, member := range members {
+			tracks, err := dbConn.GetLibraryMoodBucket(member, mood)
+			if err != nil {
+				return nil, err
+			}
+
+			if result == nil {
+				result = tracks
+			} else if mode == GroupModeUnion {
+				for id, track := range tracks {
+					result[id] = track
+				}
+			} else {
+				for id := range result {
+					if _, ok := tracks[id]; !ok {
+						delete(result, id)
+					}
+				}
+			}
+		}
+		return result, nil
+	}
+}
+
+// groupMoodMembers returns the members of group whose libraries can be used
+// and the mood to start from, checking userId and everyone in moods consented.
+func groupMoodMembers(group *models.Group, userId string, moods map[string]float32) ([]string, models.Mood, error) {
+	if group.Member(userId).ConsentedAt.IsZero() {
+		return nil, 0, ErrNoConsent
+	}
+
+	var members []string
+	for _, member := range group.Members {
+		if !member.ConsentedAt.IsZero() {
+			members = append(members, member.UserId)
+		}
+	}
+	if len(members) < 2 {
+		return nil, 0, ErrTooFewConsents
+	}
+
+	var total float32
+	for moodUserId, mood := range moods {
+		member := group.Member(moodUserId)
+		if member == nil || member.ConsentedAt.IsZero() {
+			return nil, 0, Invalid(FieldError{
+				Field:   "moods",
+				Message: fmt.Sprintf("%s is not a consenting member", moodUserId),
+			})
+		}
+		total += mood
+	}
+	return members, models.Mood(total / float32(len(moods))), nil
+}
+
+// GenerateGroupMoodPlaylist makes a playlist for the group from the libraries
+// of its consenting members, aimed at the average of the moods given. moods
+// is keyed by user id and only consenting members can have a say. userId has
+// to have consented themselves.
+// Only userId's library is fetched from Spotify, the other members' libraries
+// are used as they were last fetched when they used the app themselves.
+func GenerateGroupMoodPlaylist(
+	dbConn *db.Database, userId string, client SpotifyClient,
+	groupId, mode string, moods map[string]float32, date time.Time,
+) (*models.GroupPlaylist, error) {
+	group, err := GetGroup(dbConn, userId, groupId)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := groupMoodMembers(group, userId, moods); err != nil {
+		return nil, err
+	}
+
+	if err := fetchNextUserTracks(dbConn, userId, client); err != nil {
+		return nil, err
+	}
+
+	// Members may have withdrawn their consent while the library was fetched
+	group, err = GetGroup(dbConn, userId, groupId)
+	if err != nil {
+		return nil, err
+	}
+	members, startMood, err := groupMoodMembers(group, userId, moods)
+	if err != nil {
+		return nil, err
+	}
+
+	ignoreTracks := make(map[string]interface{})
+	for _, member := range members {
+		recentTracks(dbConn, member, date, ignoreTracks)
+	}
+
+	tracks, mood, err := walkMoods(startMood, blendedBucket(dbConn, members, mode), ignoreTracks)
+	if err != nil {
+		return nil, Internal(err)
+	}
+
+	result := &models.GroupPlaylist{
+		GroupId:   groupId,
+		Date:      date.Format(models.DateFormat),
+		Mode:      mode,
+		Moods:     moods,
+		StartMood: float32(startMood),
+		EndMood:   float32(models.ValenceMoodCategory(float32(mood))),
+		Members:   members,
+		Tracks:    tracks,
+		CreatedBy: userId,
+		CreatedAt: time.Now(),
+	}
+	if err := dbConn.PutGroupPlaylist(result); err != nil {
+		return nil, Internal(err)
+	}
+
+	return result, nil
+}
+
+func GetGroupPlaylist(dbConn *db.Database, userId, groupId, date string) (*models.GroupPlaylist, error) {
+	if _, err := GetGroup(dbConn, userId, groupId); err != nil {
+		return nil, err
+	}
+
+	playlist, err := dbConn.GetGroupPlaylist(groupId, date)
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, Internal(err)
+	}
+
+	return playlist, nil
+}
@@ -0,0 +1,219 @@
+package api_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/api"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify"
+)
+
+func TestValidateGroupPlaylist(t *testing.T) {
+	t.Parallel()
+
+	now := easyParseDate("2022-01-10")
+	type scenario struct {
+		mode           string
+		moods          map[string]float32
+		date           string
+		expectedMode   string
+		expectedDate   time.Time
+		expectedFields []string
+	}
+	scenarios := []scenario{
+		{
+			moods:        map[string]float32{userId: 0.2},
+			expectedMode: api.GroupModeIntersection,
+			expectedDate: now,
+		},
+		{
+			mode:         api.GroupModeUnion,
+			moods:        map[string]float32{userId: -0.5, "ringo": 0.5},
+			date:         "2022-01-01",
+			expectedMode: api.GroupModeUnion,
+			expectedDate: easyParseDate("2022-01-01"),
+		},
+		{
+			mode:           "sideways",
+			date:           "tomorrow",
+			expectedFields: []string{"mode", "moods", "date"},
+		},
+		{
+			moods:          map[string]float32{userId: 2},
+			date:           "2022-02-01",
+			expectedFields: []string{"moods", "date"},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		mode, date, err := api.ValidateGroupPlaylist(scenario.mode, scenario.moods, scenario.date, now)
+		if len(scenario.expectedFields) == 0 {
+			assert.NoError(t, err)
+			assert.Equal(t, scenario.expectedMode, mode)
+			assert.Equal(t, scenario.expectedDate, date)
+			continue
+		}
+
+		var fields []string
+		for _, problem := range err.(*api.Error).Details {
+			fields = append(fields, problem.Field)
+		}
+		assert.Equal(t, scenario.expectedFields, fields)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	client := newMockSpotifyClient()
+	const member = "ringo"
+	const outsider = "john"
+	for user, tracks := range map[string]map[string]models.MinTrack{
+		userId: {"a": {Valence: 0.1}, "b": {Valence: 0.2}, "c": {Valence: 0.9}},
+		member: {"a": {Valence: 0.1}, "c": {Valence: 0.9}, "d": {Valence: 0.15}},
+	} {
+		assert.NoError(t, dbConn.SaveLibraryPage(user, &models.LibraryScan{CompletedScan: true}, tracks))
+		assert.NoError(t, dbConn.SetUserFetchLock(user))
+	}
+	moods := map[string]float32{userId: 0.4, member: 0.2}
+	date := easyParseDate("2022-01-01")
+
+	// Run
+	_, err := api.CreateGroup(dbConn, userId, " ")
+	assert.ErrorIs(t, err, api.Invalid())
+	group, err := api.CreateGroup(dbConn, userId, " Home ")
+	assert.NoError(t, err)
+	assert.Equal(t, "Home", group.Name)
+	assert.Len(t, group.Members, 1)
+
+	_, err = api.CreateGroupInvite(dbConn, member, group.Id)
+	assert.ErrorIs(t, err, api.ErrNotFound)
+	code, err := api.CreateGroupInvite(dbConn, userId, group.Id)
+	assert.NoError(t, err)
+
+	_, err = api.JoinGroup(dbConn, member, api.GroupInvitePrefix+"nope")
+	assert.ErrorIs(t, err, api.ErrNotFound)
+	joined, err := api.JoinGroup(dbConn, member, code)
+	assert.NoError(t, err)
+	assert.Len(t, joined.Members, 2)
+	assert.True(t, joined.Member(member).ConsentedAt.IsZero())
+	joined, err = api.JoinGroup(dbConn, member, code)
+	assert.NoError(t, err)
+	assert.Len(t, joined.Members, 2)
+	_, err = api.CreateGroupInvite(dbConn, member, group.Id)
+	assert.ErrorIs(t, err, api.ErrForbidden)
+
+	// A new invite replaces the old one
+	newCode, err := api.CreateGroupInvite(dbConn, userId, group.Id)
+	assert.NoError(t, err)
+	_, err = api.JoinGroup(dbConn, outsider, code)
+	assert.ErrorIs(t, err, api.ErrNotFound)
+
+	groups, err := api.ListGroups(dbConn, member)
+	assert.NoError(t, err)
+	assert.Len(t, groups, 1)
+	_, err = api.GetGroup(dbConn, outsider, group.Id)
+	assert.ErrorIs(t, err, api.ErrNotFound)
+
+	// Nobody's library is used without consent
+	_, err = api.GenerateGroupMoodPlaylist(dbConn, userId, client, group.Id, api.GroupModeIntersection, moods, date)
+	assert.ErrorIs(t, err, api.ErrNoConsent)
+	_, err = api.SetGroupConsent(dbConn, userId, group.Id, true)
+	assert.NoError(t, err)
+	_, err = api.GenerateGroupMoodPlaylist(dbConn, userId, client, group.Id, api.GroupModeIntersection, moods, date)
+	assert.ErrorIs(t, err, api.ErrTooFewConsents)
+	consented, err := api.SetGroupConsent(dbConn, member, group.Id, true)
+	assert.NoError(t, err)
+	assert.False(t, consented.Member(member).ConsentedAt.IsZero())
+
+	playlist, err := api.GenerateGroupMoodPlaylist(dbConn, userId, client, group.Id, api.GroupModeIntersection, moods, date)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.3, playlist.StartMood, 0.0001)
+	assert.ElementsMatch(t, []string{userId, member}, playlist.Members)
+	assert.NotEmpty(t, playlist.Tracks)
+	assert.Subset(t, []string{"a", "c"}, playlist.Tracks)
+
+	playlist, err = api.GenerateGroupMoodPlaylist(dbConn, member, client, group.Id, api.GroupModeUnion, moods, date)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, playlist.Tracks)
+	assert.Subset(t, []string{"a", "b", "c", "d"}, playlist.Tracks)
+
+	_, err = api.GenerateGroupMoodPlaylist(dbConn, userId, client, group.Id, api.GroupModeUnion, map[string]float32{outsider: 0.1}, date)
+	assert.ErrorIs(t, err, api.Invalid())
+
+	stored, err := api.GetGroupPlaylist(dbConn, userId, group.Id, "2022-01-01")
+	assert.NoError(t, err)
+	assert.Equal(t, api.GroupModeUnion, stored.Mode)
+	assert.Equal(t, member, stored.CreatedBy)
+	_, err = api.GetGroupPlaylist(dbConn, outsider, group.Id, "2022-01-01")
+	assert.ErrorIs(t, err, api.ErrNotFound)
+
+	// Withdrawing consent takes the library out again
+	_, err = api.SetGroupConsent(dbConn, member, group.Id, false)
+	assert.NoError(t, err)
+	_, err = api.GenerateGroupMoodPlaylist(dbConn, userId, client, group.Id, api.GroupModeUnion, moods, date)
+	assert.ErrorIs(t, err, api.ErrTooFewConsents)
+
+	assert.ErrorIs(t, api.RemoveGroupMember(dbConn, member, group.Id, userId), api.ErrForbidden)
+	assert.NoError(t, api.RemoveGroupMember(dbConn, member, group.Id, member))
+	groups, _ = api.ListGroups(dbConn, member)
+	assert.Empty(t, groups)
+	_, err = api.JoinGroup(dbConn, member, newCode)
+	assert.NoError(t, err)
+
+	// The owner leaving deletes the group
+	assert.NoError(t, api.ClearUserData(dbConn, userId))
+	_, err = api.GetGroup(dbConn, member, group.Id)
+	assert.ErrorIs(t, err, api.ErrNotFound)
+	groups, _ = api.ListGroups(dbConn, member)
+	assert.Empty(t, groups)
+	_, err = api.JoinGroup(dbConn, outsider, newCode)
+	assert.ErrorIs(t, err, api.ErrNotFound)
+}
+
+func TestGroupConsentWithdrawnWhileGenerating(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	const member = "ringo"
+	const other = "george"
+	group, err := api.CreateGroup(dbConn, userId, "Home")
+	assert.NoError(t, err)
+	code, err := api.CreateGroupInvite(dbConn, userId, group.Id)
+	assert.NoError(t, err)
+	for _, user := range []string{userId, member, other} {
+		tracks := map[string]models.MinTrack{"a": {Valence: 0.1}, "b": {Valence: 0.2}}
+		assert.NoError(t, dbConn.SaveLibraryPage(user, &models.LibraryScan{CompletedScan: true}, tracks))
+		if user != userId {
+			_, err = api.JoinGroup(dbConn, user, code)
+			assert.NoError(t, err)
+		}
+		_, err = api.SetGroupConsent(dbConn, user, group.Id, true)
+		assert.NoError(t, err)
+	}
+
+	// The member withdraws while the caller's library is being fetched
+	client := newMockSpotifyClient()
+	mockLibrary(client, []spotify.SavedTrack{savedTrack("c")}, []*spotify.AudioFeatures{{ID: "c", Valence: 0.3}})
+	fetch := client.currentUsersTracksOpt
+	client.currentUsersTracksOpt = func(o *spotify.Options) (*spotify.SavedTrackPage, error) {
+		_, err := api.SetGroupConsent(dbConn, member, group.Id, false)
+		assert.NoError(t, err)
+		return fetch(o)
+	}
+	moods := map[string]float32{userId: 0.4, member: 0.2}
+	date := easyParseDate("2022-01-01")
+
+	// Run
+	_, err = api.GenerateGroupMoodPlaylist(dbConn, userId, client, group.Id, api.GroupModeUnion, moods, date)
+	assert.ErrorIs(t, err, api.Invalid())
+	_, err = api.GetGroupPlaylist(dbConn, userId, group.Id, "2022-01-01")
+	assert.ErrorIs(t, err, api.ErrNotFound)
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/sardap/TuneNeutral/backend/pkg/db"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+)
+
+// HistogramBins is how many bins each axis of the valence/energy histogram
+// splits spotify's 0 to 1 range into.
+const HistogramBins = 10
+
+var moodNames = map[models.Mood]string{
+	models.MoodDepressed: "depressed",
+	models.MoodSad:       "sad",
+	models.MoodNothing:   "nothing",
+	models.MoodGood:      "good",
+	models.MoodHappy:     "happy",
+}
+
+type BucketCount struct {
+	Level  float32 `json:"level"`
+	Tracks int     `json:"tracks"`
+	// Available leaves out tracks in cooldown
+	Available int `json:"available"`
+}
+
+type LibraryWarning struct {
+	Mood    float32 `json:"mood"`
+	Message string  `json:"message"`
+}
+
+// LibraryReport summarises what the generator has to pick from.
+type LibraryReport struct {
+	Tracks int `json:"tracks"`
+	// Ignored tracks were removed by the user and are never picked
+	Ignored int `json:"ignored"`
+	// Cooldown tracks were in a playlist in the last CooldownDays days and
+	// are skipped until they have sat out long enough
+	Cooldown      int  `json:"cooldown"`
+	CompletedScan bool `json:"completed_scan"`
+	// Histogram counts tracks by valence then energy, bin i covers
+	// i/HistogramBins up to (i+1)/HistogramBins
+	Histogram [][]int       `json:"histogram"`
+	Moods     []BucketCount `json:"moods"`
+	Energies  []BucketCount `json:"energies"`
+	// Warnings are the mood buckets too thin to fill a playlist
+	Warnings []LibraryWarning `json:"warnings"`
+}
+
+func histogramBin(value float32) int {
+	bin := int(value * HistogramBins)
+	if bin < 0 {
+		return 0
+	} else if bin >= HistogramBins {
+		return HistogramBins - 1
+	}
+	return bin
+}
+
+// ComputeLibraryReport summarises userTracks. cooldown is the ids of tracks
+// in recent playlists.
+func ComputeLibraryReport(userTracks *models.UserTracks, cooldown map[string]bool) *LibraryReport {
+	result := &LibraryReport{
+		Tracks:        len(userTracks.TrackIds),
+		Ignored:       len(userTracks.IgnoredTracks),
+		CompletedScan: userTracks.CompletedScan,
+		Histogram:     make([][]int, HistogramBins),
+		Moods:         make([]BucketCount, len(models.Moods)),
+		Energies:      make([]BucketCount, len(models.Energies)),
+		Warnings:      []LibraryWarning{},
+	}
+
+	for i := range result.Histogram {
+		result.Histogram[i] = make([]int, HistogramBins)
+	}
+	for i, mood := range models.Moods {
+		result.Moods[i].Level = float32(mood)
+	}
+	for i, energy := range models.Energies {
+		result.Energies[i].Level = float32(energy)
+	}
+
+	for id, track := range userTracks.TrackIds {
+		result.Histogram[histogramBin(track.Valence)][histogramBin(track.Energy)]++
+
+		mood := &result.Moods[models.TrackMood(track.Valence).Index()]
+		energy := &result.Energies[models.TrackEnergy(track.Energy).Index()]
+		mood.Tracks++
+		energy.Tracks++
+
+		if cooldown[id] {
+			result.Cooldown++
+			continue
+		}
+		mood.Available++
+		energy.Available++
+	}
+
+	for _, bucket := range result.Moods {
+		if bucket.Available >= PlaylistLength {
+			continue
+		}
+
+		mood := models.Mood(bucket.Level)
+		result.Warnings = append(result.Warnings, LibraryWarning{
+			Mood: bucket.Level,
+			Message: fmt.Sprintf(
+				"only %d tracks feel %s right now, playlists for feeling %s will repeat or borrow from other moods",
+				bucket.Available, moodNames[mood], moodNames[mood.Opposite()],
+			),
+		})
+	}
+
+	return result
+}
+
+// GetLibraryReport summarises the user's library as of today.
+func GetLibraryReport(dbConn *db.Database, userId string, now time.Time) (*LibraryReport, error) {
+	userTracks, err := dbConn.GetUserTracks(userId)
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, Internal(err)
+	}
+
+	today, _ := time.Parse(models.DateFormat, now.Format(models.DateFormat))
+	playlists, err := dbConn.GetMoodPlaylitsBetweenDates(userId, today.AddDate(0, 0, -CooldownDays), today)
+	if err != nil {
+		return nil, Internal(err)
+	}
+
+	cooldown := map[string]bool{}
+	for _, playlist := range playlists {
+		for _, trackId := range playlist.Tracks {
+			cooldown[trackId] = true
+		}
+	}
+
+	return ComputeLibraryReport(userTracks, cooldown), nil
+}
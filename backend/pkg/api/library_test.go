@@ -0,0 +1,90 @@
+package api_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/api"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetLibraryReport(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	now := time.Date(2022, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	_, err := api.GetLibraryReport(dbConn, userId, now)
+	assert.ErrorIs(t, err, api.ErrNotFound)
+
+	library := map[string]models.MinTrack{"removed": {Valence: 0.5, Energy: 0.5}}
+	for i := 0; i < 12; i++ {
+		library[fmt.Sprintf("happy%d", i)] = models.MinTrack{Valence: 0.85, Energy: 0.95}
+	}
+	for i := 0; i < 3; i++ {
+		library[fmt.Sprintf("depressed%d", i)] = models.MinTrack{Valence: 0.15, Energy: 0.55}
+	}
+	assert.NoError(t, dbConn.SaveLibraryPage(userId, &models.LibraryScan{CompletedScan: true}, library))
+	assert.NoError(t, dbConn.RemoveLibraryTrack(userId, "removed"))
+
+	dbConn.SetMoodPlaylist(userId, &models.MoodPlaylist{
+		Date:   easyParseDate("2022-01-07"),
+		Tracks: []string{"happy0", "happy1", "happy2"},
+	})
+	// Long enough ago to have sat out its cooldown
+	dbConn.SetMoodPlaylist(userId, &models.MoodPlaylist{
+		Date:   easyParseDate("2022-01-02"),
+		Tracks: []string{"happy3", "depressed0"},
+	})
+
+	// Run
+	report, err := api.GetLibraryReport(dbConn, userId, now)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 15, report.Tracks)
+	assert.Equal(t, 1, report.Ignored)
+	assert.Equal(t, 3, report.Cooldown)
+	assert.True(t, report.CompletedScan)
+
+	assert.Len(t, report.Histogram, api.HistogramBins)
+	assert.Equal(t, 12, report.Histogram[8][9])
+	assert.Equal(t, 3, report.Histogram[1][5])
+
+	assert.Equal(t, []api.BucketCount{
+		{Level: float32(models.MoodDepressed), Tracks: 3, Available: 3},
+		{Level: float32(models.MoodSad)},
+		{Level: float32(models.MoodNothing)},
+		{Level: float32(models.MoodGood)},
+		{Level: float32(models.MoodHappy), Tracks: 12, Available: 9},
+	}, report.Moods)
+	assert.Equal(t, []api.BucketCount{
+		{Level: float32(models.EnergyDepressed)},
+		{Level: float32(models.EnergySad)},
+		{Level: float32(models.EnergyNothing), Tracks: 3, Available: 3},
+		{Level: float32(models.EnergyGood)},
+		{Level: float32(models.EnergyHappy), Tracks: 12, Available: 9},
+	}, report.Energies)
+
+	// Every bucket is too thin, even happy once its cooldown is counted
+	var warned []float32
+	for _, warning := range report.Warnings {
+		warned = append(warned, warning.Mood)
+		assert.NotEmpty(t, warning.Message)
+	}
+	assert.Equal(t, []float32{-0.25, -0.125, 0, 0.125, 0.25}, warned)
+
+	// A bucket with enough tracks is not warned about
+	for i := 3; i < 10; i++ {
+		library := map[string]models.MinTrack{fmt.Sprintf("depressed%d", i): {Valence: 0.15, Energy: 0.55}}
+		scan, _ := dbConn.GetLibraryScan(userId)
+		assert.NoError(t, dbConn.SaveLibraryPage(userId, scan, library))
+	}
+	report, err = api.GetLibraryReport(dbConn, userId, now)
+	assert.NoError(t, err)
+	assert.Len(t, report.Warnings, 4)
+	assert.NotEqual(t, float32(models.MoodDepressed), report.Warnings[0].Mood)
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/sardap/TuneNeutral/backend/pkg/apitypes"
+	"github.com/sardap/TuneNeutral/backend/pkg/db"
+	"github.com/zmb3/spotify"
+)
+
+type Feature = apitypes.Feature
+
+const (
+	FeatureJournal  = apitypes.FeatureJournal
+	FeatureGenerate = apitypes.FeatureGenerate
+	FeatureSync     = apitypes.FeatureSync
+	FeaturePlayback = apitypes.FeaturePlayback
+)
+
+var Features = []Feature{FeatureJournal, FeatureGenerate, FeatureSync, FeaturePlayback}
+
+var featureScopes = map[Feature][]string{
+	FeatureJournal:  {},
+	FeatureGenerate: {spotify.ScopeUserLibraryRead},
+	FeatureSync:     {spotify.ScopePlaylistModifyPublic},
+	FeaturePlayback: {spotify.ScopeUserModifyPlaybackState},
+}
+
+// legacyScopes is what every login asked for before scopes were tied to
+// features. Users who logged in back then have granted all of them.
+var legacyScopes = []string{
+	spotify.ScopePlaylistReadPrivate,
+	spotify.ScopePlaylistReadCollaborative,
+	spotify.ScopeUserLibraryRead,
+	spotify.ScopeUserReadPrivate,
+	spotify.ScopeUserReadPlaybackState,
+	spotify.ScopeUserModifyPlaybackState,
+	spotify.ScopeUserTopRead,
+	spotify.ScopeStreaming,
+	spotify.ScopePlaylistModifyPublic,
+}
+
+func ParseFeature(value string) (Feature, error) {
+	feature := Feature(value)
+	if _, ok := featureScopes[feature]; !ok {
+		return "", Invalid(FieldError{Field: "feature", Message: fmt.Sprintf("unknown feature %s", value)})
+	}
+	return feature, nil
+}
+
+// mergeScopes returns the sorted union of every scope list.
+func mergeScopes(lists ...[]string) []string {
+	set := map[string]bool{}
+	for _, list := range lists {
+		for _, scope := range list {
+			set[scope] = true
+		}
+	}
+
+	result := make([]string, 0, len(set))
+	for scope := range set {
+		result = append(result, scope)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// FeatureScopes is every scope the features need.
+func FeatureScopes(features ...Feature) []string {
+	lists := make([][]string, 0, len(features))
+	for _, feature := range features {
+		lists = append(lists, featureScopes[feature])
+	}
+	return mergeScopes(lists...)
+}
+
+// HasFeature reports whether granted covers every scope feature needs.
+func HasFeature(granted []string, feature Feature) bool {
+	set := map[string]bool{}
+	for _, scope := range granted {
+		set[scope] = true
+	}
+
+	for _, scope := range featureScopes[feature] {
+		if !set[scope] {
+			return false
+		}
+	}
+	return true
+}
+
+// EnabledFeatures is every feature granted covers.
+func EnabledFeatures(granted []string) []Feature {
+	var result []Feature
+	for _, feature := range Features {
+		if HasFeature(granted, feature) {
+			result = append(result, feature)
+		}
+	}
+	return result
+}
+
+// GrantedScopes returns the scopes the user has granted. Users without a
+// record logged in before scopes were recorded and granted legacyScopes.
+func GrantedScopes(dbConn *db.Database, userId string) ([]string, error) {
+	scopes, err := dbConn.GetUserScopes(userId)
+	if err == badger.ErrKeyNotFound {
+		return legacyScopes, nil
+	} else if err != nil {
+		return nil, Internal(err)
+	}
+
+	// Nothing granted decodes as nil
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	return scopes, nil
+}
+
+// RequestScopes is what to ask for so the user ends up with features on top
+// of the features they have already enabled. A new token only carries the
+// scopes it was requested with so those features' scopes must be asked for
+// again. Only feature scopes are asked for, anything else the user granted,
+// including legacyScopes, is left to drop off.
+func RequestScopes(dbConn *db.Database, userId string, features ...Feature) ([]string, error) {
+	if userId == "" {
+		return FeatureScopes(features...), nil
+	}
+
+	granted, err := dbConn.GetUserScopes(userId)
+	if err != nil && err != badger.ErrKeyNotFound {
+		return nil, Internal(err)
+	}
+
+	return FeatureScopes(append(EnabledFeatures(granted), features...)...), nil
+}
+
+func SetGrantedScopes(dbConn *db.Database, userId string, scopes []string) error {
+	if err := dbConn.SetUserScopes(userId, mergeScopes(scopes)); err != nil {
+		return Internal(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"sort"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/sardap/TuneNeutral/backend/pkg/db"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+	"github.com/sardap/TuneNeutral/backend/pkg/search"
+)
+
+// SnippetWidth is roughly how many bytes of a note a search result shows.
+const SnippetWidth = 160
+
+// SearchQuery is a validated note search.
+type SearchQuery struct {
+	Query search.Query
+	// From and To are inclusive dates, empty is unbounded
+	From  string
+	To    string
+	Limit int
+}
+
+type SearchResult struct {
+	Date      string           `json:"date"`
+	StartMood float32          `json:"start_mood"`
+	Snippet   []search.Segment `json:"snippet"`
+}
+
+// SearchNotes returns the user's playlists whose notes match query, newest
+// first.
+func SearchNotes(dbConn *db.Database, userId string, query SearchQuery) ([]SearchResult, error) {
+	candidates, err := dbConn.SearchNotes(userId, query.Query.AllTerms(), query.From, query.To)
+	if err != nil {
+		return nil, Internal(err)
+	}
+
+	var dates []string
+	for date, positions := range candidates {
+		if query.Query.Matches(positions) {
+			dates = append(dates, date)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dates)))
+	if len(dates) > query.Limit {
+		dates = dates[:query.Limit]
+	}
+
+	result := []SearchResult{}
+	for _, date := range dates {
+		playlist, err := dbConn.GetMoodPlaylist(userId, date)
+		if err == badger.ErrKeyNotFound {
+			// Deleted since the index was read
+			continue
+		} else if err != nil {
+			return nil, Internal(err)
+		}
+
+		note := ""
+		if playlist.Note != nil {
+			note = *playlist.Note
+		}
+		result = append(result, SearchResult{
+			Date:      playlist.Date.Format(models.DateFormat),
+			StartMood: playlist.StartMood,
+			Snippet:   search.Snippet(note, query.Query, SnippetWidth),
+		})
+	}
+
+	return result, nil
+}
+
+// UpdatePlaylistNote replaces the note of the user's playlist on date.
+func UpdatePlaylistNote(dbConn *db.Database, userId, date, note string) (*models.MoodPlaylist, error) {
+	if err := ValidateNote(note); err != nil {
+		return nil, err
+	}
+
+	playlist, err := dbConn.UpdateMoodPlaylistNote(userId, date, note)
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, Internal(err)
+	}
+
+	return playlist, nil
+}
@@ -0,0 +1,95 @@
+package api_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/api"
+	"github.com/sardap/TuneNeutral/backend/pkg/search"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify"
+)
+
+func TestSearchNotes(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	client := newMockSpotifyClient()
+	var tracks []spotify.SavedTrack
+	var features []*spotify.AudioFeatures
+	for i := 0; i < 20; i++ {
+		id := fmt.Sprintf("track%d", i)
+		tracks = append(tracks, savedTrack(id))
+		features = append(features, &spotify.AudioFeatures{ID: spotify.ID(id), Valence: float32(i) / 20})
+	}
+	mockLibrary(client, tracks, features)
+
+	// Generating indexes the note
+	for date, note := range map[string]string{
+		"2022-01-01": "Long walk by the river, felt calm",
+		"2022-01-02": "Walk to work was long",
+		"2022-01-03": "Stayed in",
+	} {
+		_, err := api.GenerateMoodPlaylist(dbConn, userId, client, 0.2, easyParseDate(date), note)
+		assert.NoError(t, err)
+	}
+
+	type scenario struct {
+		q             string
+		from          string
+		to            string
+		expectedDates []string
+	}
+	scenarios := []scenario{
+		{q: "walk long", expectedDates: []string{"2022-01-02", "2022-01-01"}},
+		{q: `"long walk"`, expectedDates: []string{"2022-01-01"}},
+		{q: "walk", from: "2022-01-02", expectedDates: []string{"2022-01-02"}},
+		{q: "walk", to: "2022-01-01", expectedDates: []string{"2022-01-01"}},
+		{q: "swim", expectedDates: []string{}},
+	}
+	for _, scenario := range scenarios {
+		query, err := api.ValidateSearchQuery(scenario.q, scenario.from, scenario.to, "")
+		assert.NoError(t, err)
+
+		// Run
+		results, err := api.SearchNotes(dbConn, userId, query)
+
+		assert.NoError(t, err)
+		dates := []string{}
+		for _, result := range results {
+			dates = append(dates, result.Date)
+		}
+		assert.Equal(t, scenario.expectedDates, dates, scenario.q)
+	}
+
+	query, _ := api.ValidateSearchQuery("calm", "", "", "")
+	results, _ := api.SearchNotes(dbConn, userId, query)
+	assert.Equal(t, []search.Segment{
+		{Text: "Long walk by the river, felt "},
+		{Text: "calm", Match: true},
+	}, results[0].Snippet)
+	assert.Equal(t, float32(0.2), results[0].StartMood)
+
+	// Editing reindexes the note
+	playlist, err := api.UpdatePlaylistNote(dbConn, userId, "2022-01-03", "Short walk")
+	assert.NoError(t, err)
+	assert.Equal(t, "Short walk", *playlist.Note)
+	query, _ = api.ValidateSearchQuery("walk", "", "", "1")
+	results, _ = api.SearchNotes(dbConn, userId, query)
+	assert.Len(t, results, 1)
+	assert.Equal(t, "2022-01-03", results[0].Date)
+
+	_, err = api.UpdatePlaylistNote(dbConn, userId, "2022-01-04", "nope")
+	assert.ErrorIs(t, err, api.ErrNotFound)
+	_, err = api.UpdatePlaylistNote(dbConn, userId, "2022-01-03", string(make([]rune, api.MaxNoteLength+1)))
+	assert.ErrorIs(t, err, api.Invalid())
+
+	_, err = api.ValidateSearchQuery(`"" ?`, "2022-02-01", "2022-01-01", "101")
+	var fields []string
+	for _, detail := range api.AsError(err).Details {
+		fields = append(fields, detail.Field)
+	}
+	assert.Equal(t, []string{"q", "to", "limit"}, fields)
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/sardap/TuneNeutral/backend/pkg/db"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+)
+
+// SessionHandle is the public name of a session. The session id itself is
+// never handed out since it is what the session cookie carries.
+func SessionHandle(sessionId string) string {
+	sum := sha256.Sum256([]byte(sessionId))
+	return hex.EncodeToString(sum[:8])
+}
+
+func ListSessions(dbConn *db.Database, userId string) ([]*models.Session, error) {
+	sessions, err := dbConn.GetUserSessions(userId)
+	if err != nil {
+		return nil, Internal(err)
+	}
+
+	return sessions, nil
+}
+
+func RevokeSession(dbConn *db.Database, userId string, handle string) error {
+	sessions, err := dbConn.GetUserSessions(userId)
+	if err != nil {
+		return Internal(err)
+	}
+
+	for _, session := range sessions {
+		if SessionHandle(session.Id) != handle {
+			continue
+		}
+
+		err := dbConn.DeleteSession(session.Id)
+		if err == badger.ErrKeyNotFound {
+			return ErrNotFound
+		} else if err != nil {
+			return Internal(err)
+		}
+		return nil
+	}
+
+	return ErrNotFound
+}
+
+func RevokeAllSessions(dbConn *db.Database, userId string) error {
+	if err := dbConn.DeleteUserSessions(userId); err != nil {
+		return Internal(err)
+	}
+
+	return nil
+}
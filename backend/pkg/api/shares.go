@@ -0,0 +1,134 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/sardap/TuneNeutral/backend/pkg/db"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+)
+
+const (
+	// ShareTokenPrefix starts every share token so they can't be mistaken
+	// for access tokens
+	ShareTokenPrefix = "tns_"
+
+	MaxShareDays = 365
+)
+
+// CreateShareLink makes the playlist on date readable by anyone with the
+// returned token. The token is only available now, only its hash is stored.
+// A nil expiresInDays never expires.
+func CreateShareLink(dbConn *db.Database, userId, date string, hideNote bool, expiresInDays *int) (string, *models.ShareLink, error) {
+	if expiresInDays != nil && (*expiresInDays < 1 || *expiresInDays > MaxShareDays) {
+		return "", nil, Invalid(FieldError{
+			Field:   "expires_in_days",
+			Message: fmt.Sprintf("must be between 1 and %d", MaxShareDays),
+		})
+	}
+
+	playlist, err := GetPlaylist(dbConn, userId, date)
+	if err != nil {
+		return "", nil, err
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, Internal(err)
+	}
+	raw := ShareTokenPrefix + hex.EncodeToString(secret)
+	hash := hashAccessToken(raw)
+
+	now := time.Now()
+	link := &models.ShareLink{
+		Id:        hash[:16],
+		UserId:    userId,
+		Date:      playlist.Date.Format(models.DateFormat),
+		HideNote:  hideNote,
+		CreatedAt: now,
+	}
+	if expiresInDays != nil {
+		link.ExpiresAt = now.AddDate(0, 0, *expiresInDays)
+	}
+	if err := dbConn.PutShareLink(hash, link); err != nil {
+		return "", nil, Internal(err)
+	}
+
+	return raw, link, nil
+}
+
+// ListShareLinks returns userId's share links, newest first. A non empty
+// date only returns the links to that day's playlist.
+func ListShareLinks(dbConn *db.Database, userId, date string) ([]*models.ShareLink, error) {
+	links, err := dbConn.GetUserShareLinks(userId)
+	if err != nil {
+		return nil, Internal(err)
+	}
+
+	result := []*models.ShareLink{}
+	for _, link := range links {
+		if date == "" || link.Date == date {
+			result = append(result, link)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+
+	return result, nil
+}
+
+func RevokeShareLink(dbConn *db.Database, userId, shareId string) error {
+	err := dbConn.DeleteShareLink(userId, shareId)
+	if err == badger.ErrKeyNotFound {
+		return ErrNotFound
+	} else if err != nil {
+		return Internal(err)
+	}
+
+	return nil
+}
+
+// OpenShareLink returns the share link raw is the token of and the playlist
+// it shares, counting the visit. Unknown, expired and dangling links are all
+// ErrNotFound so a visitor can't tell them apart. The note is removed when
+// the link hides it.
+func OpenShareLink(dbConn *db.Database, raw string, now time.Time) (*models.ShareLink, *models.MoodPlaylist, error) {
+	if !strings.HasPrefix(raw, ShareTokenPrefix) {
+		return nil, nil, ErrNotFound
+	}
+
+	hash := hashAccessToken(raw)
+	link, err := dbConn.GetShareLink(hash)
+	if err == badger.ErrKeyNotFound {
+		return nil, nil, ErrNotFound
+	} else if err != nil {
+		return nil, nil, Internal(err)
+	}
+	if !link.ExpiresAt.IsZero() && !now.Before(link.ExpiresAt) {
+		return nil, nil, ErrNotFound
+	}
+
+	playlist, err := GetPlaylist(dbConn, link.UserId, link.Date)
+	if err != nil {
+		return nil, nil, err
+	}
+	if link.HideNote {
+		playlist.Note = nil
+	}
+
+	link, err = dbConn.RecordShareAccess(hash, now)
+	if err == badger.ErrKeyNotFound {
+		// Revoked while it was being opened
+		return nil, nil, ErrNotFound
+	} else if err != nil {
+		return nil, nil, Internal(err)
+	}
+
+	return link, playlist, nil
+}
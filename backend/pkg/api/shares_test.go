@@ -0,0 +1,81 @@
+package api_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/api"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShareLinks(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	note := "a quiet day"
+	assert.NoError(t, dbConn.SetMoodPlaylist(userId, &models.MoodPlaylist{
+		Date:      easyParseDate("2022-01-01"),
+		StartMood: -0.2,
+		Note:      &note,
+		Tracks:    []string{"a", "b"},
+	}))
+	days := 7
+
+	// Run
+	_, _, err := api.CreateShareLink(dbConn, userId, "2022-01-02", false, nil)
+	assert.ErrorIs(t, err, api.ErrNotFound)
+	for _, invalid := range []int{0, api.MaxShareDays + 1} {
+		invalid := invalid
+		_, _, err = api.CreateShareLink(dbConn, userId, "2022-01-01", false, &invalid)
+		assert.ErrorIs(t, err, api.Invalid())
+	}
+
+	raw, link, err := api.CreateShareLink(dbConn, userId, "2022-01-01", false, nil)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(raw, api.ShareTokenPrefix))
+	assert.Equal(t, "2022-01-01", link.Date)
+	assert.True(t, link.ExpiresAt.IsZero())
+
+	hiddenRaw, hidden, err := api.CreateShareLink(dbConn, userId, "2022-01-01", true, &days)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().AddDate(0, 0, days), hidden.ExpiresAt, time.Minute)
+
+	opened, playlist, err := api.OpenShareLink(dbConn, raw, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, opened.Accesses)
+	assert.Equal(t, &note, playlist.Note)
+	assert.Equal(t, []string{"a", "b"}, playlist.Tracks)
+	opened, _, _ = api.OpenShareLink(dbConn, raw, time.Now())
+	assert.Equal(t, 2, opened.Accesses)
+
+	_, playlist, err = api.OpenShareLink(dbConn, hiddenRaw, time.Now())
+	assert.NoError(t, err)
+	assert.Nil(t, playlist.Note)
+	_, _, err = api.OpenShareLink(dbConn, hiddenRaw, time.Now().AddDate(0, 0, days+1))
+	assert.ErrorIs(t, err, api.ErrNotFound)
+	_, _, err = api.OpenShareLink(dbConn, api.ShareTokenPrefix+"unknown", time.Now())
+	assert.ErrorIs(t, err, api.ErrNotFound)
+
+	links, err := api.ListShareLinks(dbConn, userId, "")
+	assert.NoError(t, err)
+	assert.Len(t, links, 2)
+	assert.Equal(t, hidden.Id, links[0].Id)
+	assert.Equal(t, 2, links[1].Accesses)
+	links, _ = api.ListShareLinks(dbConn, userId, "2022-01-02")
+	assert.Empty(t, links)
+
+	assert.NoError(t, api.RevokeShareLink(dbConn, userId, link.Id))
+	assert.ErrorIs(t, api.RevokeShareLink(dbConn, userId, link.Id), api.ErrNotFound)
+	_, _, err = api.OpenShareLink(dbConn, raw, time.Now())
+	assert.ErrorIs(t, err, api.ErrNotFound)
+
+	assert.NoError(t, api.ClearUserData(dbConn, userId))
+	_, _, err = api.OpenShareLink(dbConn, hiddenRaw, time.Now())
+	assert.ErrorIs(t, err, api.ErrNotFound)
+	links, _ = api.ListShareLinks(dbConn, userId, "")
+	assert.Empty(t, links)
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/db"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+)
+
+// MoodTarget is the mood every playlist steers towards.
+const MoodTarget = models.MoodNothing
+
+type RollingAverage struct {
+	Date    string  `json:"date"`
+	Average float32 `json:"average"`
+}
+
+type WeekdayStats struct {
+	Weekday    string   `json:"weekday"`
+	LoggedDays int      `json:"logged_days"`
+	Average    *float32 `json:"average"`
+}
+
+type MoodCount struct {
+	Mood       float32 `json:"mood"`
+	LoggedDays int     `json:"logged_days"`
+}
+
+type Streaks struct {
+	// Current counts back from the end of the range, a range ending today
+	// isn't broken until tomorrow
+	Current int `json:"current"`
+	Longest int `json:"longest"`
+}
+
+// MoodStats summarises the moods a user started their playlists with. Averages
+// are nil when nothing was logged.
+type MoodStats struct {
+	From       string   `json:"from"`
+	To         string   `json:"to"`
+	Window     int      `json:"window"`
+	LoggedDays int      `json:"logged_days"`
+	Average    *float32 `json:"average"`
+	// AverageTargetDistance is how far from MoodTarget the days started
+	AverageTargetDistance *float32 `json:"average_target_distance"`
+	// RollingAverage has a point for every logged day averaging the logged
+	// days in the window ending on it
+	RollingAverage []RollingAverage `json:"rolling_average"`
+	// Weekdays starts on Monday
+	Weekdays []WeekdayStats `json:"weekdays"`
+	Streaks  Streaks        `json:"streaks"`
+	// Distribution counts logged days by the models.Moods category nearest
+	// their mood
+	Distribution []MoodCount `json:"distribution"`
+}
+
+func average(total float64, count int) *float32 {
+	if count == 0 {
+		return nil
+	}
+	result := float32(total / float64(count))
+	return &result
+}
+
+// ComputeMoodStats summarises the playlists dated from from to to inclusive,
+// both days at midnight UTC. Playlists outside the range are ignored.
+func ComputeMoodStats(playlists []*models.MoodPlaylist, from, to time.Time, window int) *MoodStats {
+	days := int(to.Sub(from)/(24*time.Hour)) + 1
+	moods := make([]*float32, days)
+	for _, playlist := range playlists {
+		day := int(playlist.Date.Sub(from) / (24 * time.Hour))
+		if playlist.Date.Before(from) || day >= days {
+			continue
+		}
+		mood := playlist.StartMood
+		moods[day] = &mood
+	}
+
+	result := &MoodStats{
+		From:           from.Format(models.DateFormat),
+		To:             to.Format(models.DateFormat),
+		Window:         window,
+		RollingAverage: []RollingAverage{},
+		Weekdays:       make([]WeekdayStats, 7),
+		Distribution:   make([]MoodCount, len(models.Moods)),
+	}
+
+	for i := range result.Weekdays {
+		result.Weekdays[i].Weekday = strings.ToLower(time.Weekday((i + 1) % 7).String())
+	}
+	for i, mood := range models.Moods {
+		result.Distribution[i].Mood = float32(mood)
+	}
+
+	var total, distance float64
+	weekdayTotals := make([]float64, 7)
+	run := 0
+	for day, mood := range moods {
+		if mood == nil {
+			run = 0
+			continue
+		}
+
+		result.LoggedDays++
+		total += float64(*mood)
+		distance += math.Abs(float64(*mood - float32(MoodTarget)))
+
+		run++
+		if run > result.Streaks.Longest {
+			result.Streaks.Longest = run
+		}
+
+		date := from.AddDate(0, 0, day)
+		weekday := (int(date.Weekday()) + 6) % 7
+		result.Weekdays[weekday].LoggedDays++
+		weekdayTotals[weekday] += float64(*mood)
+
+		result.Distribution[models.ValenceMoodCategory(*mood).Index()].LoggedDays++
+
+		var windowTotal float64
+		windowDays := 0
+		for i := day; i >= 0 && i > day-window; i-- {
+			if moods[i] != nil {
+				windowTotal += float64(*moods[i])
+				windowDays++
+			}
+		}
+		result.RollingAverage = append(result.RollingAverage, RollingAverage{
+			Date:    date.Format(models.DateFormat),
+			Average: *average(windowTotal, windowDays),
+		})
+	}
+
+	result.Average = average(total, result.LoggedDays)
+	result.AverageTargetDistance = average(distance, result.LoggedDays)
+	for i := range result.Weekdays {
+		result.Weekdays[i].Average = average(weekdayTotals[i], result.Weekdays[i].LoggedDays)
+	}
+
+	end := days - 1
+	if end > 0 && moods[end] == nil {
+		end--
+	}
+	for day := end; day >= 0 && moods[day] != nil; day-- {
+		result.Streaks.Current++
+	}
+
+	return result
+}
+
+// GetMoodStats summarises the user's playlists from from to to inclusive. A
+// non empty tag only counts entries with that tag.
+func GetMoodStats(dbConn *db.Database, userId string, from, to time.Time, window int, tag string) (*MoodStats, error) {
+	playlists, _, err := dbConn.QueryMoodPlaylists(userId, db.MoodPlaylistQuery{
+		From: from.Format(models.DateFormat),
+		To:   to.Format(models.DateFormat),
+		Tag:  tag,
+	})
+	if err != nil {
+		return nil, Internal(err)
+	}
+
+	return ComputeMoodStats(playlists, from, to, window), nil
+}
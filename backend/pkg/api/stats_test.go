@@ -0,0 +1,95 @@
+package api_test
+
+import (
+	"testing"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/api"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeMoodStats(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	var playlists []*models.MoodPlaylist
+	for date, mood := range map[string]float32{
+		"2022-01-03": 0.25,
+		"2022-01-04": -0.1,
+		"2022-01-06": 0.5,
+		"2022-01-08": 0,
+		"2022-01-09": -0.25,
+		// Outside the range
+		"2022-01-12": 0.5,
+	} {
+		playlists = append(playlists, &models.MoodPlaylist{Date: easyParseDate(date), StartMood: mood})
+	}
+
+	// Run
+	stats := api.ComputeMoodStats(playlists, easyParseDate("2022-01-03"), easyParseDate("2022-01-09"), 2)
+
+	assert.Equal(t, "2022-01-03", stats.From)
+	assert.Equal(t, "2022-01-09", stats.To)
+	assert.Equal(t, 5, stats.LoggedDays)
+	assert.InDelta(t, 0.08, *stats.Average, 0.0001)
+	assert.InDelta(t, 0.22, *stats.AverageTargetDistance, 0.0001)
+
+	expectedRolling := map[string]float32{
+		"2022-01-03": 0.25,
+		"2022-01-04": 0.075,
+		// The day before wasn't logged
+		"2022-01-06": 0.5,
+		"2022-01-08": 0,
+		"2022-01-09": -0.125,
+	}
+	assert.Len(t, stats.RollingAverage, len(expectedRolling))
+	for _, point := range stats.RollingAverage {
+		assert.InDelta(t, expectedRolling[point.Date], point.Average, 0.0001, point.Date)
+	}
+
+	expectedWeekdays := []struct {
+		weekday string
+		days    int
+		average float32
+	}{
+		{"monday", 1, 0.25},
+		{"tuesday", 1, -0.1},
+		{"wednesday", 0, 0},
+		{"thursday", 1, 0.5},
+		{"friday", 0, 0},
+		{"saturday", 1, 0},
+		{"sunday", 1, -0.25},
+	}
+	for i, expected := range expectedWeekdays {
+		weekday := stats.Weekdays[i]
+		assert.Equal(t, expected.weekday, weekday.Weekday)
+		assert.Equal(t, expected.days, weekday.LoggedDays, expected.weekday)
+		if expected.days == 0 {
+			assert.Nil(t, weekday.Average, expected.weekday)
+		} else {
+			assert.InDelta(t, expected.average, *weekday.Average, 0.0001, expected.weekday)
+		}
+	}
+
+	assert.Equal(t, api.Streaks{Current: 2, Longest: 2}, stats.Streaks)
+
+	var counts []int
+	for i, count := range stats.Distribution {
+		assert.Equal(t, float32(models.Moods[i]), count.Mood)
+		counts = append(counts, count.LoggedDays)
+	}
+	assert.Equal(t, []int{1, 1, 1, 0, 2}, counts)
+
+	// Today not being logged yet doesn't break the current streak
+	stats = api.ComputeMoodStats(playlists, easyParseDate("2022-01-03"), easyParseDate("2022-01-10"), 2)
+	assert.Equal(t, 2, stats.Streaks.Current)
+	stats = api.ComputeMoodStats(playlists, easyParseDate("2022-01-03"), easyParseDate("2022-01-11"), 2)
+	assert.Equal(t, 0, stats.Streaks.Current)
+
+	stats = api.ComputeMoodStats(nil, easyParseDate("2022-01-03"), easyParseDate("2022-01-03"), 7)
+	assert.Equal(t, 0, stats.LoggedDays)
+	assert.Nil(t, stats.Average)
+	assert.Nil(t, stats.AverageTargetDistance)
+	assert.Empty(t, stats.RollingAverage)
+	assert.Len(t, stats.Weekdays, 7)
+}
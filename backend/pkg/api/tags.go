@@ -0,0 +1,197 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/sardap/TuneNeutral/backend/pkg/db"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+)
+
+const (
+	MaxTagsPerEntry = 20
+	// MaxTagPartLength bounds a tag's key and value separately
+	MaxTagPartLength = 32
+)
+
+func validTagPart(part string) bool {
+	for _, r := range part {
+		if !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != ' ' && r != '-' && r != '_' {
+			return false
+		}
+	}
+	return utf8.RuneCountInString(part) <= MaxTagPartLength
+}
+
+// ParseTag reads a tag written as key or key:value and reports problems
+// against field.
+func ParseTag(text, field string) (models.Tag, *FieldError) {
+	tag := models.ParseTag(text)
+	if tag.Key == "" {
+		return tag, &FieldError{Field: field, Message: "must have a name before any colon"}
+	}
+	if !validTagPart(tag.Key) || !validTagPart(tag.Value) {
+		return tag, &FieldError{
+			Field: field,
+			Message: fmt.Sprintf(
+				"may only use letters, numbers, spaces, - and _ with at most %d on each side of the colon",
+				MaxTagPartLength,
+			),
+		}
+	}
+	return tag, nil
+}
+
+// ValidateTags checks the tags for an entry and returns them in canonical
+// form without duplicates.
+func ValidateTags(tags []string) ([]string, error) {
+	var problems []FieldError
+	result := []string{}
+	seen := map[string]bool{}
+	for i, text := range tags {
+		tag, problem := ParseTag(text, fmt.Sprintf("tags[%d]", i))
+		if problem != nil {
+			problems = append(problems, *problem)
+			continue
+		}
+		if !seen[tag.String()] {
+			seen[tag.String()] = true
+			result = append(result, tag.String())
+		}
+	}
+
+	if len(result) > MaxTagsPerEntry {
+		problems = append(problems, FieldError{
+			Field:   "tags",
+			Message: fmt.Sprintf("can have at most %d tags", MaxTagsPerEntry),
+		})
+	}
+
+	if len(problems) > 0 {
+		return nil, Invalid(problems...)
+	}
+
+	return result, nil
+}
+
+// SetPlaylistTags replaces the tags of the user's playlist on date.
+func SetPlaylistTags(dbConn *db.Database, userId, date string, tags []string) (*models.MoodPlaylist, error) {
+	tags, err := ValidateTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	playlist, err := dbConn.SetMoodPlaylistTags(userId, date, tags)
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, Internal(err)
+	}
+
+	return playlist, nil
+}
+
+// TagSummary is how a tag has been used. AverageMood is the average start
+// mood of the entries with it, compare it with the overall average to see how
+// the tag relates to mood.
+type TagSummary struct {
+	Tag         string  `json:"tag"`
+	Key         string  `json:"key"`
+	Value       string  `json:"value"`
+	Entries     int     `json:"entries"`
+	AverageMood float32 `json:"average_mood"`
+}
+
+// ListTags summarises every tag the user has used, most used first.
+func ListTags(dbConn *db.Database, userId string) ([]TagSummary, error) {
+	playlists, err := dbConn.GetMoodPlaylists(userId)
+	if err != nil {
+		return nil, Internal(err)
+	}
+
+	totals := map[string]float64{}
+	counts := map[string]int{}
+	for _, playlist := range playlists {
+		for _, tag := range playlist.Tags {
+			totals[tag] += float64(playlist.StartMood)
+			counts[tag]++
+		}
+	}
+
+	result := []TagSummary{}
+	for text, count := range counts {
+		tag := models.ParseTag(text)
+		result = append(result, TagSummary{
+			Tag:         text,
+			Key:         tag.Key,
+			Value:       tag.Value,
+			Entries:     count,
+			AverageMood: float32(totals[text] / float64(count)),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Entries != result[j].Entries {
+			return result[i].Entries > result[j].Entries
+		}
+		return result[i].Tag < result[j].Tag
+	})
+
+	return result, nil
+}
+
+// RenameTag renames tag on every entry that has it, merging it into
+// replacement where an entry already has both. It returns how many entries
+// changed.
+func RenameTag(dbConn *db.Database, userId, tag, replacement string) (int, error) {
+	from, problem := ParseTag(tag, "tag")
+	if problem != nil {
+		return 0, ErrNotFound
+	}
+	to, problem := ParseTag(replacement, "tag")
+	if problem != nil {
+		return 0, Invalid(*problem)
+	}
+
+	changed, err := dbConn.ReplaceTag(userId, from, &to)
+	if err != nil {
+		return 0, Internal(err)
+	} else if changed == 0 {
+		return 0, ErrNotFound
+	}
+
+	return changed, nil
+}
+
+// DeleteTag removes tag from every entry. It returns how many entries
+// changed.
+func DeleteTag(dbConn *db.Database, userId, tag string) (int, error) {
+	parsed, problem := ParseTag(tag, "tag")
+	if problem != nil {
+		return 0, ErrNotFound
+	}
+
+	changed, err := dbConn.ReplaceTag(userId, parsed, nil)
+	if err != nil {
+		return 0, Internal(err)
+	} else if changed == 0 {
+		return 0, ErrNotFound
+	}
+
+	return changed, nil
+}
+
+// ValidateTagFilter checks a tag used to filter entries and returns it in
+// canonical form. An empty tag doesn't filter.
+func ValidateTagFilter(tag string) (string, error) {
+	if tag == "" {
+		return "", nil
+	}
+	parsed, problem := ParseTag(tag, "tag")
+	if problem != nil {
+		return "", Invalid(*problem)
+	}
+	return parsed.String(), nil
+}
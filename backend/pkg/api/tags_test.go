@@ -0,0 +1,127 @@
+package api_test
+
+import (
+	"testing"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/api"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTags(t *testing.T) {
+	t.Parallel()
+
+	type scenario struct {
+		tags           []string
+		expectedTags   []string
+		expectedFields []string
+	}
+	scenarios := []scenario{
+		{
+			tags:         []string{},
+			expectedTags: []string{},
+		},
+		{
+			tags:         []string{" Weather : Rain", "weather:rain", "Slept_badly", "café"},
+			expectedTags: []string{"weather:rain", "slept_badly", "café"},
+		},
+		{
+			tags:           []string{":rain", "a/b", "fine", "work:from:home"},
+			expectedFields: []string{"tags[0]", "tags[1]", "tags[3]"},
+		},
+		{
+			tags:           []string{string(make([]rune, api.MaxTagPartLength+1))},
+			expectedFields: []string{"tags[0]"},
+		},
+	}
+	var tooMany []string
+	for i := 0; i <= api.MaxTagsPerEntry; i++ {
+		tooMany = append(tooMany, string(rune('a'+i)))
+	}
+	scenarios = append(scenarios, scenario{tags: tooMany, expectedFields: []string{"tags"}})
+
+	for _, scenario := range scenarios {
+		// Run
+		tags, err := api.ValidateTags(scenario.tags)
+
+		if len(scenario.expectedFields) == 0 {
+			assert.NoError(t, err)
+			assert.Equal(t, scenario.expectedTags, tags)
+			continue
+		}
+
+		assert.ErrorIs(t, err, api.Invalid())
+		var fields []string
+		for _, detail := range api.AsError(err).Details {
+			fields = append(fields, detail.Field)
+		}
+		assert.Equal(t, scenario.expectedFields, fields)
+	}
+}
+
+func TestTags(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	for date, mood := range map[string]float32{
+		"2022-01-01": -0.4,
+		"2022-01-02": -0.2,
+		"2022-01-03": 0.3,
+	} {
+		assert.NoError(t, dbConn.SetMoodPlaylist(userId, &models.MoodPlaylist{
+			Date:      easyParseDate(date),
+			StartMood: mood,
+		}))
+	}
+
+	// Run
+	_, err := api.SetPlaylistTags(dbConn, userId, "2022-01-01", []string{"Weather:Rain", "work"})
+	assert.NoError(t, err)
+	_, err = api.SetPlaylistTags(dbConn, userId, "2022-01-02", []string{"weather:rain"})
+	assert.NoError(t, err)
+	playlist, err := api.SetPlaylistTags(dbConn, userId, "2022-01-03", []string{"weather:sun", "work"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"weather:sun", "work"}, playlist.Tags)
+
+	_, err = api.SetPlaylistTags(dbConn, userId, "2022-01-04", []string{"work"})
+	assert.ErrorIs(t, err, api.ErrNotFound)
+	_, err = api.SetPlaylistTags(dbConn, userId, "2022-01-01", []string{"a/b"})
+	assert.ErrorIs(t, err, api.Invalid())
+
+	tags, err := api.ListTags(dbConn, userId)
+	assert.NoError(t, err)
+	assert.Len(t, tags, 3)
+	assert.Equal(t, "weather:rain", tags[0].Tag)
+	assert.Equal(t, "weather", tags[0].Key)
+	assert.Equal(t, "rain", tags[0].Value)
+	assert.Equal(t, 2, tags[0].Entries)
+	assert.InDelta(t, -0.3, tags[0].AverageMood, 0.0001)
+	assert.Equal(t, "work", tags[1].Tag)
+	assert.Equal(t, "weather:sun", tags[2].Tag)
+
+	stats, err := api.GetMoodStats(dbConn, userId, easyParseDate("2022-01-01"), easyParseDate("2022-01-03"), 1, "weather")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, stats.LoggedDays)
+	stats, err = api.GetMoodStats(dbConn, userId, easyParseDate("2022-01-01"), easyParseDate("2022-01-03"), 1, "work")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, stats.LoggedDays)
+
+	changed, err := api.RenameTag(dbConn, userId, "work", "Job")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, changed)
+	_, err = api.RenameTag(dbConn, userId, "work", "job")
+	assert.ErrorIs(t, err, api.ErrNotFound)
+	_, err = api.RenameTag(dbConn, userId, "job", "")
+	assert.ErrorIs(t, err, api.Invalid())
+
+	changed, err = api.DeleteTag(dbConn, userId, "job")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, changed)
+	_, err = api.DeleteTag(dbConn, userId, "job")
+	assert.ErrorIs(t, err, api.ErrNotFound)
+
+	tags, _ = api.ListTags(dbConn, userId)
+	assert.Len(t, tags, 2)
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/sardap/TuneNeutral/backend/pkg/db"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+)
+
+const (
+	// AccessTokenPrefix starts every access token so they are easy to spot
+	// in scripts and secret scanners
+	AccessTokenPrefix = "tn_"
+
+	TokenScopeRead  = "read"
+	TokenScopeWrite = "write"
+	// TokenScopeFeed only reads the calendar and Atom feeds. Feed readers
+	// put the token in the URL, where it ends up in logs, so it gets nothing
+	// else
+	TokenScopeFeed = "feed"
+
+	maxTokenNameLength = 64
+	tokenTouchInterval = time.Minute
+)
+
+var TokenScopes = []string{TokenScopeRead, TokenScopeWrite, TokenScopeFeed}
+
+func hashAccessToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func validTokenScope(scope string) bool {
+	for _, valid := range TokenScopes {
+		if scope == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAccessToken mints a token for userId. The returned secret is the only
+// time it is available, only its hash is stored. spotifyToken is the user's
+// encoded Spotify token, stored for requests made with the access token.
+func CreateAccessToken(dbConn *db.Database, userId, name string, scopes []string, spotifyToken []byte) (string, *models.AccessToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxTokenNameLength {
+		return "", nil, Invalid(FieldError{
+			Field:   "name",
+			Message: fmt.Sprintf("must be 1 to %d characters", maxTokenNameLength),
+		})
+	}
+
+	if len(scopes) == 0 {
+		return "", nil, Invalid(FieldError{Field: "scopes", Message: "at least one scope is required"})
+	}
+	for _, scope := range scopes {
+		if !validTokenScope(scope) {
+			return "", nil, Invalid(FieldError{Field: "scopes", Message: fmt.Sprintf("unknown scope %s", scope)})
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, Internal(err)
+	}
+	raw := AccessTokenPrefix + hex.EncodeToString(secret)
+	hash := hashAccessToken(raw)
+
+	token := &models.AccessToken{
+		Id:        hash[:16],
+		UserId:    userId,
+		Name:      name,
+		Scopes:    mergeScopes(scopes),
+		CreatedAt: time.Now(),
+	}
+	if err := dbConn.PutAccessToken(hash, token, spotifyToken); err != nil {
+		return "", nil, Internal(err)
+	}
+
+	return raw, token, nil
+}
+
+func ListAccessTokens(dbConn *db.Database, userId string) ([]*models.AccessToken, error) {
+	tokens, err := dbConn.GetUserAccessTokens(userId)
+	if err != nil {
+		return nil, Internal(err)
+	}
+
+	return tokens, nil
+}
+
+func RevokeAccessToken(dbConn *db.Database, userId, tokenId string) error {
+	err := dbConn.DeleteAccessToken(userId, tokenId)
+	if err == badger.ErrKeyNotFound {
+		return ErrNotFound
+	} else if err != nil {
+		return Internal(err)
+	}
+
+	return nil
+}
+
+// AuthenticateAccessToken returns the token raw is the secret of and records
+// it being used. Unknown or revoked tokens are ErrUnauthorized.
+func AuthenticateAccessToken(dbConn *db.Database, raw string) (*models.AccessToken, error) {
+	if !strings.HasPrefix(raw, AccessTokenPrefix) {
+		return nil, ErrUnauthorized
+	}
+
+	hash := hashAccessToken(raw)
+	token, err := dbConn.GetAccessToken(hash)
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrUnauthorized
+	} else if err != nil {
+		return nil, Internal(err)
+	}
+
+	now := time.Now()
+	if now.Sub(token.LastUsed) > tokenTouchInterval {
+		token.LastUsed = now
+		dbConn.TouchAccessToken(hash, now)
+	}
+
+	return token, nil
+}
+
+// TokenAllows reports whether token may make a request with method. Reads
+// need the read scope, everything else the write scope.
+func TokenAllows(token *models.AccessToken, method string) bool {
+	needed := TokenScopeWrite
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		needed = TokenScopeRead
+	}
+
+	return TokenHasScope(token, needed)
+}
+
+func TokenHasScope(token *models.AccessToken, scope string) bool {
+	for _, granted := range token.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSpotifyToken returns the user's stored Spotify token. Without one, as
+// after a restore, access tokens can't reach Spotify until the user logs in
+// again.
+func GetSpotifyToken(dbConn *db.Database, userId string) ([]byte, error) {
+	token, err := dbConn.GetSpotifyToken(userId)
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrUnauthorized.WithCause(fmt.Errorf("no spotify token stored"))
+	} else if err != nil {
+		return nil, Internal(err)
+	}
+
+	return token, nil
+}
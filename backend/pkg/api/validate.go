@@ -0,0 +1,241 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/db"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+	"github.com/sardap/TuneNeutral/backend/pkg/search"
+)
+
+const (
+	MaxNoteLength = 1000
+	// DefaultPlaylistPageSize is how many playlists a page has when the
+	// request doesn't say
+	DefaultPlaylistPageSize = 100
+	MaxPlaylistPageSize     = 366
+	// DefaultStatsDays is how many days stats cover when the request doesn't
+	// give a start
+	DefaultStatsDays   = 90
+	MaxStatsDays       = 366
+	DefaultStatsWindow = 7
+	MaxStatsWindow     = 90
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+	// futureDateSlack lets users in timezones ahead of the server log their
+	// today
+	futureDateSlack = 24 * time.Hour
+)
+
+var noteTooLong = FieldError{
+	Field:   "note",
+	Message: fmt.Sprintf("must be at most %d characters", MaxNoteLength),
+}
+
+// ValidateNote checks a playlist's note on its own.
+func ValidateNote(note string) error {
+	if utf8.RuneCountInString(note) > MaxNoteLength {
+		return Invalid(noteTooLong)
+	}
+	return nil
+}
+
+// ValidateMoodPlaylist checks a request to generate a mood playlist and
+// returns its date. An empty date is today. Every problem found is reported in
+// one Invalid error.
+func ValidateMoodPlaylist(mood *float32, date string, note string, now time.Time) (time.Time, error) {
+	var problems []FieldError
+
+	if mood == nil {
+		problems = append(problems, FieldError{Field: "mood", Message: "is required"})
+	} else if models.Mood(*mood) < models.MoodMin || models.Mood(*mood) > models.MoodMax {
+		problems = append(problems, FieldError{
+			Field:   "mood",
+			Message: fmt.Sprintf("must be between %g and %g", models.MoodMin, models.MoodMax),
+		})
+	}
+
+	today, _ := time.Parse(models.DateFormat, now.Format(models.DateFormat))
+	result := today
+	if date != "" {
+		parsed, err := time.Parse(models.DateFormat, date)
+		if err != nil {
+			problems = append(problems, FieldError{Field: "date", Message: "must be formatted as YYYY-MM-DD"})
+		} else if parsed.After(today.Add(futureDateSlack)) {
+			problems = append(problems, FieldError{Field: "date", Message: "can't be in the future"})
+		}
+		result = parsed
+	}
+
+	if utf8.RuneCountInString(note) > MaxNoteLength {
+		problems = append(problems, noteTooLong)
+	}
+
+	if len(problems) > 0 {
+		return time.Time{}, Invalid(problems...)
+	}
+
+	return result, nil
+}
+
+func isDate(value string) bool {
+	_, err := time.Parse(models.DateFormat, value)
+	return err == nil
+}
+
+// ValidatePlaylistQuery checks the query parameters for listing mood
+// playlists. order is asc or desc, cursor is the next_cursor of the previous
+// page, tag limits it to entries with that tag and every parameter may be
+// empty.
+func ValidatePlaylistQuery(from, to, cursor, order, limit, tag string) (db.MoodPlaylistQuery, error) {
+	var problems []FieldError
+	query := db.MoodPlaylistQuery{
+		From:  from,
+		To:    to,
+		After: cursor,
+		Limit: DefaultPlaylistPageSize,
+	}
+
+	if from != "" && !isDate(from) {
+		problems = append(problems, FieldError{Field: "from", Message: "must be formatted as YYYY-MM-DD"})
+	}
+	if to != "" && !isDate(to) {
+		problems = append(problems, FieldError{Field: "to", Message: "must be formatted as YYYY-MM-DD"})
+	} else if from != "" && to != "" && to < from {
+		problems = append(problems, FieldError{Field: "to", Message: "can't be before from"})
+	}
+
+	if cursor != "" && !isDate(cursor) {
+		problems = append(problems, FieldError{Field: "cursor", Message: "is not a cursor from a previous page"})
+	}
+
+	switch order {
+	case "", "asc":
+	case "desc":
+		query.Reverse = true
+	default:
+		problems = append(problems, FieldError{Field: "order", Message: "must be asc or desc"})
+	}
+
+	if limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 1 || parsed > MaxPlaylistPageSize {
+			problems = append(problems, FieldError{
+				Field:   "limit",
+				Message: fmt.Sprintf("must be a number from 1 to %d", MaxPlaylistPageSize),
+			})
+		}
+		query.Limit = parsed
+	}
+
+	if tag != "" {
+		parsed, problem := ParseTag(tag, "tag")
+		if problem != nil {
+			problems = append(problems, *problem)
+		}
+		query.Tag = parsed.String()
+	}
+
+	if len(problems) > 0 {
+		return db.MoodPlaylistQuery{}, Invalid(problems...)
+	}
+
+	return query, nil
+}
+
+// ValidateStatsQuery checks the query parameters for mood stats and returns
+// the range and rolling average window. to defaults to today and from to
+// DefaultStatsDays before it.
+func ValidateStatsQuery(from, to, window string, now time.Time) (time.Time, time.Time, int, error) {
+	var problems []FieldError
+
+	end, _ := time.Parse(models.DateFormat, now.Format(models.DateFormat))
+	endValid := true
+	if to != "" {
+		parsed, err := time.Parse(models.DateFormat, to)
+		if err != nil {
+			problems = append(problems, FieldError{Field: "to", Message: "must be formatted as YYYY-MM-DD"})
+			endValid = false
+		}
+		end = parsed
+	}
+
+	start := end.AddDate(0, 0, -(DefaultStatsDays - 1))
+	if from != "" {
+		parsed, err := time.Parse(models.DateFormat, from)
+		if err != nil {
+			problems = append(problems, FieldError{Field: "from", Message: "must be formatted as YYYY-MM-DD"})
+		} else if endValid && parsed.After(end) {
+			problems = append(problems, FieldError{Field: "from", Message: "can't be after to"})
+		} else if endValid && parsed.AddDate(0, 0, MaxStatsDays-1).Before(end) {
+			problems = append(problems, FieldError{
+				Field:   "from",
+				Message: fmt.Sprintf("can cover at most %d days", MaxStatsDays),
+			})
+		}
+		start = parsed
+	}
+
+	result := DefaultStatsWindow
+	if window != "" {
+		parsed, err := strconv.Atoi(window)
+		if err != nil || parsed < 1 || parsed > MaxStatsWindow {
+			problems = append(problems, FieldError{
+				Field:   "window",
+				Message: fmt.Sprintf("must be a number from 1 to %d", MaxStatsWindow),
+			})
+		}
+		result = parsed
+	}
+
+	if len(problems) > 0 {
+		return time.Time{}, time.Time{}, 0, Invalid(problems...)
+	}
+
+	return start, end, result, nil
+}
+
+// ValidateSearchQuery checks the query parameters for searching notes. q is
+// required, the rest may be empty.
+func ValidateSearchQuery(q, from, to, limit string) (SearchQuery, error) {
+	var problems []FieldError
+	result := SearchQuery{
+		Query: search.ParseQuery(q),
+		From:  from,
+		To:    to,
+		Limit: DefaultSearchLimit,
+	}
+
+	if result.Query.Empty() {
+		problems = append(problems, FieldError{Field: "q", Message: "must have a word to search for"})
+	}
+
+	if from != "" && !isDate(from) {
+		problems = append(problems, FieldError{Field: "from", Message: "must be formatted as YYYY-MM-DD"})
+	}
+	if to != "" && !isDate(to) {
+		problems = append(problems, FieldError{Field: "to", Message: "must be formatted as YYYY-MM-DD"})
+	} else if from != "" && to != "" && to < from {
+		problems = append(problems, FieldError{Field: "to", Message: "can't be before from"})
+	}
+
+	if limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 1 || parsed > MaxSearchLimit {
+			problems = append(problems, FieldError{
+				Field:   "limit",
+				Message: fmt.Sprintf("must be a number from 1 to %d", MaxSearchLimit),
+			})
+		}
+		result.Limit = parsed
+	}
+
+	if len(problems) > 0 {
+		return SearchQuery{}, Invalid(problems...)
+	}
+
+	return result, nil
+}
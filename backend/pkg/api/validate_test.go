@@ -0,0 +1,232 @@
+package api_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/api"
+	"github.com/sardap/TuneNeutral/backend/pkg/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func mood(m float32) *float32 {
+	return &m
+}
+
+func TestValidateMoodPlaylist(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 1, 6, 23, 30, 0, 0, time.UTC)
+
+	type scenario struct {
+		mood           *float32
+		date           string
+		note           string
+		expectedDate   time.Time
+		expectedFields []string
+	}
+	scenarios := []scenario{
+		{
+			mood:         mood(0.1),
+			date:         "2022-01-01",
+			note:         "fine",
+			expectedDate: easyParseDate("2022-01-01"),
+		},
+		{
+			// Empty dates are today
+			mood:         mood(-0.5),
+			expectedDate: easyParseDate("2022-01-06"),
+		},
+		{
+			// Tomorrow is allowed for users ahead of the server
+			mood:         mood(0.5),
+			date:         "2022-01-07",
+			expectedDate: easyParseDate("2022-01-07"),
+		},
+		{
+			mood:           mood(0.1),
+			date:           "2022-01-08",
+			expectedFields: []string{"date"},
+		},
+		{
+			mood:           mood(0.1),
+			date:           "2022-01-06T00:00:00Z",
+			expectedFields: []string{"date"},
+		},
+		{
+			mood:           mood(0.51),
+			expectedFields: []string{"mood"},
+		},
+		{
+			note:           strings.Repeat("a", api.MaxNoteLength+1),
+			date:           "06/01/2022",
+			expectedFields: []string{"mood", "date", "note"},
+		},
+		{
+			// Length is in characters not bytes
+			mood:         mood(0),
+			note:         strings.Repeat("😑", api.MaxNoteLength),
+			expectedDate: easyParseDate("2022-01-06"),
+		},
+	}
+	for _, scenario := range scenarios {
+		// Run
+		date, err := api.ValidateMoodPlaylist(scenario.mood, scenario.date, scenario.note, now)
+
+		if len(scenario.expectedFields) == 0 {
+			assert.NoError(t, err)
+			assert.Equal(t, scenario.expectedDate, date)
+			continue
+		}
+
+		assert.ErrorIs(t, err, api.Invalid())
+		var fields []string
+		for _, detail := range api.AsError(err).Details {
+			fields = append(fields, detail.Field)
+		}
+		assert.Equal(t, scenario.expectedFields, fields)
+	}
+}
+
+func TestValidatePlaylistQuery(t *testing.T) {
+	t.Parallel()
+
+	type scenario struct {
+		from           string
+		to             string
+		cursor         string
+		order          string
+		limit          string
+		tag            string
+		expectedQuery  db.MoodPlaylistQuery
+		expectedFields []string
+	}
+	scenarios := []scenario{
+		{
+			expectedQuery: db.MoodPlaylistQuery{Limit: api.DefaultPlaylistPageSize},
+		},
+		{
+			from:          "2021-01-01",
+			to:            "2021-01-31",
+			cursor:        "2021-01-10",
+			order:         "desc",
+			limit:         "7",
+			expectedQuery: db.MoodPlaylistQuery{From: "2021-01-01", To: "2021-01-31", After: "2021-01-10", Reverse: true, Limit: 7},
+		},
+		{
+			from:           "2021-02-01",
+			to:             "2021-01-01",
+			expectedFields: []string{"to"},
+		},
+		{
+			from:           "yesterday",
+			to:             "01/01/2021",
+			cursor:         "abc",
+			order:          "newest",
+			limit:          "0",
+			expectedFields: []string{"from", "to", "cursor", "order", "limit"},
+		},
+		{
+			limit:          "367",
+			expectedFields: []string{"limit"},
+		},
+		{
+			tag:           " Weather : Rain ",
+			expectedQuery: db.MoodPlaylistQuery{Limit: api.DefaultPlaylistPageSize, Tag: "weather:rain"},
+		},
+		{
+			tag:            "a/b",
+			expectedFields: []string{"tag"},
+		},
+	}
+	for _, scenario := range scenarios {
+		// Run
+		query, err := api.ValidatePlaylistQuery(scenario.from, scenario.to, scenario.cursor, scenario.order, scenario.limit, scenario.tag)
+
+		if len(scenario.expectedFields) == 0 {
+			assert.NoError(t, err)
+			assert.Equal(t, scenario.expectedQuery, query)
+			continue
+		}
+
+		assert.ErrorIs(t, err, api.Invalid())
+		var fields []string
+		for _, detail := range api.AsError(err).Details {
+			fields = append(fields, detail.Field)
+		}
+		assert.Equal(t, scenario.expectedFields, fields)
+	}
+}
+
+func TestValidateStatsQuery(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 1, 6, 23, 30, 0, 0, time.UTC)
+
+	type scenario struct {
+		from           string
+		to             string
+		window         string
+		expectedFrom   time.Time
+		expectedTo     time.Time
+		expectedWindow int
+		expectedFields []string
+	}
+	scenarios := []scenario{
+		{
+			expectedFrom:   easyParseDate("2021-10-09"),
+			expectedTo:     easyParseDate("2022-01-06"),
+			expectedWindow: api.DefaultStatsWindow,
+		},
+		{
+			from:           "2021-01-01",
+			to:             "2021-12-31",
+			window:         "30",
+			expectedFrom:   easyParseDate("2021-01-01"),
+			expectedTo:     easyParseDate("2021-12-31"),
+			expectedWindow: 30,
+		},
+		{
+			from:           "2021-01-01",
+			to:             "2022-01-01",
+			expectedFrom:   easyParseDate("2021-01-01"),
+			expectedTo:     easyParseDate("2022-01-01"),
+			expectedWindow: api.DefaultStatsWindow,
+		},
+		{
+			from:           "2021-01-01",
+			to:             "2022-01-02",
+			expectedFields: []string{"from"},
+		},
+		{
+			from:           "2022-01-07",
+			expectedFields: []string{"from"},
+		},
+		{
+			from:           "monday",
+			to:             "friday",
+			window:         "0",
+			expectedFields: []string{"to", "from", "window"},
+		},
+	}
+	for _, scenario := range scenarios {
+		// Run
+		from, to, window, err := api.ValidateStatsQuery(scenario.from, scenario.to, scenario.window, now)
+
+		if len(scenario.expectedFields) == 0 {
+			assert.NoError(t, err)
+			assert.Equal(t, scenario.expectedFrom, from)
+			assert.Equal(t, scenario.expectedTo, to)
+			assert.Equal(t, scenario.expectedWindow, window)
+			continue
+		}
+
+		assert.ErrorIs(t, err, api.Invalid())
+		var fields []string
+		for _, detail := range api.AsError(err).Details {
+			fields = append(fields, detail.Field)
+		}
+		assert.Equal(t, scenario.expectedFields, fields)
+	}
+}
@@ -0,0 +1,37 @@
+// Package apitypes holds the types the REST API shares with its clients. It
+// only imports the standard library so pkg/client can use it without pulling
+// in the server.
+package apitypes
+
+// ErrorCode identifies the kind of failure. Clients should branch on it, not
+// on the message.
+type ErrorCode string
+
+const (
+	CodeBadRequest   ErrorCode = "bad_request"
+	CodeInvalid      ErrorCode = "invalid_request"
+	CodeUnauthorized ErrorCode = "unauthorized"
+	CodeForbidden    ErrorCode = "forbidden"
+	CodeNotFound     ErrorCode = "not_found"
+	CodeConflict     ErrorCode = "conflict"
+	CodeServerError  ErrorCode = "internal_error"
+)
+
+// FieldError describes what is wrong with one field of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Feature is a part of the app that needs its own Spotify scopes. Users are
+// only asked for a feature's scopes once they enable it.
+type Feature string
+
+const (
+	// FeatureJournal only needs a login
+	FeatureJournal  Feature = "journal"
+	FeatureGenerate Feature = "generate"
+	FeatureSync     Feature = "sync"
+	// FeaturePlayback adds tracks to the user's queue
+	FeaturePlayback Feature = "playback"
+)
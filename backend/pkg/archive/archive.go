@@ -0,0 +1,361 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/sardap/TuneNeutral/backend/pkg/db"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+)
+
+// FormatVersion is the version of every document in an export archive. It
+// changes whenever a document changes in a way older readers can't handle.
+const FormatVersion = 1
+
+const (
+	ManifestName = "manifest.json"
+	LibraryName  = "library.json"
+	EntriesName  = "mood_entries.json"
+	IgnoredName  = "ignored_tracks.json"
+	SettingsName = "settings.json"
+	JournalName  = "mood_journal.csv"
+)
+
+// Manifest leads an export archive and lists the files after it.
+type Manifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UserId    string    `json:"user_id"`
+	Files     []File    `json:"files"`
+}
+
+type File struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+type Artist struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// TrackMetadata is what is stored about a track apart from its audio
+// features.
+type TrackMetadata struct {
+	Name        string   `json:"name"`
+	AlbumId     string   `json:"album_id"`
+	AlbumArtUrl string   `json:"album_art_url"`
+	Artists     []Artist `json:"artists"`
+}
+
+type Track struct {
+	Id      string  `json:"id"`
+	Valence float32 `json:"valence"`
+	Energy  float32 `json:"energy"`
+	// Mood is the mood category the valence falls in
+	Mood float32 `json:"mood"`
+	// Metadata is nil for tracks that are no longer stored
+	Metadata *TrackMetadata `json:"metadata"`
+}
+
+type Library struct {
+	Version       int     `json:"version"`
+	CompletedScan bool    `json:"completed_scan"`
+	Tracks        []Track `json:"tracks"`
+}
+
+// IgnoredTracks are tracks the user removed from their library. Only their
+// ids are kept so Valence and Energy come from the stored track, when there
+// is one.
+type IgnoredTracks struct {
+	Version int     `json:"version"`
+	Tracks  []Track `json:"tracks"`
+}
+
+type Entry struct {
+	// Date is YYYY-MM-DD
+	Date      string   `json:"date"`
+	StartMood float32  `json:"start_mood"`
+	EndMood   float32  `json:"end_mood"`
+	Note      *string  `json:"note"`
+	Tags      []string `json:"tags"`
+	// Tracks are the ids of the playlist's tracks in order
+	Tracks []string `json:"tracks"`
+}
+
+type Entries struct {
+	Version int     `json:"version"`
+	Entries []Entry `json:"entries"`
+}
+
+type Settings struct {
+	Version int `json:"version"`
+	// SpotifyPlaylistId is empty until mood playlists are first synced
+	SpotifyPlaylistId string   `json:"spotify_playlist_id"`
+	Scopes            []string `json:"scopes"`
+}
+
+// Export is everything stored about a user that they can take with them.
+// Secrets, like their Spotify token, sessions and access tokens, are left out.
+type Export struct {
+	Library  Library
+	Ignored  IgnoredTracks
+	Entries  Entries
+	Settings Settings
+	// tracks has the metadata of every track referenced, for the journal
+	tracks map[string]*models.Track
+}
+
+func toTrack(id string, min *models.MinTrack, stored *models.Track) Track {
+	result := Track{Id: id}
+	if min != nil {
+		result.Valence = min.Valence
+		result.Energy = min.Energy
+	} else if stored != nil {
+		result.Valence = stored.Valence
+		result.Energy = stored.Energy
+	}
+	result.Mood = float32(models.TrackMood(result.Valence))
+
+	if stored != nil {
+		result.Metadata = &TrackMetadata{
+			Name:        stored.Name,
+			AlbumId:     stored.AlbumId,
+			AlbumArtUrl: stored.AlbumArtUrl,
+			Artists:     []Artist{},
+		}
+		for _, artist := range stored.Artists {
+			result.Metadata.Artists = append(result.Metadata.Artists, Artist{Id: string(artist.ID), Name: artist.Name})
+		}
+	}
+
+	return result
+}
+
+// Collect reads everything to export for userId from dbConn.
+func Collect(dbConn *db.Database, userId string) (*Export, error) {
+	result := &Export{
+		Library:  Library{Version: FormatVersion, Tracks: []Track{}},
+		Ignored:  IgnoredTracks{Version: FormatVersion, Tracks: []Track{}},
+		Entries:  Entries{Version: FormatVersion, Entries: []Entry{}},
+		Settings: Settings{Version: FormatVersion, Scopes: []string{}},
+	}
+
+	scan, err := dbConn.GetLibraryScan(userId)
+	if err == nil {
+		result.Library.CompletedScan = scan.CompletedScan
+	} else if err != badger.ErrKeyNotFound {
+		return nil, err
+	}
+
+	library, err := dbConn.GetLibraryTracks(userId)
+	if err != nil {
+		return nil, err
+	}
+	ignored, err := dbConn.GetIgnoredTracks(userId)
+	if err != nil {
+		return nil, err
+	}
+	playlists, err := dbConn.GetMoodPlaylists(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := map[string]bool{}
+	for id := range library {
+		referenced[id] = true
+	}
+	for _, id := range ignored {
+		referenced[id] = true
+	}
+	for _, playlist := range playlists {
+		for _, id := range playlist.Tracks {
+			referenced[id] = true
+		}
+	}
+	var ids []string
+	for id := range referenced {
+		ids = append(ids, id)
+	}
+	tracks, _, err := dbConn.GetTracks(ids...)
+	if err != nil {
+		return nil, err
+	}
+	result.tracks = map[string]*models.Track{}
+	for _, track := range tracks {
+		result.tracks[track.Id] = track
+	}
+
+	for id, min := range library {
+		min := min
+		result.Library.Tracks = append(result.Library.Tracks, toTrack(id, &min, result.tracks[id]))
+	}
+	sort.Slice(result.Library.Tracks, func(i, j int) bool {
+		return result.Library.Tracks[i].Id < result.Library.Tracks[j].Id
+	})
+
+	for _, id := range ignored {
+		result.Ignored.Tracks = append(result.Ignored.Tracks, toTrack(id, nil, result.tracks[id]))
+	}
+
+	for _, playlist := range playlists {
+		entry := Entry{
+			Date:      playlist.Date.Format(models.DateFormat),
+			StartMood: playlist.StartMood,
+			EndMood:   playlist.EndMood,
+			Note:      playlist.Note,
+			Tags:      playlist.Tags,
+			Tracks:    playlist.Tracks,
+		}
+		if entry.Tags == nil {
+			entry.Tags = []string{}
+		}
+		if entry.Tracks == nil {
+			entry.Tracks = []string{}
+		}
+		result.Entries.Entries = append(result.Entries.Entries, entry)
+	}
+
+	result.Settings.SpotifyPlaylistId, err = dbConn.GetSpotifyPlaylist(userId)
+	if err != nil && err != badger.ErrKeyNotFound {
+		return nil, err
+	}
+	scopes, err := dbConn.GetUserScopes(userId)
+	if err != nil && err != badger.ErrKeyNotFound {
+		return nil, err
+	}
+	if scopes != nil {
+		result.Settings.Scopes = scopes
+	}
+
+	return result, nil
+}
+
+func (e *Export) trackName(id string) string {
+	track, ok := e.tracks[id]
+	if !ok {
+		return id
+	}
+
+	var artists []string
+	for _, artist := range track.Artists {
+		artists = append(artists, artist.Name)
+	}
+	if len(artists) == 0 {
+		return track.Name
+	}
+	return fmt.Sprintf("%s by %s", track.Name, strings.Join(artists, ", "))
+}
+
+// journal is the mood entries as a spreadsheet, one row a day.
+func (e *Export) journal() ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"date", "start_mood", "end_mood", "tags", "note", "tracks"})
+	for _, entry := range e.Entries.Entries {
+		note := ""
+		if entry.Note != nil {
+			note = *entry.Note
+		}
+		var tracks []string
+		for _, id := range entry.Tracks {
+			tracks = append(tracks, e.trackName(id))
+		}
+		w.Write([]string{
+			entry.Date,
+			fmt.Sprintf("%g", entry.StartMood),
+			fmt.Sprintf("%g", entry.EndMood),
+			strings.Join(entry.Tags, "; "),
+			note,
+			strings.Join(tracks, "; "),
+		})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// Write writes e as a zip archive to w. The manifest comes first and carries
+// the size and checksum of every other file.
+func (e *Export) Write(w io.Writer, userId string, now time.Time) (*Manifest, error) {
+	type file struct {
+		name string
+		data []byte
+	}
+	var files []file
+	for _, document := range []struct {
+		name  string
+		value interface{}
+	}{
+		{LibraryName, e.Library},
+		{EntriesName, e.Entries},
+		{IgnoredName, e.Ignored},
+		{SettingsName, e.Settings},
+	} {
+		data, err := json.MarshalIndent(document.value, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file{document.name, data})
+	}
+	journal, err := e.journal()
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, file{JournalName, journal})
+
+	manifest := &Manifest{
+		Version:   FormatVersion,
+		CreatedAt: now.UTC(),
+		UserId:    userId,
+	}
+	for _, f := range files {
+		sum := sha256.Sum256(f.data)
+		manifest.Files = append(manifest.Files, File{
+			Name:   f.name,
+			Size:   int64(len(f.data)),
+			Sha256: hex.EncodeToString(sum[:]),
+		})
+	}
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	zw := zip.NewWriter(w)
+	for _, f := range append([]file{{ManifestName, manifestData}}, files...) {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     f.name,
+			Method:   zip.Deflate,
+			Modified: manifest.CreatedAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fw.Write(f.data); err != nil {
+			return nil, err
+		}
+	}
+
+	return manifest, zw.Close()
+}
+
+// Write exports everything stored about userId to w as a zip archive.
+func Write(dbConn *db.Database, userId string, w io.Writer, now time.Time) (*Manifest, error) {
+	export, err := Collect(dbConn, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return export.Write(w, userId, now)
+}
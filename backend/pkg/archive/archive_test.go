@@ -0,0 +1,173 @@
+package archive_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/archive"
+	"github.com/sardap/TuneNeutral/backend/pkg/config"
+	"github.com/sardap/TuneNeutral/backend/pkg/db"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify"
+)
+
+const userId = "paul"
+
+func newDatabase(t *testing.T) *db.Database {
+	cfg := &config.Config{
+		DatabasePath: path.Join(t.TempDir(), "database"),
+	}
+
+	return db.ConnectDb(cfg)
+}
+
+func readFiles(t *testing.T, data []byte) ([]string, map[string][]byte) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	assert.NoError(t, err)
+
+	var names []string
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		r, err := f.Open()
+		assert.NoError(t, err)
+		content, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		r.Close()
+		names = append(names, f.Name)
+		files[f.Name] = content
+	}
+	return names, files
+}
+
+func TestWrite(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	assert.NoError(t, dbConn.PutTrack(&models.Track{
+		Id: "a", Name: "A", Valence: 0.9, AlbumId: "album",
+		Artists: []spotify.SimpleArtist{{ID: "artist", Name: "Artist"}},
+	}))
+	assert.NoError(t, dbConn.PutTrack(&models.Track{Id: "b", Name: "B", Valence: 0.1, Energy: 0.4}))
+	assert.NoError(t, dbConn.SaveLibraryPage(userId, &models.LibraryScan{CompletedScan: true}, map[string]models.MinTrack{
+		"a":    {Valence: 0.9},
+		"b":    {Valence: 0.1, Energy: 0.4},
+		"gone": {Valence: 0.5},
+	}))
+	assert.NoError(t, dbConn.RemoveLibraryTrack(userId, "b"))
+	note := "rainy, \"cold\""
+	assert.NoError(t, dbConn.SetMoodPlaylist(userId, &models.MoodPlaylist{
+		Date:      time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		StartMood: -0.25,
+		EndMood:   0.25,
+		Note:      &note,
+		Tags:      []string{"weather:rain", "work"},
+		Tracks:    []string{"a", "gone"},
+	}))
+	assert.NoError(t, dbConn.SetSpotifyPlaylist(userId, "playlist"))
+	assert.NoError(t, dbConn.SetUserScopes(userId, []string{"user-library-read"}))
+
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	buf := &bytes.Buffer{}
+
+	// Run
+	manifest, err := archive.Write(dbConn, userId, buf, now)
+	assert.NoError(t, err)
+
+	names, files := readFiles(t, buf.Bytes())
+	assert.Equal(t, []string{
+		archive.ManifestName,
+		archive.LibraryName,
+		archive.EntriesName,
+		archive.IgnoredName,
+		archive.SettingsName,
+		archive.JournalName,
+	}, names)
+
+	var written archive.Manifest
+	assert.NoError(t, json.Unmarshal(files[archive.ManifestName], &written))
+	assert.Equal(t, *manifest, written)
+	assert.Equal(t, archive.FormatVersion, written.Version)
+	assert.Equal(t, userId, written.UserId)
+	assert.True(t, now.Equal(written.CreatedAt))
+	for _, f := range written.Files {
+		sum := sha256.Sum256(files[f.Name])
+		assert.Equal(t, hex.EncodeToString(sum[:]), f.Sha256, f.Name)
+		assert.Equal(t, int64(len(files[f.Name])), f.Size, f.Name)
+	}
+
+	var library archive.Library
+	assert.NoError(t, json.Unmarshal(files[archive.LibraryName], &library))
+	assert.True(t, library.CompletedScan)
+	assert.Len(t, library.Tracks, 2)
+	assert.Equal(t, "a", library.Tracks[0].Id)
+	assert.Equal(t, float32(models.MoodHappy), library.Tracks[0].Mood)
+	assert.Equal(t, &archive.TrackMetadata{
+		Name:    "A",
+		AlbumId: "album",
+		Artists: []archive.Artist{{Id: "artist", Name: "Artist"}},
+	}, library.Tracks[0].Metadata)
+	assert.Equal(t, "gone", library.Tracks[1].Id)
+	assert.Nil(t, library.Tracks[1].Metadata)
+
+	var ignored archive.IgnoredTracks
+	assert.NoError(t, json.Unmarshal(files[archive.IgnoredName], &ignored))
+	assert.Len(t, ignored.Tracks, 1)
+	assert.Equal(t, float32(0.4), ignored.Tracks[0].Energy)
+	assert.Equal(t, "B", ignored.Tracks[0].Metadata.Name)
+
+	var entries archive.Entries
+	assert.NoError(t, json.Unmarshal(files[archive.EntriesName], &entries))
+	assert.Equal(t, []archive.Entry{{
+		Date:      "2021-01-01",
+		StartMood: -0.25,
+		EndMood:   0.25,
+		Note:      &note,
+		Tags:      []string{"weather:rain", "work"},
+		Tracks:    []string{"a", "gone"},
+	}}, entries.Entries)
+
+	var settings archive.Settings
+	assert.NoError(t, json.Unmarshal(files[archive.SettingsName], &settings))
+	assert.Equal(t, archive.Settings{
+		Version:           archive.FormatVersion,
+		SpotifyPlaylistId: "playlist",
+		Scopes:            []string{"user-library-read"},
+	}, settings)
+
+	rows, err := csv.NewReader(bytes.NewReader(files[archive.JournalName])).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"date", "start_mood", "end_mood", "tags", "note", "tracks"},
+		{"2021-01-01", "-0.25", "0.25", "weather:rain; work", note, "A by Artist; gone"},
+	}, rows)
+}
+
+func TestWriteEmpty(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	buf := &bytes.Buffer{}
+
+	// Run
+	_, err := archive.Write(dbConn, userId, buf, time.Now())
+	assert.NoError(t, err)
+
+	_, files := readFiles(t, buf.Bytes())
+	assert.JSONEq(t, `{"version": 1, "completed_scan": false, "tracks": []}`, string(files[archive.LibraryName]))
+	assert.JSONEq(t, `{"version": 1, "entries": []}`, string(files[archive.EntriesName]))
+	assert.JSONEq(t, `{"version": 1, "spotify_playlist_id": "", "scopes": []}`, string(files[archive.SettingsName]))
+	assert.Equal(t, "date,start_mood,end_mood,tags,note,tracks\n", string(files[archive.JournalName]))
+}
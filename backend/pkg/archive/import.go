@@ -0,0 +1,456 @@
+package archive
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/sardap/TuneNeutral/backend/pkg/api"
+	"github.com/sardap/TuneNeutral/backend/pkg/db"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+)
+
+var (
+	ErrInvalidArchive     = fmt.Errorf("invalid archive")
+	ErrUnsupportedVersion = fmt.Errorf("unsupported archive version")
+	ErrChecksumMismatch   = fmt.Errorf("checksum mismatch")
+	ErrOtherUser          = fmt.Errorf("archive belongs to another user")
+)
+
+// maxDecompressedSize bounds everything read from one archive together, zip
+// headers can lie about sizes. It is a few times the largest upload so a
+// small archive can't expand into an unbounded amount of memory.
+const maxDecompressedSize = 256 << 20
+
+// maxIdLength bounds Spotify ids, which are 22 characters today.
+const maxIdLength = 64
+
+func (m *Manifest) Valid() error {
+	if m.Version != FormatVersion {
+		return fmt.Errorf("%w: %d, only %d is supported", ErrUnsupportedVersion, m.Version, FormatVersion)
+	}
+
+	if m.CreatedAt.IsZero() {
+		return fmt.Errorf("%w: created_at must be set", ErrInvalidArchive)
+	}
+
+	for _, f := range m.Files {
+		if sum, err := hex.DecodeString(f.Sha256); err != nil || len(sum) != sha256.Size {
+			return fmt.Errorf("%w: %s must have a hex encoded sha256 sum", ErrInvalidArchive, f.Name)
+		}
+	}
+
+	return nil
+}
+
+// readFile reads f taking what it read from budget, the number of bytes the
+// archive may still decompress to.
+func readFile(f *zip.File, budget *int64) ([]byte, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidArchive, f.Name, err)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(io.LimitReader(r, *budget+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidArchive, f.Name, err)
+	}
+	if int64(len(data)) > *budget {
+		return nil, fmt.Errorf("%w: archive must decompress to at most %d bytes", ErrInvalidArchive, maxDecompressedSize)
+	}
+	*budget -= int64(len(data))
+	return data, nil
+}
+
+// validId reports whether id looks like a Spotify id. Ids end up in database
+// keys so anything else is refused.
+func validId(id string) bool {
+	if id == "" || len(id) > maxIdLength {
+		return false
+	}
+	for _, r := range id {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// invalid reports the problems in one item of a document as an
+// ErrInvalidArchive. Field errors from the api validators are listed in full.
+func invalid(document, item string, err error) error {
+	var apiErr *api.Error
+	if errors.As(err, &apiErr) && len(apiErr.Details) > 0 {
+		var problems []string
+		for _, detail := range apiErr.Details {
+			problems = append(problems, fmt.Sprintf("%s %s", detail.Field, detail.Message))
+		}
+		return fmt.Errorf("%w: %s: %s: %s", ErrInvalidArchive, document, item, strings.Join(problems, ", "))
+	}
+	return fmt.Errorf("%w: %s: %s: %v", ErrInvalidArchive, document, item, err)
+}
+
+func validateTracks(document string, tracks []Track) error {
+	for i, track := range tracks {
+		item := fmt.Sprintf("tracks[%d]", i)
+		if !validId(track.Id) {
+			return invalid(document, item, fmt.Errorf("%q is not a track id", track.Id))
+		}
+		if track.Valence < 0 || track.Valence > 1 || track.Energy < 0 || track.Energy > 1 {
+			return invalid(document, item, fmt.Errorf("valence and energy must be between 0 and 1"))
+		}
+	}
+	return nil
+}
+
+// validate checks every document with the same rules the API applies, and
+// puts tags in their canonical form, so importing can't store anything a
+// request couldn't.
+func (e *Export) validate(now time.Time) error {
+	if err := validateTracks(LibraryName, e.Library.Tracks); err != nil {
+		return err
+	}
+	if err := validateTracks(IgnoredName, e.Ignored.Tracks); err != nil {
+		return err
+	}
+
+	dates := map[string]bool{}
+	for i := range e.Entries.Entries {
+		entry := &e.Entries.Entries[i]
+		item := fmt.Sprintf("entries[%d]", i)
+
+		// The API takes a missing date to mean today, an entry must have one
+		if _, err := time.Parse(models.DateFormat, entry.Date); err != nil {
+			return invalid(EntriesName, item, fmt.Errorf("date must be formatted as YYYY-MM-DD"))
+		}
+
+		note := ""
+		if entry.Note != nil {
+			note = *entry.Note
+		}
+		startMood := entry.StartMood
+		if _, err := api.ValidateMoodPlaylist(&startMood, entry.Date, note, now); err != nil {
+			return invalid(EntriesName, item, err)
+		}
+		if models.Mood(entry.EndMood) < models.MoodMin || models.Mood(entry.EndMood) > models.MoodMax {
+			return invalid(EntriesName, item, fmt.Errorf("end_mood must be between %g and %g", models.MoodMin, models.MoodMax))
+		}
+		if dates[entry.Date] {
+			return invalid(EntriesName, item, fmt.Errorf("%s appears more than once", entry.Date))
+		}
+		dates[entry.Date] = true
+
+		tags, err := api.ValidateTags(entry.Tags)
+		if err != nil {
+			return invalid(EntriesName, item, err)
+		}
+		entry.Tags = tags
+
+		for _, id := range entry.Tracks {
+			if !validId(id) {
+				return invalid(EntriesName, item, fmt.Errorf("%q is not a track id", id))
+			}
+		}
+	}
+
+	if id := e.Settings.SpotifyPlaylistId; id != "" && !validId(id) {
+		return invalid(SettingsName, "spotify_playlist_id", fmt.Errorf("%q is not a playlist id", id))
+	}
+
+	return nil
+}
+
+// Read checks an export archive against its manifest, decodes it and
+// validates it. Every document must be the version this server writes.
+func Read(r io.ReaderAt, size int64) (*Export, *Manifest, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+
+	if len(zr.File) == 0 || zr.File[0].Name != ManifestName {
+		return nil, nil, fmt.Errorf("%w: archive must start with %s", ErrInvalidArchive, ManifestName)
+	}
+	budget := int64(maxDecompressedSize)
+	data, err := readFile(zr.File[0], &budget)
+	if err != nil {
+		return nil, nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s: %v", ErrInvalidArchive, ManifestName, err)
+	}
+	if err := manifest.Valid(); err != nil {
+		return nil, nil, err
+	}
+
+	files := map[string]*zip.File{}
+	for _, f := range zr.File[1:] {
+		if _, ok := files[f.Name]; ok {
+			return nil, nil, fmt.Errorf("%w: %s appears more than once", ErrInvalidArchive, f.Name)
+		}
+		files[f.Name] = f
+	}
+
+	result := &Export{}
+	documents := map[string]interface{}{
+		LibraryName:  &result.Library,
+		EntriesName:  &result.Entries,
+		IgnoredName:  &result.Ignored,
+		SettingsName: &result.Settings,
+	}
+	listed := map[string]bool{}
+	for _, entry := range manifest.Files {
+		if listed[entry.Name] {
+			return nil, nil, fmt.Errorf("%w: %s lists %s more than once", ErrInvalidArchive, ManifestName, entry.Name)
+		}
+		listed[entry.Name] = true
+		f, ok := files[entry.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: missing %s", ErrInvalidArchive, entry.Name)
+		}
+		data, err := readFile(f, &budget)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		sum := sha256.Sum256(data)
+		if int64(len(data)) != entry.Size || hex.EncodeToString(sum[:]) != entry.Sha256 {
+			return nil, nil, fmt.Errorf("%w: %s", ErrChecksumMismatch, entry.Name)
+		}
+
+		if document, ok := documents[entry.Name]; ok {
+			if err := json.Unmarshal(data, document); err != nil {
+				return nil, nil, fmt.Errorf("%w: %s: %v", ErrInvalidArchive, entry.Name, err)
+			}
+		}
+	}
+
+	for name := range documents {
+		if !listed[name] {
+			return nil, nil, fmt.Errorf("%w: missing %s", ErrInvalidArchive, name)
+		}
+	}
+	for name, version := range map[string]int{
+		LibraryName:  result.Library.Version,
+		EntriesName:  result.Entries.Version,
+		IgnoredName:  result.Ignored.Version,
+		SettingsName: result.Settings.Version,
+	} {
+		if version != FormatVersion {
+			return nil, nil, fmt.Errorf("%w: %s is version %d", ErrUnsupportedVersion, name, version)
+		}
+	}
+
+	if err := result.validate(time.Now()); err != nil {
+		return nil, nil, err
+	}
+
+	return result, &manifest, nil
+}
+
+// Mode is how an import treats what the user already has.
+type Mode string
+
+const (
+	// ModeMerge adds what the user doesn't have and keeps what they do
+	ModeMerge Mode = "merge"
+	// ModeReplace removes the user's library and mood entries first
+	ModeReplace Mode = "replace"
+)
+
+// Conflict is a day whose imported entry differs from the one the user
+// already has. The existing entry is kept.
+type Conflict struct {
+	Date string `json:"date"`
+	// Fields are the fields that differ
+	Fields []string `json:"fields"`
+}
+
+// Report says what an import changed.
+type Report struct {
+	Mode          Mode       `json:"mode"`
+	LibraryTracks int        `json:"library_tracks"`
+	IgnoredTracks int        `json:"ignored_tracks"`
+	Entries       int        `json:"entries"`
+	Unchanged     int        `json:"unchanged"`
+	Conflicts     []Conflict `json:"conflicts"`
+	// MissingTracks are imported tracks this server has no metadata for.
+	// Track metadata is never taken from an archive, they are shown as
+	// missing until a library sync fetches them from Spotify.
+	MissingTracks []string `json:"missing_tracks"`
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func differingFields(existing *models.MoodPlaylist, imported *models.MoodPlaylist) []string {
+	var result []string
+	if existing.StartMood != imported.StartMood {
+		result = append(result, "start_mood")
+	}
+	if existing.EndMood != imported.EndMood {
+		result = append(result, "end_mood")
+	}
+	if (existing.Note == nil) != (imported.Note == nil) ||
+		(existing.Note != nil && *existing.Note != *imported.Note) {
+		result = append(result, "note")
+	}
+	if !equalStrings(existing.Tags, imported.Tags) {
+		result = append(result, "tags")
+	}
+	if !equalStrings(existing.Tracks, imported.Tracks) {
+		result = append(result, "tracks")
+	}
+	return result
+}
+
+func (e Entry) playlist() *models.MoodPlaylist {
+	date, _ := time.Parse(models.DateFormat, e.Date)
+	result := &models.MoodPlaylist{
+		Date:      date,
+		Tracks:    e.Tracks,
+		StartMood: e.StartMood,
+		EndMood:   e.EndMood,
+		Note:      e.Note,
+	}
+	if len(e.Tags) > 0 {
+		result.Tags = e.Tags
+	}
+	return result
+}
+
+// Import adds an export read by Read to userId's data. Ignored tracks win
+// over library tracks, a track ignored on either side ends up ignored.
+// Everything is worked out before anything is written, and the user's
+// existing data is only removed in ModeReplace once the import is written.
+// Track metadata, secrets and granted scopes are never imported.
+func (e *Export) Import(dbConn *db.Database, userId string, mode Mode) (*Report, error) {
+	report := &Report{Mode: mode, Conflicts: []Conflict{}, MissingTracks: []string{}}
+	replace := mode == ModeReplace
+	staged := &db.UserImport{Library: map[string]models.MinTrack{}}
+
+	library := map[string]models.MinTrack{}
+	ignored := map[string]bool{}
+	_, err := dbConn.GetLibraryScan(userId)
+	if err == badger.ErrKeyNotFound || replace {
+		staged.Scan = &models.LibraryScan{CompletedScan: e.Library.CompletedScan}
+	} else if err != nil {
+		return nil, err
+	}
+	if !replace {
+		if library, err = dbConn.GetLibraryTracks(userId); err != nil {
+			return nil, err
+		}
+		existingIgnored, err := dbConn.GetIgnoredTracks(userId)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range existingIgnored {
+			ignored[id] = true
+		}
+	}
+
+	for _, track := range e.Ignored.Tracks {
+		if !ignored[track.Id] {
+			ignored[track.Id] = true
+			staged.Ignored = append(staged.Ignored, track.Id)
+			report.IgnoredTracks++
+		}
+	}
+	for _, track := range e.Library.Tracks {
+		if _, inLibrary := library[track.Id]; !inLibrary && !ignored[track.Id] {
+			library[track.Id] = models.MinTrack{Valence: track.Valence, Energy: track.Energy}
+			staged.Library[track.Id] = library[track.Id]
+			report.LibraryTracks++
+		}
+	}
+
+	referenced := map[string]bool{}
+	for id := range staged.Library {
+		referenced[id] = true
+	}
+	for _, entry := range e.Entries.Entries {
+		playlist := entry.playlist()
+		if !replace {
+			existing, err := dbConn.GetMoodPlaylist(userId, entry.Date)
+			if err == nil {
+				if fields := differingFields(existing, playlist); len(fields) > 0 {
+					report.Conflicts = append(report.Conflicts, Conflict{Date: entry.Date, Fields: fields})
+				} else {
+					report.Unchanged++
+				}
+				continue
+			} else if err != badger.ErrKeyNotFound {
+				return nil, err
+			}
+		}
+
+		staged.Playlists = append(staged.Playlists, playlist)
+		for _, id := range playlist.Tracks {
+			referenced[id] = true
+		}
+		report.Entries++
+	}
+
+	var ids []string
+	for id := range referenced {
+		ids = append(ids, id)
+	}
+	_, missing, err := dbConn.GetTracks(ids...)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(missing)
+	report.MissingTracks = append(report.MissingTracks, missing...)
+
+	if err := dbConn.ImportUserData(userId, staged, replace); err != nil {
+		return nil, err
+	}
+
+	if e.Settings.SpotifyPlaylistId != "" {
+		_, err := dbConn.GetSpotifyPlaylist(userId)
+		if err == badger.ErrKeyNotFound || replace {
+			if err := dbConn.SetSpotifyPlaylist(userId, e.Settings.SpotifyPlaylistId); err != nil {
+				return nil, err
+			}
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+// Import reads the export archive in r and adds it to userId's data. The
+// archive must have been exported by the same user.
+func Import(dbConn *db.Database, userId string, r io.ReaderAt, size int64, mode Mode) (*Report, error) {
+	export, manifest, err := Read(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest.UserId != userId {
+		return nil, ErrOtherUser
+	}
+
+	return export.Import(dbConn, userId, mode)
+}
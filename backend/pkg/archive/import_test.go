@@ -0,0 +1,236 @@
+package archive_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/sardap/TuneNeutral/backend/pkg/api"
+	"github.com/sardap/TuneNeutral/backend/pkg/archive"
+	"github.com/sardap/TuneNeutral/backend/pkg/db"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func day(date string) time.Time {
+	parsed, _ := time.Parse(models.DateFormat, date)
+	return parsed
+}
+
+func TestImport(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	source := newDatabase(t)
+	defer source.Close()
+	assert.NoError(t, source.PutTrack(&models.Track{Id: "a", Name: "A", Valence: 0.9, Energy: 0.2}))
+	assert.NoError(t, source.PutTrack(&models.Track{Id: "b", Name: "B", Valence: 0.1}))
+	assert.NoError(t, source.SaveLibraryPage(userId, &models.LibraryScan{CompletedScan: true}, map[string]models.MinTrack{
+		"a": {Valence: 0.9, Energy: 0.2},
+		"b": {Valence: 0.1},
+	}))
+	assert.NoError(t, source.RemoveLibraryTrack(userId, "b"))
+	note := "rainy"
+	for _, playlist := range []*models.MoodPlaylist{
+		{Date: day("2021-01-01"), StartMood: -0.25, Note: &note, Tags: []string{"work"}, Tracks: []string{"a"}},
+		{Date: day("2021-01-02"), StartMood: 0.1, Tracks: []string{"a"}},
+		{Date: day("2021-01-03"), StartMood: 0.2},
+	} {
+		assert.NoError(t, source.SetMoodPlaylist(userId, playlist))
+	}
+	assert.NoError(t, source.SetSpotifyPlaylist(userId, "playlist"))
+	buf := &bytes.Buffer{}
+	_, err := archive.Write(source, userId, buf, time.Now())
+	assert.NoError(t, err)
+	data := buf.Bytes()
+
+	target := newDatabase(t)
+	defer target.Close()
+	assert.NoError(t, target.SaveLibraryPage(userId, &models.LibraryScan{}, map[string]models.MinTrack{
+		"b": {Valence: 0.1},
+		"c": {Valence: 0.5},
+	}))
+	assert.NoError(t, target.SetMoodPlaylist(userId, &models.MoodPlaylist{Date: day("2021-01-02"), StartMood: 0.1, Tracks: []string{"a"}}))
+	assert.NoError(t, target.SetMoodPlaylist(userId, &models.MoodPlaylist{Date: day("2021-01-03"), StartMood: 0.3, Tags: []string{"sleep"}}))
+
+	// Run
+	report, err := archive.Import(target, userId, bytes.NewReader(data), int64(len(data)), archive.ModeMerge)
+	assert.NoError(t, err)
+	assert.Equal(t, &archive.Report{
+		Mode:          archive.ModeMerge,
+		LibraryTracks: 1,
+		IgnoredTracks: 1,
+		Entries:       1,
+		Unchanged:     1,
+		Conflicts:     []archive.Conflict{{Date: "2021-01-03", Fields: []string{"start_mood", "tags"}}},
+		MissingTracks: []string{"a"},
+	}, report)
+
+	library, _ := target.GetLibraryTracks(userId)
+	assert.Equal(t, map[string]models.MinTrack{"a": {Valence: 0.9, Energy: 0.2}, "c": {Valence: 0.5}}, library)
+	ignored, _ := target.GetIgnoredTracks(userId)
+	assert.Equal(t, []string{"b"}, ignored)
+	_, err = target.GetTrack("a")
+	assert.ErrorIs(t, err, badger.ErrKeyNotFound)
+	playlist, _ := target.GetMoodPlaylist(userId, "2021-01-01")
+	assert.Equal(t, &note, playlist.Note)
+	assert.Equal(t, []string{"work"}, playlist.Tags)
+	playlist, _ = target.GetMoodPlaylist(userId, "2021-01-03")
+	assert.Equal(t, float32(0.3), playlist.StartMood)
+	playlistId, _ := target.GetSpotifyPlaylist(userId)
+	assert.Equal(t, "playlist", playlistId)
+
+	// Importing again changes nothing
+	report, err = archive.Import(target, userId, bytes.NewReader(data), int64(len(data)), archive.ModeMerge)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.LibraryTracks+report.IgnoredTracks+report.Entries)
+	assert.Equal(t, 2, report.Unchanged)
+	assert.Len(t, report.Conflicts, 1)
+	assert.Empty(t, report.MissingTracks)
+
+	report, err = archive.Import(target, userId, bytes.NewReader(data), int64(len(data)), archive.ModeReplace)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Entries)
+	assert.Empty(t, report.Conflicts)
+	library, _ = target.GetLibraryTracks(userId)
+	assert.Equal(t, map[string]models.MinTrack{"a": {Valence: 0.9, Energy: 0.2}}, library)
+	scan, _ := target.GetLibraryScan(userId)
+	assert.True(t, scan.CompletedScan)
+	playlist, _ = target.GetMoodPlaylist(userId, "2021-01-03")
+	assert.Equal(t, float32(0.2), playlist.StartMood)
+	playlists, _, _ := target.QueryMoodPlaylists(userId, db.MoodPlaylistQuery{Tag: "work"})
+	assert.Len(t, playlists, 1)
+
+	_, err = archive.Import(target, "someone", bytes.NewReader(data), int64(len(data)), archive.ModeMerge)
+	assert.ErrorIs(t, err, archive.ErrOtherUser)
+	_, err = target.GetLibraryScan("someone")
+	assert.ErrorIs(t, err, badger.ErrKeyNotFound)
+}
+
+func rewrite(t *testing.T, data []byte, change func(name string, content []byte) []byte) []byte {
+	names, files := readFiles(t, data)
+
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for _, name := range names {
+		w, err := zw.Create(name)
+		assert.NoError(t, err)
+		w.Write(change(name, files[name]))
+	}
+	assert.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+// resign is rewrite that also updates the manifest to match the changed
+// documents. The manifest itself is changed last.
+func resign(t *testing.T, data []byte, change func(name string, content []byte) []byte) []byte {
+	_, files := readFiles(t, data)
+	var manifest archive.Manifest
+	assert.NoError(t, json.Unmarshal(files[archive.ManifestName], &manifest))
+	for i, file := range manifest.Files {
+		content := change(file.Name, files[file.Name])
+		sum := sha256.Sum256(content)
+		manifest.Files[i].Size = int64(len(content))
+		manifest.Files[i].Sha256 = hex.EncodeToString(sum[:])
+	}
+	signed, err := json.Marshal(manifest)
+	assert.NoError(t, err)
+
+	return rewrite(t, data, func(name string, content []byte) []byte {
+		if name == archive.ManifestName {
+			return change(name, signed)
+		}
+		return change(name, content)
+	})
+}
+
+func TestReadRejectsBadArchives(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	buf := &bytes.Buffer{}
+	_, err := archive.Write(dbConn, userId, buf, time.Now())
+	assert.NoError(t, err)
+	data := buf.Bytes()
+
+	type scenario struct {
+		data     []byte
+		expected error
+	}
+	scenarios := []scenario{
+		{
+			data:     []byte("not a zip"),
+			expected: archive.ErrInvalidArchive,
+		},
+		{
+			data: rewrite(t, data, func(name string, content []byte) []byte {
+				if name == archive.ManifestName {
+					return bytes.Replace(content, []byte(`"version": 1`), []byte(`"version": 2`), 1)
+				}
+				return content
+			}),
+			expected: archive.ErrUnsupportedVersion,
+		},
+		{
+			data: rewrite(t, data, func(name string, content []byte) []byte {
+				if name == archive.EntriesName {
+					return []byte(`{"version": 1, "entries": [{"date": "tomorrow"}]}`)
+				}
+				return content
+			}),
+			expected: archive.ErrChecksumMismatch,
+		},
+	}
+	for _, entries := range []string{
+		`{"version": 1, "entries": [{"start_mood": 0.1}]}`,
+		`{"version": 1, "entries": [{"date": "2021-1-1"}]}`,
+		`{"version": 1, "entries": [{"date": "2021-01-01", "note": "` + strings.Repeat("a", api.MaxNoteLength+1) + `"}]}`,
+		`{"version": 1, "entries": [{"date": "2021-01-01", "tags": ["a/b"]}]}`,
+		`{"version": 1, "entries": [{"date": "2021-01-01", "start_mood": 2}]}`,
+		`{"version": 1, "entries": [{"date": "2021-01-01", "end_mood": -2}]}`,
+		`{"version": 1, "entries": [{"date": "2021-01-01"}, {"date": "2021-01-01"}]}`,
+		`{"version": 1, "entries": [{"date": "2021-01-01", "tracks": ["../a"]}]}`,
+	} {
+		entries := entries
+		scenarios = append(scenarios, scenario{
+			data: resign(t, data, func(name string, content []byte) []byte {
+				if name == archive.EntriesName {
+					return []byte(entries)
+				}
+				return content
+			}),
+			expected: archive.ErrInvalidArchive,
+		})
+	}
+	scenarios = append(scenarios, scenario{
+		data: resign(t, data, func(name string, content []byte) []byte {
+			if name == archive.ManifestName {
+				var manifest archive.Manifest
+				json.Unmarshal(content, &manifest)
+				manifest.Files = append(manifest.Files, manifest.Files[0])
+				content, _ = json.Marshal(manifest)
+			}
+			return content
+		}),
+		expected: archive.ErrInvalidArchive,
+	})
+	for _, scenario := range scenarios {
+		// Run
+		_, _, err := archive.Read(bytes.NewReader(scenario.data), int64(len(scenario.data)))
+
+		assert.ErrorIs(t, err, scenario.expected)
+	}
+
+	for _, data := range [][]byte{data, resign(t, data, func(name string, content []byte) []byte { return content })} {
+		_, _, err = archive.Read(bytes.NewReader(data), int64(len(data)))
+		assert.NoError(t, err)
+	}
+}
@@ -0,0 +1,315 @@
+package backup
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/db"
+)
+
+const (
+	FormatVersion = 1
+
+	manifestName = "manifest.json"
+	dataName     = "badger.bak"
+
+	filePrefix     = "tune-neutral-"
+	fileSuffix     = ".tar"
+	fileTimeFormat = "20060102T150405Z"
+)
+
+var (
+	ErrInvalidManifest  = fmt.Errorf("invalid manifest")
+	ErrChecksumMismatch = fmt.Errorf("checksum mismatch")
+)
+
+// Manifest describes the badger backup stored next to it in a backup archive.
+// Data written with an older SchemaVersion is migrated once it is restored.
+type Manifest struct {
+	Version       int       `json:"version"`
+	CreatedAt     time.Time `json:"created_at"`
+	ReadTs        uint64    `json:"read_ts"`
+	SchemaVersion uint64    `json:"schema_version"`
+	Size          int64     `json:"size"`
+	Sha256        string    `json:"sha256"`
+}
+
+func (m *Manifest) Valid() error {
+	if m.Version != FormatVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidManifest, m.Version)
+	}
+
+	if m.CreatedAt.IsZero() {
+		return fmt.Errorf("%w: created_at must be set", ErrInvalidManifest)
+	}
+
+	if m.SchemaVersion > db.LatestSchemaVersion() {
+		return fmt.Errorf("%w: schema version %d is newer than this server's %d", ErrInvalidManifest, m.SchemaVersion, db.LatestSchemaVersion())
+	}
+
+	if m.Size < 0 {
+		return fmt.Errorf("%w: size must not be negative", ErrInvalidManifest)
+	}
+
+	if sum, err := hex.DecodeString(m.Sha256); err != nil || len(sum) != sha256.Size {
+		return fmt.Errorf("%w: sha256 must be a hex encoded sha256 sum", ErrInvalidManifest)
+	}
+
+	return nil
+}
+
+// Write streams a backup archive of dbConn to w. The badger backup is staged in
+// a temporary file first so the manifest, which leads the archive, can carry the
+// size and checksum of the data.
+func Write(dbConn *db.Database, w io.Writer) (*Manifest, error) {
+	tmp, err := os.CreateTemp("", "tune-neutral-backup-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	schemaVersion, err := dbConn.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	readTs, err := dbConn.Backup(io.MultiWriter(tmp, hash))
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{
+		Version:       FormatVersion,
+		CreatedAt:     time.Now().UTC(),
+		ReadTs:        readTs,
+		SchemaVersion: schemaVersion,
+		Size:          size,
+		Sha256:        hex.EncodeToString(hash.Sum(nil)),
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	tw := tar.NewWriter(w)
+	err = tw.WriteHeader(&tar.Header{
+		Name:    manifestName,
+		Mode:    0600,
+		Size:    int64(len(manifestData)),
+		ModTime: manifest.CreatedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(manifestData); err != nil {
+		return nil, err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    dataName,
+		Mode:    0600,
+		Size:    size,
+		ModTime: manifest.CreatedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(tw, tmp); err != nil {
+		return nil, err
+	}
+
+	return manifest, tw.Close()
+}
+
+func readManifest(tr *tar.Reader) (*Manifest, error) {
+	header, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidManifest, err)
+	}
+
+	if header.Name != manifestName {
+		return nil, fmt.Errorf("%w: archive must start with %s", ErrInvalidManifest, manifestName)
+	}
+
+	var manifest Manifest
+	if err := json.NewDecoder(io.LimitReader(tr, 1<<20)).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidManifest, err)
+	}
+
+	if err := manifest.Valid(); err != nil {
+		return nil, err
+	}
+
+	return &manifest, nil
+}
+
+// Verify checks the manifest and data checksum of a backup archive without
+// touching a database.
+func Verify(r io.Reader) (*Manifest, error) {
+	tr := tar.NewReader(r)
+
+	manifest, err := readManifest(tr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkData(tr, manifest, io.Discard); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+func checkData(tr *tar.Reader, manifest *Manifest, w io.Writer) error {
+	header, err := tr.Next()
+	if err != nil {
+		return fmt.Errorf("%w: missing %s", ErrInvalidManifest, dataName)
+	}
+
+	if header.Name != dataName || header.Size != manifest.Size {
+		return fmt.Errorf("%w: %s does not match manifest", ErrInvalidManifest, dataName)
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, hash), tr); err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != manifest.Sha256 {
+		return ErrChecksumMismatch
+	}
+
+	return nil
+}
+
+// Restore replaces the contents of dbConn with the backup archive read from r
+// and migrates it to the current schema. Nothing is written to the database
+// until the manifest and checksum have been validated.
+func Restore(dbConn *db.Database, r io.Reader) (*Manifest, error) {
+	tr := tar.NewReader(r)
+
+	manifest, err := readManifest(tr)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp("", "tune-neutral-restore-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := checkData(tr, manifest, tmp); err != nil {
+		return nil, err
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	if err := dbConn.Restore(tmp); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+func fileName(t time.Time) string {
+	return filePrefix + t.UTC().Format(fileTimeFormat) + fileSuffix
+}
+
+// WriteFile writes a backup archive into dir and returns its path.
+func WriteFile(dbConn *db.Database, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	target := filepath.Join(dir, fileName(time.Now()))
+
+	f, err := os.CreateTemp(dir, ".tune-neutral-*.partial")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := Write(dbConn, f); err != nil {
+		f.Close()
+		return "", err
+	}
+
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	return target, os.Rename(f.Name(), target)
+}
+
+// Rotate deletes all but the newest retain backups in dir.
+func Rotate(dir string, retain int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		backups = append(backups, name)
+	}
+
+	if len(backups) <= retain {
+		return nil
+	}
+
+	// The timestamp format sorts lexically
+	sort.Strings(backups)
+	for _, name := range backups[:len(backups)-retain] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func Scheduled(dbConn *db.Database, dir string, interval time.Duration, retain int) {
+	for {
+		time.Sleep(interval)
+
+		path, err := WriteFile(dbConn, dir)
+		if err != nil {
+			log.Printf("Scheduled backup failed: %v", err)
+			continue
+		}
+		log.Printf("Wrote backup %s", path)
+
+		if err := Rotate(dir, retain); err != nil {
+			log.Printf("Backup rotation failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package backup_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/sardap/TuneNeutral/backend/pkg/backup"
+	"github.com/sardap/TuneNeutral/backend/pkg/config"
+	"github.com/sardap/TuneNeutral/backend/pkg/db"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func newDatabase(t *testing.T) *db.Database {
+	cfg := &config.Config{
+		DatabasePath: path.Join(t.TempDir(), "database"),
+	}
+
+	return db.ConnectDb(cfg)
+}
+
+func TestWriteRestore(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	dbConn.PutTrack(&models.Track{Id: "please", Name: "please"})
+
+	buf := &bytes.Buffer{}
+	manifest, err := backup.Write(dbConn, buf)
+	assert.NoError(t, err)
+	assert.NoError(t, manifest.Valid())
+	assert.Equal(t, db.LatestSchemaVersion(), manifest.SchemaVersion)
+
+	dbConn.PutTrack(&models.Track{Id: "hire", Name: "hire"})
+
+	// Run
+	restored, err := backup.Restore(dbConn, bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, manifest.Sha256, restored.Sha256)
+
+	assert.True(t, dbConn.TrackExists("please"))
+	assert.ErrorIs(t, func() error { _, err := dbConn.GetTrack("hire"); return err }(), badger.ErrKeyNotFound)
+}
+
+func TestRestoreRejectsBadArchives(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	dbConn.PutTrack(&models.Track{Id: "please", Name: "please"})
+
+	buf := &bytes.Buffer{}
+	manifest, err := backup.Write(dbConn, buf)
+	assert.NoError(t, err)
+
+	rebuild := func(manifest backup.Manifest, data []byte) io.Reader {
+		out := &bytes.Buffer{}
+		tw := tar.NewWriter(out)
+		manifestData, _ := json.Marshal(manifest)
+		tw.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0600, Size: int64(len(manifestData))})
+		tw.Write(manifestData)
+		tw.WriteHeader(&tar.Header{Name: "badger.bak", Mode: 0600, Size: int64(len(data))})
+		tw.Write(data)
+		tw.Close()
+		return out
+	}
+
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	tr.Next()
+	tr.Next()
+	data, _ := io.ReadAll(tr)
+
+	type scenario struct {
+		archive     io.Reader
+		expectedErr error
+	}
+	scenarios := []scenario{
+		{
+			archive:     bytes.NewReader([]byte("not a backup")),
+			expectedErr: backup.ErrInvalidManifest,
+		},
+		{
+			archive: func() io.Reader {
+				badVersion := *manifest
+				badVersion.Version = backup.FormatVersion + 1
+				return rebuild(badVersion, data)
+			}(),
+			expectedErr: backup.ErrInvalidManifest,
+		},
+		{
+			archive: func() io.Reader {
+				newerSchema := *manifest
+				newerSchema.SchemaVersion = db.LatestSchemaVersion() + 1
+				return rebuild(newerSchema, data)
+			}(),
+			expectedErr: backup.ErrInvalidManifest,
+		},
+		{
+			archive: func() io.Reader {
+				corrupt := append([]byte{}, data...)
+				corrupt[len(corrupt)-1] ^= 0xff
+				return rebuild(*manifest, corrupt)
+			}(),
+			expectedErr: backup.ErrChecksumMismatch,
+		},
+	}
+	for _, scenario := range scenarios {
+		dbConn.PutTrack(&models.Track{Id: "hire", Name: "hire"})
+
+		_, err := backup.Restore(dbConn, scenario.archive)
+
+		assert.ErrorIs(t, err, scenario.expectedErr)
+		// A rejected archive must not touch the database
+		assert.True(t, dbConn.TrackExists("hire"))
+	}
+}
+
+func TestRotate(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dir := t.TempDir()
+	names := []string{
+		"tune-neutral-20220101T000000Z.tar",
+		"tune-neutral-20220102T000000Z.tar",
+		"tune-neutral-20220103T000000Z.tar",
+		"unrelated.txt",
+	}
+	for _, name := range names {
+		os.WriteFile(path.Join(dir, name), []byte{}, 0600)
+	}
+
+	// Run
+	assert.NoError(t, backup.Rotate(dir, 2))
+
+	entries, _ := os.ReadDir(dir)
+	var remaining []string
+	for _, entry := range entries {
+		remaining = append(remaining, entry.Name())
+	}
+	assert.ElementsMatch(t, names[1:], remaining)
+}
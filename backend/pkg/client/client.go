@@ -0,0 +1,129 @@
+// Package client is a typed client for the REST API described by
+// pkg/router/openapi.json.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/apitypes"
+)
+
+// Client calls the API as the owner of a personal access token, or as the
+// admin when given the server's admin token.
+type Client struct {
+	baseURL string
+	token   string
+	// HTTPClient sends the requests, http.DefaultClient when nil
+	HTTPClient *http.Client
+}
+
+// New returns a client for the server at baseURL, such as
+// https://tune.example.
+func New(baseURL, token string) *Client {
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+	}
+}
+
+// Error is an error response from the server. It matches any error with an
+// ErrorCode method returning the same code with errors.Is, such as the api.Err
+// sentinels.
+type Error struct {
+	StatusCode int                   `json:"-"`
+	Code       apitypes.ErrorCode    `json:"code"`
+	Message    string                `json:"message"`
+	Details    []apitypes.FieldError `json:"details"`
+	Meta       map[string]string     `json:"meta"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+func (e *Error) ErrorCode() apitypes.ErrorCode {
+	return e.Code
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(interface{ ErrorCode() apitypes.ErrorCode })
+	return ok && t.ErrorCode() == e.Code
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// send makes the request and returns the response when it succeeded, any
+// other status is returned as an *Error.
+func (c *Client) send(ctx context.Context, method, path string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+
+		var envelope struct {
+			Error *Error `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil || envelope.Error == nil {
+			return nil, &Error{StatusCode: resp.StatusCode, Code: apitypes.CodeServerError, Message: resp.Status}
+		}
+		envelope.Error.StatusCode = resp.StatusCode
+		return nil, envelope.Error
+	}
+
+	return resp, nil
+}
+
+// do sends in as JSON when it is not nil and decodes the result into out
+// when it is not nil.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	contentType := ""
+	if in != nil {
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(encoded)
+		contentType = "application/json"
+	}
+
+	resp, err := c.send(ctx, method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+
+	envelope := struct {
+		Result interface{} `json:"result"`
+	}{Result: out}
+	return json.NewDecoder(resp.Body).Decode(&envelope)
+}
@@ -0,0 +1,501 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// MoodPlaylists gets a page of mood playlists. Set query.Cursor to the
+// page's NextCursor for the next one.
+func (c *Client) MoodPlaylists(ctx context.Context, query PlaylistQuery) (*PlaylistPage, error) {
+	values := url.Values{}
+	for name, value := range map[string]string{"from": query.From, "to": query.To, "cursor": query.Cursor, "tag": query.Tag} {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+	if query.Descending {
+		values.Set("order", "desc")
+	}
+	if query.Limit > 0 {
+		values.Set("limit", strconv.Itoa(query.Limit))
+	}
+
+	var result PlaylistPage
+	if err := c.do(ctx, http.MethodGet, "/v1/api/mood_playlists?"+values.Encode(), nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// MoodPlaylist gets the playlist for date, which is YYYY-MM-DD.
+func (c *Client) MoodPlaylist(ctx context.Context, date string) (*MoodPlaylist, error) {
+	var result MoodPlaylist
+	if err := c.do(ctx, http.MethodGet, "/v1/api/mood_playlist/"+url.PathEscape(date), nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// UpdateNote replaces the note of the mood playlist for date.
+func (c *Client) UpdateNote(ctx context.Context, date, note string) (*Playlist, error) {
+	request := struct {
+		Note string `json:"note"`
+	}{Note: note}
+
+	var result Playlist
+	if err := c.do(ctx, http.MethodPatch, "/v1/api/mood_playlist/"+url.PathEscape(date), request, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SearchNotes finds the days whose notes match q, newest first. Empty dates
+// and a zero limit use the server's defaults.
+func (c *Client) SearchNotes(ctx context.Context, q, from, to string, limit int) ([]SearchResult, error) {
+	values := url.Values{"q": {q}}
+	if from != "" {
+		values.Set("from", from)
+	}
+	if to != "" {
+		values.Set("to", to)
+	}
+	if limit > 0 {
+		values.Set("limit", strconv.Itoa(limit))
+	}
+
+	var result struct {
+		Results []SearchResult `json:"results"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v1/api/search?"+values.Encode(), nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Results, nil
+}
+
+// ExportMoodPlaylist writes the mood playlist for date to w in format, one
+// of m3u8, xspf, jspf or csv.
+func (c *Client) ExportMoodPlaylist(ctx context.Context, date, format string, w io.Writer) error {
+	path := "/v1/api/mood_playlist/" + url.PathEscape(date) + "/export?" + url.Values{"format": {format}}.Encode()
+	resp, err := c.send(ctx, http.MethodGet, path, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// SetTags replaces the tags of the mood playlist for date.
+func (c *Client) SetTags(ctx context.Context, date string, tags []string) (*Playlist, error) {
+	request := struct {
+		Tags []string `json:"tags"`
+	}{Tags: tags}
+	if request.Tags == nil {
+		request.Tags = []string{}
+	}
+
+	var result Playlist
+	err := c.do(ctx, http.MethodPut, "/v1/api/mood_playlist/"+url.PathEscape(date)+"/tags", request, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Tags lists the tags the user has used, most used first.
+func (c *Client) Tags(ctx context.Context) ([]TagSummary, error) {
+	var result struct {
+		Tags []TagSummary `json:"tags"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v1/api/tags", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Tags, nil
+}
+
+// RenameTag renames tag to replacement on every entry and returns how many
+// entries changed.
+func (c *Client) RenameTag(ctx context.Context, tag, replacement string) (int, error) {
+	request := struct {
+		Tag string `json:"tag"`
+	}{Tag: replacement}
+
+	var result struct {
+		Changed int `json:"changed"`
+	}
+	if err := c.do(ctx, http.MethodPatch, "/v1/api/tags/"+url.PathEscape(tag), request, &result); err != nil {
+		return 0, err
+	}
+	return result.Changed, nil
+}
+
+// DeleteTag removes tag from every entry and returns how many entries
+// changed.
+func (c *Client) DeleteTag(ctx context.Context, tag string) (int, error) {
+	var result struct {
+		Changed int `json:"changed"`
+	}
+	if err := c.do(ctx, http.MethodDelete, "/v1/api/tags/"+url.PathEscape(tag), nil, &result); err != nil {
+		return 0, err
+	}
+	return result.Changed, nil
+}
+
+// CreateShareLink shares the mood playlist for date with a public link.
+// expiresInDays of 0 makes a link that never expires.
+func (c *Client) CreateShareLink(ctx context.Context, date string, hideNote bool, expiresInDays int) (*CreatedShareLink, error) {
+	request := struct {
+		HideNote      bool `json:"hide_note"`
+		ExpiresInDays *int `json:"expires_in_days,omitempty"`
+	}{HideNote: hideNote}
+	if expiresInDays != 0 {
+		request.ExpiresInDays = &expiresInDays
+	}
+
+	var result CreatedShareLink
+	err := c.do(ctx, http.MethodPost, "/v1/api/mood_playlist/"+url.PathEscape(date)+"/shares", request, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ShareLinks lists the user's share links newest first, only those for date
+// when it isn't empty.
+func (c *Client) ShareLinks(ctx context.Context, date string) ([]ShareLink, error) {
+	path := "/v1/api/shares"
+	if date != "" {
+		path += "?" + url.Values{"date": {date}}.Encode()
+	}
+
+	var result struct {
+		Shares []ShareLink `json:"shares"`
+	}
+	if err := c.do(ctx, http.MethodGet, path, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Shares, nil
+}
+
+func (c *Client) RevokeShareLink(ctx context.Context, shareId string) error {
+	return c.do(ctx, http.MethodDelete, "/v1/api/shares/"+url.PathEscape(shareId), nil, nil)
+}
+
+// SharedPlaylist opens a share link. It needs no credentials, only the
+// link's token.
+func (c *Client) SharedPlaylist(ctx context.Context, token string) (*SharedPlaylist, error) {
+	var result SharedPlaylist
+	if err := c.do(ctx, http.MethodGet, "/v1/api/shared/"+url.PathEscape(token), nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Groups lists the groups the user is in, oldest first.
+func (c *Client) Groups(ctx context.Context) ([]Group, error) {
+	var result struct {
+		Groups []Group `json:"groups"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v1/api/groups", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Groups, nil
+}
+
+func (c *Client) Group(ctx context.Context, groupId string) (*Group, error) {
+	var result Group
+	if err := c.do(ctx, http.MethodGet, "/v1/api/groups/"+url.PathEscape(groupId), nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// CreateGroup makes a group owned by the user. The owner still has to
+// consent before their library is used.
+func (c *Client) CreateGroup(ctx context.Context, name string) (*Group, error) {
+	request := struct {
+		Name string `json:"name"`
+	}{Name: name}
+
+	var result Group
+	if err := c.do(ctx, http.MethodPost, "/v1/api/groups", request, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// CreateGroupInvite returns a new invite code for the group, replacing the
+// last one. Only the owner can invite.
+func (c *Client) CreateGroupInvite(ctx context.Context, groupId string) (string, error) {
+	var result struct {
+		Code string `json:"code"`
+	}
+	err := c.do(ctx, http.MethodPost, "/v1/api/groups/"+url.PathEscape(groupId)+"/invite", nil, &result)
+	if err != nil {
+		return "", err
+	}
+	return result.Code, nil
+}
+
+// RemoveGroupMember takes userId out of the group, the user's own id leaves
+// it.
+func (c *Client) RemoveGroupMember(ctx context.Context, groupId, userId string) error {
+	path := "/v1/api/groups/" + url.PathEscape(groupId) + "/members/" + url.PathEscape(userId)
+	return c.do(ctx, http.MethodDelete, path, nil, nil)
+}
+
+// GenerateGroupPlaylist makes a playlist from the libraries of the group's
+// consenting members.
+func (c *Client) GenerateGroupPlaylist(ctx context.Context, groupId string, request GenerateGroupPlaylistRequest) (*GroupPlaylist, error) {
+	var result GroupPlaylist
+	err := c.do(ctx, http.MethodPost, "/v1/api/groups/"+url.PathEscape(groupId)+"/playlists", request, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *Client) GroupPlaylist(ctx context.Context, groupId, date string) (*GroupPlaylist, error) {
+	var result GroupPlaylist
+	path := "/v1/api/groups/" + url.PathEscape(groupId) + "/playlists/" + url.PathEscape(date)
+	if err := c.do(ctx, http.MethodGet, path, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *Client) RemovedTracks(ctx context.Context) (*TrackList, error) {
+	var result TrackList
+	if err := c.do(ctx, http.MethodGet, "/v1/api/removed_tracks", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SpotifyPlaylist is the id of the Spotify playlist mood playlists are synced
+// to, empty until the first sync.
+func (c *Client) SpotifyPlaylist(ctx context.Context) (string, error) {
+	var result struct {
+		Id string `json:"id"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v1/api/spotify_playlist", nil, &result); err != nil {
+		return "", err
+	}
+	return result.Id, nil
+}
+
+// AllData is everything stored about the user as the server stores it.
+func (c *Client) AllData(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	if err := c.do(ctx, http.MethodGet, "/v1/api/all_data", nil, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c *Client) GenerateMoodPlaylist(ctx context.Context, request GenerateMoodPlaylistRequest) error {
+	return c.do(ctx, http.MethodPost, "/v1/api/generate_mood_playlist", request, &struct{}{})
+}
+
+// UpdateSpotifyPlaylist copies the mood playlist for date to the user's
+// Spotify playlist.
+func (c *Client) UpdateSpotifyPlaylist(ctx context.Context, date string) error {
+	return c.do(ctx, http.MethodPost, "/v1/api/update_playlist/"+url.PathEscape(date), nil, nil)
+}
+
+func (c *Client) RemoveTrack(ctx context.Context, trackId string) error {
+	return c.do(ctx, http.MethodPost, "/v1/api/remove_track/"+url.PathEscape(trackId), nil, nil)
+}
+
+func (c *Client) UnremoveTrack(ctx context.Context, trackId string) error {
+	return c.do(ctx, http.MethodPost, "/v1/api/unremove_track/"+url.PathEscape(trackId), nil, nil)
+}
+
+func (c *Client) Scopes(ctx context.Context) (*Scopes, error) {
+	var result Scopes
+	if err := c.do(ctx, http.MethodGet, "/v1/api/scopes", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *Client) LibraryReport(ctx context.Context) (*LibraryReport, error) {
+	var result LibraryReport
+	if err := c.do(ctx, http.MethodGet, "/v1/api/library/report", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// MoodStats summarises the moods reported from from to to, both YYYY-MM-DD.
+// Empty dates and a zero window use the server's defaults and a non empty tag
+// only counts entries with it.
+func (c *Client) MoodStats(ctx context.Context, from, to string, window int, tag string) (*MoodStats, error) {
+	values := url.Values{}
+	if from != "" {
+		values.Set("from", from)
+	}
+	if to != "" {
+		values.Set("to", to)
+	}
+	if window > 0 {
+		values.Set("window", strconv.Itoa(window))
+	}
+	if tag != "" {
+		values.Set("tag", tag)
+	}
+
+	var result MoodStats
+	if err := c.do(ctx, http.MethodGet, "/v1/api/stats/mood?"+values.Encode(), nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Sessions, access tokens and group membership can only be managed, and user
+// data only removed, from a browser session so the methods below only work
+// with a client whose HTTPClient carries the session cookie.
+
+func (c *Client) RemoveAllUserData(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "/v1/api/remove_all_user_data", nil, nil)
+}
+
+func (c *Client) Sessions(ctx context.Context) ([]Session, error) {
+	var result struct {
+		Sessions []Session `json:"sessions"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v1/api/sessions", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Sessions, nil
+}
+
+func (c *Client) RevokeSession(ctx context.Context, sessionId string) error {
+	return c.do(ctx, http.MethodDelete, "/v1/api/sessions/"+url.PathEscape(sessionId), nil, nil)
+}
+
+func (c *Client) RevokeAllSessions(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "/v1/api/sessions", nil, nil)
+}
+
+func (c *Client) AccessTokens(ctx context.Context) ([]AccessToken, error) {
+	var result struct {
+		Tokens []AccessToken `json:"tokens"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v1/api/tokens", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Tokens, nil
+}
+
+func (c *Client) CreateAccessToken(ctx context.Context, name string, scopes ...string) (*CreatedAccessToken, error) {
+	request := struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}{Name: name, Scopes: scopes}
+
+	var result CreatedAccessToken
+	if err := c.do(ctx, http.MethodPost, "/v1/api/tokens", request, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *Client) RevokeAccessToken(ctx context.Context, tokenId string) error {
+	return c.do(ctx, http.MethodDelete, "/v1/api/tokens/"+url.PathEscape(tokenId), nil, nil)
+}
+
+// JoinGroup joins the group code invites to. Joining doesn't consent to the
+// group using the user's library.
+func (c *Client) JoinGroup(ctx context.Context, code string) (*Group, error) {
+	request := struct {
+		Code string `json:"code"`
+	}{Code: code}
+
+	var result Group
+	if err := c.do(ctx, http.MethodPost, "/v1/api/groups/join", request, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SetGroupConsent gives or withdraws consent to the group using the user's
+// library.
+func (c *Client) SetGroupConsent(ctx context.Context, groupId string, consent bool) (*Group, error) {
+	request := struct {
+		Consent bool `json:"consent"`
+	}{Consent: consent}
+
+	var result Group
+	err := c.do(ctx, http.MethodPut, "/v1/api/groups/"+url.PathEscape(groupId)+"/consent", request, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Export writes a zip archive of everything stored about the user to w.
+func (c *Client) Export(ctx context.Context, w io.Writer) error {
+	resp, err := c.send(ctx, http.MethodGet, "/v1/api/export", "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Import adds the export archive in r to the user's data.
+func (c *Client) Import(ctx context.Context, r io.Reader, mode ImportMode) (*ImportReport, error) {
+	path := "/v1/api/import?" + url.Values{"mode": {string(mode)}}.Encode()
+	resp, err := c.send(ctx, http.MethodPost, path, "application/zip", r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Result *ImportReport `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return body.Result, nil
+}
+
+// Backup writes a backup of the database to w. It needs the admin token.
+func (c *Client) Backup(ctx context.Context, w io.Writer) error {
+	resp, err := c.send(ctx, http.MethodGet, "/admin/backup", "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Restore replaces the database with the backup in r. It needs the admin
+// token.
+func (c *Client) Restore(ctx context.Context, r io.Reader) (*BackupManifest, error) {
+	resp, err := c.send(ctx, http.MethodPost, "/admin/restore", "application/x-tar", r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Result *BackupManifest `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return body.Result, nil
+}
@@ -0,0 +1,285 @@
+package client
+
+import (
+	"time"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/apitypes"
+)
+
+type IdNamePair struct {
+	Name string `json:"name"`
+	Id   string `json:"id"`
+}
+
+type Album struct {
+	IdNamePair
+	Url string `json:"url"`
+}
+
+type Track struct {
+	IdNamePair
+	Mood    float32      `json:"mood"`
+	Album   Album        `json:"album"`
+	Artists []IdNamePair `json:"artists"`
+}
+
+type Playlist struct {
+	Date      time.Time `json:"date"`
+	StartMood float32   `json:"start_mood"`
+	Note      *string   `json:"note"`
+	Tags      []string  `json:"tags"`
+}
+
+// PlaylistQuery filters mood playlists, the zero value is the first page
+// oldest first.
+type PlaylistQuery struct {
+	// From and To are YYYY-MM-DD and inclusive
+	From       string
+	To         string
+	Descending bool
+	Limit      int
+	Cursor     string
+	// Tag is key or key:value, a key on its own matches every value
+	Tag string
+}
+
+type PlaylistPage struct {
+	Playlists []Playlist `json:"playlists"`
+	// NextCursor is nil on the last page
+	NextCursor *string `json:"next_cursor"`
+}
+
+type MoodPlaylist struct {
+	Tracks []Track `json:"tracks"`
+	// MissingTracks are ids of tracks that are no longer stored
+	MissingTracks []string `json:"missing_tracks"`
+	StartMood     float32  `json:"start_mood"`
+	Note          *string  `json:"note"`
+	Tags          []string `json:"tags"`
+}
+
+type TrackList struct {
+	Tracks        []Track  `json:"tracks"`
+	MissingTracks []string `json:"missing_tracks"`
+}
+
+type GenerateMoodPlaylistRequest struct {
+	Mood float32 `json:"mood"`
+	// Date is YYYY-MM-DD, today when empty
+	Date string `json:"date,omitempty"`
+	Note string `json:"note,omitempty"`
+}
+
+type Scopes struct {
+	Scopes   []string           `json:"scopes"`
+	Features []apitypes.Feature `json:"features"`
+}
+
+// Segment is a piece of a search snippet, Match is set on the words that
+// matched the query.
+type Segment struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match"`
+}
+
+type SearchResult struct {
+	Date      string    `json:"date"`
+	StartMood float32   `json:"start_mood"`
+	Snippet   []Segment `json:"snippet"`
+}
+
+type TagSummary struct {
+	Tag         string  `json:"tag"`
+	Key         string  `json:"key"`
+	Value       string  `json:"value"`
+	Entries     int     `json:"entries"`
+	AverageMood float32 `json:"average_mood"`
+}
+
+type BucketCount struct {
+	Level  float32 `json:"level"`
+	Tracks int     `json:"tracks"`
+	// Available leaves out tracks in cooldown
+	Available int `json:"available"`
+}
+
+type LibraryWarning struct {
+	Mood    float32 `json:"mood"`
+	Message string  `json:"message"`
+}
+
+type LibraryReport struct {
+	Tracks        int  `json:"tracks"`
+	Ignored       int  `json:"ignored"`
+	Cooldown      int  `json:"cooldown"`
+	CompletedScan bool `json:"completed_scan"`
+	// Histogram counts tracks by valence then energy
+	Histogram [][]int       `json:"histogram"`
+	Moods     []BucketCount `json:"moods"`
+	Energies  []BucketCount `json:"energies"`
+	// Warnings are the mood buckets too thin to fill a playlist
+	Warnings []LibraryWarning `json:"warnings"`
+}
+
+type RollingAverage struct {
+	Date    string  `json:"date"`
+	Average float32 `json:"average"`
+}
+
+type WeekdayStats struct {
+	Weekday    string   `json:"weekday"`
+	LoggedDays int      `json:"logged_days"`
+	Average    *float32 `json:"average"`
+}
+
+type MoodCount struct {
+	Mood       float32 `json:"mood"`
+	LoggedDays int     `json:"logged_days"`
+}
+
+type Streaks struct {
+	Current int `json:"current"`
+	Longest int `json:"longest"`
+}
+
+type MoodStats struct {
+	From                  string           `json:"from"`
+	To                    string           `json:"to"`
+	Window                int              `json:"window"`
+	LoggedDays            int              `json:"logged_days"`
+	Average               *float32         `json:"average"`
+	AverageTargetDistance *float32         `json:"average_target_distance"`
+	RollingAverage        []RollingAverage `json:"rolling_average"`
+	// Weekdays starts on Monday
+	Weekdays     []WeekdayStats `json:"weekdays"`
+	Streaks      Streaks        `json:"streaks"`
+	Distribution []MoodCount    `json:"distribution"`
+}
+
+type Session struct {
+	Id        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UserAgent string    `json:"user_agent"`
+	Ip        string    `json:"ip"`
+	Current   bool      `json:"current"`
+}
+
+type AccessToken struct {
+	Id        string     `json:"id"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	LastUsed  *time.Time `json:"last_used"`
+}
+
+type CreatedAccessToken struct {
+	AccessToken
+	// Token is the secret, the server never shows it again
+	Token string `json:"token"`
+}
+
+type ShareLink struct {
+	Id string `json:"id"`
+	// Date is the YYYY-MM-DD day of the shared playlist
+	Date      string    `json:"date"`
+	HideNote  bool      `json:"hide_note"`
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt is nil for a link that never expires
+	ExpiresAt    *time.Time `json:"expires_at"`
+	Accesses     int        `json:"accesses"`
+	LastAccessed *time.Time `json:"last_accessed"`
+}
+
+type CreatedShareLink struct {
+	ShareLink
+	// Token is the secret, the server never shows it again
+	Token string `json:"token"`
+	// Url opens the shared playlist on the website
+	Url string `json:"url"`
+}
+
+// SharedPlaylist is what anyone with a share link sees.
+type SharedPlaylist struct {
+	Date      string  `json:"date"`
+	StartMood float32 `json:"start_mood"`
+	// Note is nil when the link hides it
+	Note      *string    `json:"note"`
+	Tracks    []Track    `json:"tracks"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type GroupMember struct {
+	UserId   string    `json:"user_id"`
+	JoinedAt time.Time `json:"joined_at"`
+	// Consented is whether the member's library can be used
+	Consented   bool       `json:"consented"`
+	ConsentedAt *time.Time `json:"consented_at"`
+}
+
+type Group struct {
+	Id        string        `json:"id"`
+	Name      string        `json:"name"`
+	OwnerId   string        `json:"owner_id"`
+	CreatedAt time.Time     `json:"created_at"`
+	Members   []GroupMember `json:"members"`
+}
+
+type GenerateGroupPlaylistRequest struct {
+	// Mode is intersection or union, intersection when empty
+	Mode string `json:"mode,omitempty"`
+	// Moods are keyed by the user id of consenting members
+	Moods map[string]float32 `json:"moods"`
+	// Date is YYYY-MM-DD, today when empty
+	Date string `json:"date,omitempty"`
+}
+
+type GroupPlaylist struct {
+	Date      string             `json:"date"`
+	Mode      string             `json:"mode"`
+	Moods     map[string]float32 `json:"moods"`
+	StartMood float32            `json:"start_mood"`
+	EndMood   float32            `json:"end_mood"`
+	// Members are the users whose libraries were used
+	Members       []string `json:"members"`
+	Tracks        []Track  `json:"tracks"`
+	MissingTracks []string `json:"missing_tracks"`
+	CreatedBy     string   `json:"created_by"`
+}
+
+// ImportMode decides what happens to the user's existing data on import.
+type ImportMode string
+
+const (
+	// ImportMerge adds what the user doesn't have and keeps what they do
+	ImportMerge ImportMode = "merge"
+	// ImportReplace removes the user's library and mood entries first
+	ImportReplace ImportMode = "replace"
+)
+
+// ImportConflict is a day whose imported entry differs from the one the user
+// already has. The existing entry is kept.
+type ImportConflict struct {
+	Date   string   `json:"date"`
+	Fields []string `json:"fields"`
+}
+
+type ImportReport struct {
+	Mode          ImportMode       `json:"mode"`
+	LibraryTracks int              `json:"library_tracks"`
+	IgnoredTracks int              `json:"ignored_tracks"`
+	Entries       int              `json:"entries"`
+	Unchanged     int              `json:"unchanged"`
+	Conflicts     []ImportConflict `json:"conflicts"`
+	MissingTracks []string         `json:"missing_tracks"`
+}
+
+type BackupManifest struct {
+	Version       int       `json:"version"`
+	CreatedAt     time.Time `json:"created_at"`
+	ReadTs        uint64    `json:"read_ts"`
+	SchemaVersion uint64    `json:"schema_version"`
+	Size          int64     `json:"size"`
+	Sha256        string    `json:"sha256"`
+}
@@ -1,6 +1,36 @@
 package config
 
-import "fmt"
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CookieSecret is an authentication and encryption key pair for the session
+// cookie.
+type CookieSecret struct {
+	Auth    string
+	Encrypt string
+}
+
+// ParseCookieSecrets parses a comma separated list of auth:encrypt pairs.
+func ParseCookieSecrets(value string) ([]CookieSecret, error) {
+	var result []CookieSecret
+	if value == "" {
+		return result, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		auth, encrypt, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("cookie secret pair must be auth:encrypt")
+		}
+		result = append(result, CookieSecret{Auth: auth, Encrypt: encrypt})
+	}
+
+	return result, nil
+}
 
 type Config struct {
 	ClientId         string
@@ -8,9 +38,20 @@ type Config struct {
 	Domain           string
 	Scheme           string
 	DatabasePath     string
+	TrackCacheSize   int
 	WebsiteFilesPath string
-	CookieAuthSecert string
-	CookieEyncSecert string
+	// CookieSecrets is ordered newest first. The first pair protects new
+	// cookies, every pair is accepted when reading one.
+	CookieSecrets   []CookieSecret
+	AdminToken      string
+	BackupDir       string
+	BackupInterval  time.Duration
+	BackupRetention int
+	// CallbackDelay is added to every OAuth callback request
+	CallbackDelay time.Duration
+	// SpotifyHTTPClient is used for every request to Spotify's accounts and
+	// web API. Nil uses http.DefaultClient.
+	SpotifyHTTPClient *http.Client
 }
 
 func (c *Config) Valid() error {
@@ -26,14 +67,39 @@ func (c *Config) Valid() error {
 		return fmt.Errorf("website-files-path must be set")
 	}
 
-	if c.CookieAuthSecert == "" {
+	if len(c.CookieSecrets) == 0 {
 		return fmt.Errorf("cookie secert must be set")
 	}
 
-	if c.CookieEyncSecert == "" {
-		return fmt.Errorf("cookie eync must be set")
+	for _, secret := range c.CookieSecrets {
+		if secret.Auth == "" {
+			return fmt.Errorf("cookie secert must be set")
+		}
+
+		if secret.Encrypt == "" {
+			return fmt.Errorf("cookie eync must be set")
+		}
+	}
+
+	if c.BackupDir != "" {
+		if c.BackupInterval <= 0 {
+			return fmt.Errorf("backup-interval must be positive")
+		}
 
+		if c.BackupRetention <= 0 {
+			return fmt.Errorf("backup-retention must be positive")
+		}
 	}
 
 	return nil
 }
+
+// CookieKeyPairs flattens CookieSecrets into the hash and block key pairs
+// securecookie expects.
+func (c *Config) CookieKeyPairs() [][]byte {
+	var result [][]byte
+	for _, secret := range c.CookieSecrets {
+		result = append(result, []byte(secret.Auth), []byte(secret.Encrypt))
+	}
+	return result
+}
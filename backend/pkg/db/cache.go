@@ -0,0 +1,150 @@
+package db
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+	"github.com/zmb3/spotify"
+)
+
+// trackCache is a fixed size LRU of track records. Tracks are shared by every
+// user so the same few thousand records back most playlist views. A nil
+// trackCache is valid and caches nothing.
+//
+// Every invalidation bumps the cache's generation. Readers take the
+// generation before reading the database and only add what they read if it
+// has not moved, so a read that raced a write can never cache the old record.
+// Tracks are copied on the way in and out so callers cannot change a cached
+// record.
+type trackCache struct {
+	mu         sync.Mutex
+	size       int
+	generation uint64
+	ll         *list.List
+	items      map[string]*list.Element
+}
+
+func newTrackCache(size int) *trackCache {
+	if size <= 0 {
+		return nil
+	}
+
+	return &trackCache{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func copyTrack(track *models.Track) *models.Track {
+	result := *track
+
+	if track.AvailableMarkets != nil {
+		result.AvailableMarkets = make(map[string]error, len(track.AvailableMarkets))
+		for market, err := range track.AvailableMarkets {
+			result.AvailableMarkets[market] = err
+		}
+	}
+
+	if track.Artists != nil {
+		result.Artists = make([]spotify.SimpleArtist, len(track.Artists))
+		for i, artist := range track.Artists {
+			if artist.ExternalURLs != nil {
+				urls := make(map[string]string, len(artist.ExternalURLs))
+				for k, v := range artist.ExternalURLs {
+					urls[k] = v
+				}
+				artist.ExternalURLs = urls
+			}
+			result.Artists[i] = artist
+		}
+	}
+
+	return &result
+}
+
+// current returns the generation to pass to add for a read started now.
+func (c *trackCache) current() uint64 {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+func (c *trackCache) get(id string) (*models.Track, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[id]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return copyTrack(elem.Value.(*models.Track)), true
+}
+
+// add caches track unless the cache was invalidated since generation.
+func (c *trackCache) add(track *models.Track, generation uint64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	track = copyTrack(track)
+
+	if elem, ok := c.items[track.Id]; ok {
+		elem.Value = track
+		c.ll.MoveToFront(elem)
+		return
+	}
+
+	c.items[track.Id] = c.ll.PushFront(track)
+	if c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*models.Track).Id)
+	}
+}
+
+func (c *trackCache) remove(id string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if elem, ok := c.items[id]; ok {
+		c.ll.Remove(elem)
+		delete(c.items, id)
+	}
+}
+
+func (c *trackCache) purge() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+}
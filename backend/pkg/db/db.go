@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"encoding/gob"
 	"fmt"
+	"io"
 	"log"
-	"strings"
+	"math/rand"
+	"os"
 	"time"
 
 	"github.com/dgraph-io/badger/v3"
@@ -15,18 +17,51 @@ import (
 )
 
 type Database struct {
-	db *badger.DB
+	db     *badger.DB
+	tracks *trackCache
 }
 
 func (d *Database) Close() {
 	d.db.Close()
 }
 
+const (
+	maxUpdateAttempts = 16
+	maxUpdateBackoff  = 50 * time.Millisecond
+)
+
+// Update runs fn in a read-write transaction. When the commit fails because a
+// concurrent transaction wrote a key fn read, fn is run again against a fresh
+// transaction. fn must therefore do all of its reads through txn and must not
+// have side effects outside of it. badger.ErrConflict is returned if every
+// attempt conflicts.
+func (d *Database) Update(fn func(txn *badger.Txn) error) error {
+	var err error
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		err = d.db.Update(fn)
+		if err != badger.ErrConflict {
+			return err
+		}
+
+		backoff := time.Millisecond << attempt
+		if backoff > maxUpdateBackoff {
+			backoff = maxUpdateBackoff
+		}
+		time.Sleep(time.Duration(rand.Int63n(int64(backoff))))
+	}
+	return err
+}
+
 func trackKey(trackId string) []byte {
 	return []byte(fmt.Sprintf("tracks/%s", trackId))
 }
 
+// PutTrack stores track. The cached copy is dropped both before and after the
+// write so no reader is served the old record once PutTrack returns.
 func (d *Database) PutTrack(track *models.Track) error {
+	d.tracks.remove(track.Id)
+	defer d.tracks.remove(track.Id)
+
 	return d.db.Update(func(txn *badger.Txn) error {
 		buf := &bytes.Buffer{}
 		enc := gob.NewEncoder(buf)
@@ -37,6 +72,16 @@ func (d *Database) PutTrack(track *models.Track) error {
 }
 
 func (d *Database) GetTrack(id string) (track *models.Track, err error) {
+	if cached, ok := d.tracks.get(id); ok {
+		return cached, nil
+	}
+	generation := d.tracks.current()
+	defer func() {
+		if err == nil {
+			d.tracks.add(track, generation)
+		}
+	}()
+
 	err = d.db.View(func(txn *badger.Txn) error {
 		itm, err := txn.Get(trackKey(id))
 		if err != nil {
@@ -62,62 +107,58 @@ func (d *Database) TrackExists(id string) (result bool) {
 	return result
 }
 
-func (d *Database) GetTracks(ids ...string) (tracks []*models.Track) {
-	d.db.View(func(txn *badger.Txn) error {
-		for _, id := range ids {
-			itm, err := txn.Get(trackKey(id))
-			if err != nil {
-				continue
-			}
-			itm.Value(func(val []byte) error {
-				var track *models.Track
-				enc := gob.NewDecoder(bytes.NewBuffer(val))
-				enc.Decode(&track)
-				tracks = append(tracks, track)
-				return nil
-			})
+// GetTracks loads every track in ids in a single transaction. tracks keeps the
+// order of ids, any id without a stored track is skipped and returned in
+// missing instead.
+func (d *Database) GetTracks(ids ...string) (tracks []*models.Track, missing []string, err error) {
+	found := make(map[string]*models.Track, len(ids))
+	var toLoad []string
+	for _, id := range ids {
+		if cached, ok := d.tracks.get(id); ok {
+			found[id] = cached
+		} else {
+			toLoad = append(toLoad, id)
 		}
-		return nil
-	})
-	return
-}
+	}
 
-func userTracksKey(userId string) []byte {
-	return []byte(fmt.Sprintf("user/tracks/%s", userId))
-}
+	if len(toLoad) > 0 {
+		generation := d.tracks.current()
+		err = d.db.View(func(txn *badger.Txn) error {
+			for _, id := range toLoad {
+				itm, err := txn.Get(trackKey(id))
+				if err == badger.ErrKeyNotFound {
+					continue
+				} else if err != nil {
+					return err
+				}
 
-func (d *Database) SetUserTracks(userId string, userTracks *models.UserTracks) error {
-	return d.db.Update(func(txn *badger.Txn) error {
-		buf := &bytes.Buffer{}
-		enc := gob.NewEncoder(buf)
-		err := enc.Encode(userTracks)
+				var track *models.Track
+				err = itm.Value(func(val []byte) error {
+					return gobDecode(val, &track)
+				})
+				if err != nil {
+					return err
+				}
+				found[id] = track
+				d.tracks.add(track, generation)
+			}
+			return nil
+		})
 		if err != nil {
-			panic(err)
+			return nil, nil, err
 		}
-		return txn.Set(userTracksKey(userId), buf.Bytes())
-	})
-}
+	}
 
-func (d *Database) GetUserTracks(userId string) (tracks *models.UserTracks, err error) {
-	err = d.db.View(func(txn *badger.Txn) error {
-		itm, err := txn.Get(userTracksKey(userId))
-		if err != nil {
-			return err
+	for _, id := range ids {
+		if track, ok := found[id]; ok {
+			tracks = append(tracks, track)
+		} else {
+			missing = append(missing, id)
 		}
-		return itm.Value(func(val []byte) error {
-			enc := gob.NewDecoder(bytes.NewBuffer(val))
-			return enc.Decode(&tracks)
-		})
-	})
+	}
 	return
 }
 
-func (d *Database) ClearUserTracks(userId string) error {
-	return d.db.Update(func(txn *badger.Txn) error {
-		return txn.Delete(userTracksKey(userId))
-	})
-}
-
 func userFetchLock(userId string) []byte {
 	return []byte(fmt.Sprintf("user/fetch_lock/%s", userId))
 }
@@ -147,24 +188,81 @@ func (d *Database) ClearUserFetchLock(userId string) error {
 	})
 }
 
+// keyMoodPlaylistPrefix ends in a slash so one user's playlists are never
+// matched by the prefix of another user whose id starts the same way.
+//
+// Playlists, fetch locks and Spotify playlist ids keep their original
+// user/<kind>/<id> keys rather than living under users/<id>/ with the rest
+// of a user's data. Released databases already hold them there and nothing
+// reads them by the users/<id>/ prefix, so they are not worth migrating.
 func keyMoodPlaylistPrefix(userId string) []byte {
-	return []byte(fmt.Sprintf("user/playlist/%s", userId))
-
+	return []byte(fmt.Sprintf("user/playlist/%s/", userId))
 }
 
 func keyMoodPlaylist(userId, date string) []byte {
-	return []byte(fmt.Sprintf("%s/%s", keyMoodPlaylistPrefix(userId), date))
+	return append(keyMoodPlaylistPrefix(userId), date...)
 }
 
-func (d *Database) SetMoodPlaylist(userId string, playlist *models.MoodPlaylist) error {
-	return d.db.Update(func(txn *badger.Txn) error {
-		buf := &bytes.Buffer{}
-		enc := gob.NewEncoder(buf)
-		err := enc.Encode(playlist)
+// setMoodPlaylist stores playlist and reindexes its note and tags.
+func setMoodPlaylist(txn *badger.Txn, userId string, playlist *models.MoodPlaylist) error {
+	date := playlist.Date.Format(models.DateFormat)
+
+	var old models.MoodPlaylist
+	itm, err := txn.Get(keyMoodPlaylist(userId, date))
+	if err == nil {
+		err := itm.Value(func(val []byte) error {
+			return gobDecode(val, &old)
+		})
 		if err != nil {
-			panic(err)
+			return err
 		}
-		return txn.Set(keyMoodPlaylist(userId, playlist.Date.Format(models.DateFormat)), buf.Bytes())
+	} else if err != badger.ErrKeyNotFound {
+		return err
+	}
+
+	data, err := gobEncode(playlist)
+	if err != nil {
+		return err
+	}
+	if err := txn.Set(keyMoodPlaylist(userId, date), data); err != nil {
+		return err
+	}
+
+	if err := indexNote(txn, userId, date, old.Note, playlist.Note); err != nil {
+		return err
+	}
+	return indexTags(txn, userId, date, old.Tags, playlist.Tags)
+}
+
+// deleteMoodPlaylist removes the playlist on date along with its note and tag
+// index entries. Deleting a playlist that doesn't exist does nothing.
+func deleteMoodPlaylist(txn *badger.Txn, userId, date string) error {
+	var old models.MoodPlaylist
+	itm, err := txn.Get(keyMoodPlaylist(userId, date))
+	if err == badger.ErrKeyNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	err = itm.Value(func(val []byte) error {
+		return gobDecode(val, &old)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := indexNote(txn, userId, date, old.Note, nil); err != nil {
+		return err
+	}
+	if err := indexTags(txn, userId, date, old.Tags, nil); err != nil {
+		return err
+	}
+	return txn.Delete(keyMoodPlaylist(userId, date))
+}
+
+func (d *Database) SetMoodPlaylist(userId string, playlist *models.MoodPlaylist) error {
+	return d.Update(func(txn *badger.Txn) error {
+		return setMoodPlaylist(txn, userId, playlist)
 	})
 }
 
@@ -182,68 +280,124 @@ func (d *Database) GetMoodPlaylist(userId, date string) (playlist *models.MoodPl
 	return
 }
 
-func (d *Database) GetMoodPlaylitsBetweenDates(userId string, start, end time.Time) (playlists []*models.MoodPlaylist, err error) {
-	err = d.db.View(func(txn *badger.Txn) error {
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer it.Close()
-
-		prefix := keyMoodPlaylistPrefix(userId)
-
-		getDate := func(v []byte) time.Time {
-			result, _ := time.Parse(models.DateFormat, strings.Split(string(v), "/")[3])
-			return result
+// MoodPlaylistQuery selects a page of a user's mood playlists. Dates are
+// models.DateFormat strings and every field is optional.
+type MoodPlaylistQuery struct {
+	// From and To bound the dates, both inclusive
+	From string
+	To   string
+	// After continues a walk from the playlist with this date, which is not
+	// included again
+	After string
+	// Reverse walks newest first
+	Reverse bool
+	// Limit is the most playlists returned, zero is no limit
+	Limit int
+	// Tag only matches playlists with the tag, a tag without a value matches
+	// every value of its key
+	Tag string
+}
+
+// QueryMoodPlaylists walks only the keys between the query's bounds. more is
+// set when playlists past the limit remain.
+func (d *Database) QueryMoodPlaylists(userId string, query MoodPlaylistQuery) (playlists []*models.MoodPlaylist, more bool, err error) {
+	prefix := keyMoodPlaylistPrefix(userId)
+
+	// first is where the walk starts and last where it stops, in walk order
+	first, last := query.From, query.To
+	if query.Reverse {
+		first, last = query.To, query.From
+	}
+	if query.After != "" && (first == "" || (query.After > first) != query.Reverse) {
+		first = query.After
+	}
+	pastLast := func(date string) bool {
+		if last == "" {
+			return false
 		}
-
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			date := getDate(it.Item().Key())
-			if (date.After(start) && date.Before(end)) || date == start || date == end {
-				it.Item().Value(func(val []byte) error {
-					enc := gob.NewDecoder(bytes.NewBuffer(val))
-					var playlist models.MoodPlaylist
-					enc.Decode(&playlist)
-					playlists = append(playlists, &playlist)
-					return nil
-				})
-			}
+		if query.Reverse {
+			return date < last
 		}
+		return date > last
+	}
 
-		return nil
-	})
-	return
-}
+	seek := append([]byte{}, prefix...)
+	if first != "" {
+		seek = append(seek, first...)
+	} else if query.Reverse {
+		seek = append(seek, 0xff)
+	}
 
-func (d *Database) GetMoodPlaylists(userId string) (playlists []*models.MoodPlaylist, err error) {
 	err = d.db.View(func(txn *badger.Txn) error {
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		opts.Reverse = query.Reverse
+		if query.Limit > 0 && query.Limit < opts.PrefetchSize {
+			opts.PrefetchSize = query.Limit + 1
+		}
+		var tagged map[string]bool
+		if query.Tag != "" {
+			tagged = taggedDates(txn, userId, models.ParseTag(query.Tag), false)
+		}
+
+		it := txn.NewIterator(opts)
 		defer it.Close()
 
-		prefix := keyMoodPlaylistPrefix(userId)
+		for it.Seek(seek); it.ValidForPrefix(prefix); it.Next() {
+			date := string(it.Item().Key()[len(prefix):])
+			if pastLast(date) {
+				break
+			}
+			if date == query.After || (tagged != nil && !tagged[date]) {
+				continue
+			}
+			if query.Limit > 0 && len(playlists) >= query.Limit {
+				more = true
+				break
+			}
 
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			it.Item().Value(func(val []byte) error {
-				enc := gob.NewDecoder(bytes.NewBuffer(val))
-				var playlist *models.MoodPlaylist
-				enc.Decode(&playlist)
-				playlists = append(playlists, playlist)
+			err := it.Item().Value(func(val []byte) error {
+				var playlist models.MoodPlaylist
+				if err := gob.NewDecoder(bytes.NewBuffer(val)).Decode(&playlist); err != nil {
+					return err
+				}
+				playlists = append(playlists, &playlist)
 				return nil
 			})
+			if err != nil {
+				return err
+			}
 		}
+
 		return nil
 	})
 	return
 }
 
-func (d *Database) ClearMoodPlaylists(userId string) error {
-	return d.db.Update(func(txn *badger.Txn) error {
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer it.Close()
+// GetMoodPlaylitsBetweenDates returns the playlists from start's day to
+// end's day inclusive.
+func (d *Database) GetMoodPlaylitsBetweenDates(userId string, start, end time.Time) (playlists []*models.MoodPlaylist, err error) {
+	playlists, _, err = d.QueryMoodPlaylists(userId, MoodPlaylistQuery{
+		From: start.Format(models.DateFormat),
+		To:   end.Format(models.DateFormat),
+	})
+	return
+}
 
-		prefix := keyMoodPlaylistPrefix(userId)
+func (d *Database) GetMoodPlaylists(userId string) (playlists []*models.MoodPlaylist, err error) {
+	playlists, _, err = d.QueryMoodPlaylists(userId, MoodPlaylistQuery{})
+	return
+}
 
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			txn.Delete(it.Item().Key())
+func (d *Database) ClearMoodPlaylists(userId string) error {
+	return d.Update(func(txn *badger.Txn) error {
+		if err := deletePrefix(txn, keyMoodPlaylistPrefix(userId)); err != nil {
+			return err
 		}
-		return nil
+		if err := deletePrefix(txn, notesIndexPrefix(userId)); err != nil {
+			return err
+		}
+		return deletePrefix(txn, taggedPrefix(userId))
 	})
 }
 
@@ -281,42 +435,57 @@ func authStateKey(id string) []byte {
 	return []byte(fmt.Sprintf("auth/states/%s", id))
 }
 
-func (d *Database) GenerateAuthState() (state, key string, err error) {
-	d.db.Update(func(txn *badger.Txn) error {
-		{
-			id, _ := uuid.NewV4()
-			state = id.String()
-		}
-		{
-			id, _ := uuid.NewV4()
-			key = id.String()
-		}
+// GenerateAuthState creates a state for an OAuth authorization request. The
+// state is kept with the session key it is bound to, the PKCE verifier and the
+// requested scopes until it is consumed or expires.
+func (d *Database) GenerateAuthState(verifier string, scopes []string) (state, key string, err error) {
+	{
+		id, _ := uuid.NewV4()
+		state = id.String()
+	}
+	{
+		id, _ := uuid.NewV4()
+		key = id.String()
+	}
 
-		entry := badger.NewEntry(authStateKey(state), []byte(key))
-		entry.WithTTL(3 * time.Minute)
-		err = txn.SetEntry(entry)
-		return nil
+	data, err := gobEncode(&models.AuthState{Key: key, Verifier: verifier, Scopes: scopes})
+	if err != nil {
+		return "", "", err
+	}
+
+	err = d.db.Update(func(txn *badger.Txn) error {
+		entry := badger.NewEntry(authStateKey(state), data).WithTTL(3 * time.Minute)
+		return txn.SetEntry(entry)
 	})
 	return
 }
 
-func (d *Database) GetAuthState(state string) (result string, err error) {
-	err = d.db.View(func(txn *badger.Txn) error {
-		itm, err := txn.Get(authStateKey(state))
+// ConsumeAuthState returns the auth state and deletes it so it can only be
+// used once. Returns badger.ErrKeyNotFound for unknown, expired or already
+// used states.
+func (d *Database) ConsumeAuthState(state string) (*models.AuthState, error) {
+	var result models.AuthState
+	err := d.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(authStateKey(state))
 		if err != nil {
 			return err
 		}
 
-		data, _ := itm.ValueCopy(nil)
-		result = string(data)
-		return nil
+		data, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if err := gobDecode(data, &result); err != nil {
+			return err
+		}
+
+		return txn.Delete(authStateKey(state))
 	})
 	if err != nil {
-		go d.db.Update(func(txn *badger.Txn) error {
-			return txn.Delete(authStateKey(state))
-		})
+		return nil, err
 	}
-	return
+
+	return &result, nil
 }
 
 func ipKey(ip string) []byte {
@@ -340,6 +509,62 @@ func (d *Database) IsIpGood(ip string) (result bool) {
 	return
 }
 
+// Backup streams a consistent snapshot of every key to w and returns the
+// version the snapshot was taken at. Users' Spotify tokens are left out so
+// backups hold no credentials, users with access tokens log in again after
+// a restore.
+func (d *Database) Backup(w io.Writer) (uint64, error) {
+	stream := d.db.NewStream()
+	stream.LogPrefix = "DB.Backup"
+	stream.ChooseKey = func(item *badger.Item) bool {
+		return !isSpotifyTokenKey(item.Key())
+	}
+	return stream.Backup(w, 0)
+}
+
+// Restore replaces the contents of the database with a stream produced by
+// Backup and migrates it to the current schema. The current contents are
+// snapshotted first and loaded back if the stream cannot be loaded or
+// migrated, so a failed restore leaves the database as it was.
+func (d *Database) Restore(r io.Reader) error {
+	snapshot, err := os.CreateTemp("", "tune-neutral-rollback-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(snapshot.Name())
+	defer snapshot.Close()
+
+	if _, err := d.db.Backup(snapshot, 0); err != nil {
+		return err
+	}
+
+	err = d.load(r)
+	if err == nil {
+		err = d.migrate()
+	}
+	if err != nil {
+		if _, seekErr := snapshot.Seek(0, io.SeekStart); seekErr != nil {
+			return fmt.Errorf("restore failed: %w, rollback failed: %v", err, seekErr)
+		}
+		if rollbackErr := d.load(snapshot); rollbackErr != nil {
+			return fmt.Errorf("restore failed: %w, rollback failed: %v", err, rollbackErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (d *Database) load(r io.Reader) error {
+	defer d.tracks.purge()
+
+	if err := d.db.DropAll(); err != nil {
+		return err
+	}
+
+	return d.db.Load(r, 256)
+}
+
 func ConnectDb(cfg *config.Config) *Database {
 	// Open the Badger database located in the /tmp/badger directory.
 	// It will be created if it doesn't exist.
@@ -347,7 +572,15 @@ func ConnectDb(cfg *config.Config) *Database {
 	if err != nil {
 		log.Fatal(err)
 	}
-	return &Database{
-		db: db,
+
+	result := &Database{
+		db:     db,
+		tracks: newTrackCache(cfg.TrackCacheSize),
+	}
+
+	if err := result.migrate(); err != nil {
+		log.Fatal(err)
 	}
+
+	return result
 }
@@ -0,0 +1,543 @@
+package db_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/sardap/TuneNeutral/backend/pkg/config"
+	"github.com/sardap/TuneNeutral/backend/pkg/db"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+	"github.com/stretchr/testify/assert"
+)
+
+const userId = "paul"
+
+func newDatabase(t *testing.T) *db.Database {
+	cfg := &config.Config{
+		DatabasePath: path.Join(t.TempDir(), "database"),
+	}
+
+	return db.ConnectDb(cfg)
+}
+
+func TestUpdateRetriesConflicts(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	key := []byte("test/counter")
+
+	increment := func(txn *badger.Txn) error {
+		var count uint64
+		itm, err := txn.Get(key)
+		if err == nil {
+			itm.Value(func(val []byte) error {
+				count = binary.BigEndian.Uint64(val)
+				return nil
+			})
+		} else if err != badger.ErrKeyNotFound {
+			return err
+		}
+
+		val := make([]byte, 8)
+		binary.BigEndian.PutUint64(val, count+1)
+		return txn.Set(key, val)
+	}
+
+	// Run
+	const workers = 16
+	const incrementsPerWorker = 25
+
+	var mu sync.Mutex
+	succeeded := 0
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < incrementsPerWorker; j++ {
+				err := dbConn.Update(increment)
+				if err == badger.ErrConflict {
+					continue
+				}
+				assert.NoError(t, err)
+
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every committed increment must be visible, none may be lost
+	var count uint64
+	dbConn.Update(func(txn *badger.Txn) error {
+		itm, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+		return itm.Value(func(val []byte) error {
+			count = binary.BigEndian.Uint64(val)
+			return nil
+		})
+	})
+	assert.Greater(t, succeeded, 0)
+	assert.Equal(t, uint64(succeeded), count)
+}
+
+func TestMoodIndexFollowsLibrary(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	dbConn.PutTrack(&models.Track{Id: "sad", Valence: 0.35})
+	dbConn.SetUserTracks(userId, &models.UserTracks{
+		UserId: userId,
+		TrackIds: map[string]models.MinTrack{
+			"sad":   {Valence: 0.35},
+			"happy": {Valence: 0.9},
+		},
+	})
+
+	bucketIds := func(mood models.Mood) []string {
+		tracks, err := dbConn.GetLibraryMoodBucket(userId, mood)
+		assert.NoError(t, err)
+		var ids []string
+		for id := range tracks {
+			ids = append(ids, id)
+		}
+		return ids
+	}
+
+	assert.ElementsMatch(t, []string{"sad"}, bucketIds(models.MoodSad))
+	assert.ElementsMatch(t, []string{"happy"}, bucketIds(models.MoodHappy))
+
+	// Run
+	assert.NoError(t, dbConn.RemoveLibraryTrack(userId, "sad"))
+	assert.Empty(t, bucketIds(models.MoodSad))
+
+	assert.NoError(t, dbConn.UnremoveLibraryTrack(userId, "sad"))
+	assert.ElementsMatch(t, []string{"sad"}, bucketIds(models.MoodSad))
+
+	// A re-synced track whose features changed moves bucket
+	scan, _ := dbConn.GetLibraryScan(userId)
+	assert.NoError(t, dbConn.SaveLibraryPage(userId, scan, map[string]models.MinTrack{
+		"happy": {Valence: 0.5},
+	}))
+	assert.Empty(t, bucketIds(models.MoodHappy))
+	assert.ElementsMatch(t, []string{"happy"}, bucketIds(models.MoodNothing))
+
+	assert.NoError(t, dbConn.ClearUserTracks(userId))
+	for _, mood := range models.Moods {
+		assert.Empty(t, bucketIds(mood))
+	}
+}
+
+func TestGetTracks(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	cfg := &config.Config{
+		DatabasePath:   path.Join(t.TempDir(), "database"),
+		TrackCacheSize: 2,
+	}
+	dbConn := db.ConnectDb(cfg)
+	defer dbConn.Close()
+	for _, id := range []string{"please", "hire", "me"} {
+		dbConn.PutTrack(&models.Track{Id: id, Name: id})
+	}
+
+	type scenario struct {
+		ids             []string
+		expectedTracks  []string
+		expectedMissing []string
+	}
+	scenarios := []scenario{
+		{
+			ids:            []string{"me", "hire", "please"},
+			expectedTracks: []string{"me", "hire", "please"},
+		},
+		{
+			ids:             []string{"please", "duck", "me", "goose"},
+			expectedTracks:  []string{"please", "me"},
+			expectedMissing: []string{"duck", "goose"},
+		},
+		{
+			ids: []string{},
+		},
+	}
+	for _, scenario := range scenarios {
+		// Run twice so the second pass is served at least partly from the cache
+		for i := 0; i < 2; i++ {
+			tracks, missing, err := dbConn.GetTracks(scenario.ids...)
+
+			assert.NoError(t, err)
+			var names []string
+			for _, track := range tracks {
+				names = append(names, track.Name)
+			}
+			assert.Equal(t, scenario.expectedTracks, names)
+			assert.Equal(t, scenario.expectedMissing, missing)
+		}
+	}
+
+	// Writes must not leave a stale cached copy behind
+	dbConn.GetTrack("me")
+	dbConn.PutTrack(&models.Track{Id: "me", Name: "you"})
+	track, err := dbConn.GetTrack("me")
+	assert.NoError(t, err)
+	assert.Equal(t, "you", track.Name)
+
+	// Callers get their own copy of a cached track
+	track.Name = "changed"
+	track, _ = dbConn.GetTrack("me")
+	assert.Equal(t, "you", track.Name)
+}
+
+func TestRestoreRollsBack(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	dbConn.PutTrack(&models.Track{Id: "please", Name: "please"})
+
+	buf := &bytes.Buffer{}
+	_, err := dbConn.Backup(buf)
+	assert.NoError(t, err)
+
+	dbConn.PutTrack(&models.Track{Id: "hire", Name: "hire"})
+
+	// Run
+	truncated := bytes.NewReader(buf.Bytes()[:buf.Len()-1])
+	assert.Error(t, dbConn.Restore(truncated))
+
+	assert.True(t, dbConn.TrackExists("please"))
+	assert.True(t, dbConn.TrackExists("hire"))
+
+	assert.NoError(t, dbConn.Restore(bytes.NewReader(buf.Bytes())))
+	assert.True(t, dbConn.TrackExists("please"))
+	assert.False(t, dbConn.TrackExists("hire"))
+}
+
+func TestRestoreMigrates(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	raw, err := badger.Open(badger.DefaultOptions(path.Join(t.TempDir(), "old")).WithLogger(nil))
+	assert.NoError(t, err)
+	err = raw.Update(func(txn *badger.Txn) error {
+		legacy := &bytes.Buffer{}
+		assert.NoError(t, gob.NewEncoder(legacy).Encode(&models.UserTracks{
+			TrackIds: map[string]models.MinTrack{"a": {Valence: 0.2}},
+		}))
+		return txn.Set([]byte("user/tracks/paul"), legacy.Bytes())
+	})
+	assert.NoError(t, err)
+	buf := &bytes.Buffer{}
+	_, err = raw.Backup(buf, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, raw.Close())
+
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+
+	// Run
+	assert.NoError(t, dbConn.Restore(buf))
+
+	version, err := dbConn.SchemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, db.LatestSchemaVersion(), version)
+
+	library, err := dbConn.GetLibraryTracks(userId)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]models.MinTrack{"a": {Valence: 0.2}}, library)
+}
+
+func TestSpotifyTokenFollowsAccessTokens(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+
+	// Run
+	assert.NoError(t, dbConn.UpdateSpotifyToken(userId, []byte("login")))
+	_, err := dbConn.GetSpotifyToken(userId)
+	assert.Equal(t, badger.ErrKeyNotFound, err)
+
+	for _, id := range []string{"a", "b"} {
+		token := &models.AccessToken{Id: id, UserId: userId}
+		assert.NoError(t, dbConn.PutAccessToken("hash-"+id, token, []byte("minted")))
+	}
+	assert.NoError(t, dbConn.UpdateSpotifyToken(userId, []byte("refreshed")))
+	stored, err := dbConn.GetSpotifyToken(userId)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("refreshed"), stored)
+
+	buf := &bytes.Buffer{}
+	_, err = dbConn.Backup(buf)
+	assert.NoError(t, err)
+	restored := newDatabase(t)
+	defer restored.Close()
+	assert.NoError(t, restored.Restore(buf))
+	_, err = restored.GetSpotifyToken(userId)
+	assert.Equal(t, badger.ErrKeyNotFound, err)
+	tokens, _ := restored.GetUserAccessTokens(userId)
+	assert.Len(t, tokens, 2)
+
+	assert.NoError(t, dbConn.DeleteAccessToken(userId, "a"))
+	_, err = dbConn.GetSpotifyToken(userId)
+	assert.NoError(t, err)
+	assert.NoError(t, dbConn.DeleteAccessToken(userId, "b"))
+	_, err = dbConn.GetSpotifyToken(userId)
+	assert.Equal(t, badger.ErrKeyNotFound, err)
+}
+
+func TestQueryMoodPlaylists(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	for _, date := range []string{"2021-01-01", "2021-01-02", "2021-01-04", "2021-02-01"} {
+		parsed, _ := time.Parse(models.DateFormat, date)
+		dbConn.SetMoodPlaylist(userId, &models.MoodPlaylist{Date: parsed})
+	}
+	// Another user whose id starts with userId must never show up
+	dbConn.SetMoodPlaylist(userId+"2", &models.MoodPlaylist{Date: time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC)})
+
+	type scenario struct {
+		query         db.MoodPlaylistQuery
+		expectedDates []string
+		expectedMore  bool
+	}
+	scenarios := []scenario{
+		{
+			query:         db.MoodPlaylistQuery{},
+			expectedDates: []string{"2021-01-01", "2021-01-02", "2021-01-04", "2021-02-01"},
+		},
+		{
+			query:         db.MoodPlaylistQuery{Reverse: true},
+			expectedDates: []string{"2021-02-01", "2021-01-04", "2021-01-02", "2021-01-01"},
+		},
+		{
+			query:         db.MoodPlaylistQuery{From: "2021-01-02", To: "2021-01-04"},
+			expectedDates: []string{"2021-01-02", "2021-01-04"},
+		},
+		{
+			query:         db.MoodPlaylistQuery{From: "2021-01-03", To: "2021-01-31", Reverse: true},
+			expectedDates: []string{"2021-01-04"},
+		},
+		{
+			query:         db.MoodPlaylistQuery{Limit: 2},
+			expectedDates: []string{"2021-01-01", "2021-01-02"},
+			expectedMore:  true,
+		},
+		{
+			query:         db.MoodPlaylistQuery{After: "2021-01-02", Limit: 2},
+			expectedDates: []string{"2021-01-04", "2021-02-01"},
+		},
+		{
+			query:         db.MoodPlaylistQuery{After: "2021-01-04", Reverse: true, Limit: 1},
+			expectedDates: []string{"2021-01-02"},
+			expectedMore:  true,
+		},
+		{
+			// A cursor before the range starts at the range
+			query:         db.MoodPlaylistQuery{From: "2021-01-02", After: "2021-01-01", Limit: 1},
+			expectedDates: []string{"2021-01-02"},
+			expectedMore:  true,
+		},
+		{
+			query:         db.MoodPlaylistQuery{From: "2021-03-01"},
+			expectedDates: nil,
+		},
+	}
+	for _, scenario := range scenarios {
+		// Run
+		playlists, more, err := dbConn.QueryMoodPlaylists(userId, scenario.query)
+
+		assert.NoError(t, err)
+		var dates []string
+		for _, playlist := range playlists {
+			dates = append(dates, playlist.Date.Format(models.DateFormat))
+		}
+		assert.Equal(t, scenario.expectedDates, dates, "%+v", scenario.query)
+		assert.Equal(t, scenario.expectedMore, more, "%+v", scenario.query)
+	}
+
+	// Clearing one user leaves the other alone
+	assert.NoError(t, dbConn.ClearMoodPlaylists(userId))
+	others, err := dbConn.GetMoodPlaylists(userId + "2")
+	assert.NoError(t, err)
+	assert.Len(t, others, 1)
+}
+
+func TestNotesIndex(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	note := func(date, text string) {
+		parsed, _ := time.Parse(models.DateFormat, date)
+		assert.NoError(t, dbConn.SetMoodPlaylist(userId, &models.MoodPlaylist{Date: parsed, Note: &text}))
+	}
+	note("2021-01-01", "tired and sad")
+	note("2021-01-02", "so tired")
+	note("2021-01-03", "happy")
+
+	dates := func(terms []string, from, to string) []string {
+		found, err := dbConn.SearchNotes(userId, terms, from, to)
+		assert.NoError(t, err)
+		var result []string
+		for date := range found {
+			result = append(result, date)
+		}
+		return result
+	}
+
+	// Run
+	assert.ElementsMatch(t, []string{"2021-01-01", "2021-01-02"}, dates([]string{"tired"}, "", ""))
+	assert.ElementsMatch(t, []string{"2021-01-01"}, dates([]string{"tired", "sad"}, "", ""))
+	assert.ElementsMatch(t, []string{"2021-01-02"}, dates([]string{"tired"}, "2021-01-02", "2021-01-03"))
+	assert.Empty(t, dates([]string{"tired", "happy"}, "", ""))
+
+	found, _ := dbConn.SearchNotes(userId, []string{"tired", "and"}, "", "")
+	assert.Equal(t, map[string]map[string][]int{"2021-01-01": {"tired": {0}, "and": {1}}}, found)
+
+	// Replacing a note drops its old terms
+	note("2021-01-02", "rested")
+	assert.ElementsMatch(t, []string{"2021-01-01"}, dates([]string{"tired"}, "", ""))
+
+	playlist, err := dbConn.UpdateMoodPlaylistNote(userId, "2021-01-03", "happy but tired")
+	assert.NoError(t, err)
+	assert.Equal(t, "happy but tired", *playlist.Note)
+	assert.ElementsMatch(t, []string{"2021-01-01", "2021-01-03"}, dates([]string{"tired"}, "", ""))
+
+	_, err = dbConn.UpdateMoodPlaylistNote(userId, "2021-01-04", "nope")
+	assert.Equal(t, badger.ErrKeyNotFound, err)
+
+	assert.NoError(t, dbConn.ClearMoodPlaylists(userId))
+	assert.Empty(t, dates([]string{"tired"}, "", ""))
+}
+
+func TestTags(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	tag := func(date string, tags ...string) {
+		parsed, _ := time.Parse(models.DateFormat, date)
+		assert.NoError(t, dbConn.SetMoodPlaylist(userId, &models.MoodPlaylist{Date: parsed, Tags: tags}))
+	}
+	tag("2021-01-01", "weather:rain", "work")
+	tag("2021-01-02", "weather:sun")
+	tag("2021-01-03", "work")
+	tag("2021-01-04")
+
+	dates := func(tag string) []string {
+		playlists, _, err := dbConn.QueryMoodPlaylists(userId, db.MoodPlaylistQuery{Tag: tag})
+		assert.NoError(t, err)
+		result := []string{}
+		for _, playlist := range playlists {
+			result = append(result, playlist.Date.Format(models.DateFormat))
+		}
+		return result
+	}
+
+	// Run
+	assert.Equal(t, []string{"2021-01-01", "2021-01-03"}, dates("work"))
+	assert.Equal(t, []string{"2021-01-01"}, dates("weather:rain"))
+	// A key on its own matches every value
+	assert.Equal(t, []string{"2021-01-01", "2021-01-02"}, dates("weather"))
+	assert.Empty(t, dates("sleep"))
+
+	playlists, more, err := dbConn.QueryMoodPlaylists(userId, db.MoodPlaylistQuery{Tag: "work", Reverse: true, Limit: 1})
+	assert.NoError(t, err)
+	assert.True(t, more)
+	assert.Equal(t, []string{"work"}, playlists[0].Tags)
+
+	playlist, err := dbConn.SetMoodPlaylistTags(userId, "2021-01-03", []string{"weather:rain"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"weather:rain"}, playlist.Tags)
+	assert.Equal(t, []string{"2021-01-01"}, dates("work"))
+	_, err = dbConn.SetMoodPlaylistTags(userId, "2021-01-05", []string{"work"})
+	assert.Equal(t, badger.ErrKeyNotFound, err)
+
+	// Renaming onto a tag an entry already has merges them
+	tag("2021-01-04", "weather:sun", "weather:rain")
+	changed, err := dbConn.ReplaceTag(userId, models.ParseTag("weather:rain"), &models.Tag{Key: "weather", Value: "sun"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, changed)
+	assert.Empty(t, dates("weather:rain"))
+	assert.Equal(t, []string{"2021-01-01", "2021-01-02", "2021-01-03", "2021-01-04"}, dates("weather:sun"))
+	playlist, _ = dbConn.GetMoodPlaylist(userId, "2021-01-04")
+	assert.Equal(t, []string{"weather:sun"}, playlist.Tags)
+
+	changed, err = dbConn.ReplaceTag(userId, models.ParseTag("work"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, changed)
+	assert.Empty(t, dates("work"))
+
+	assert.NoError(t, dbConn.ClearMoodPlaylists(userId))
+	assert.Empty(t, dates("weather"))
+}
+
+func TestImportUserData(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	assert.NoError(t, dbConn.SaveLibraryPage(userId, &models.LibraryScan{}, map[string]models.MinTrack{
+		"a": {Valence: 0.1},
+		"b": {Valence: 0.2},
+	}))
+	assert.NoError(t, dbConn.RemoveLibraryTrack(userId, "b"))
+	old := "tired"
+	assert.NoError(t, dbConn.SetMoodPlaylist(userId, &models.MoodPlaylist{Date: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), Note: &old}))
+	note := "rested"
+	data := &db.UserImport{
+		Scan:    &models.LibraryScan{CompletedScan: true},
+		Library: map[string]models.MinTrack{"c": {Valence: 0.3}, "d": {Valence: 0.4}},
+		Ignored: []string{"d"},
+		Playlists: []*models.MoodPlaylist{
+			{Date: time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC), Note: &note},
+		},
+	}
+
+	// Run
+	assert.NoError(t, dbConn.ImportUserData(userId, data, false))
+	library, _ := dbConn.GetLibraryTracks(userId)
+	assert.Equal(t, map[string]models.MinTrack{"a": {Valence: 0.1}, "c": {Valence: 0.3}}, library)
+	ignored, _ := dbConn.GetIgnoredTracks(userId)
+	assert.ElementsMatch(t, []string{"b", "d"}, ignored)
+	_, err := dbConn.GetMoodPlaylist(userId, "2021-01-01")
+	assert.NoError(t, err)
+
+	assert.NoError(t, dbConn.ImportUserData(userId, data, true))
+	library, _ = dbConn.GetLibraryTracks(userId)
+	assert.Equal(t, map[string]models.MinTrack{"c": {Valence: 0.3}}, library)
+	ignored, _ = dbConn.GetIgnoredTracks(userId)
+	assert.Equal(t, []string{"d"}, ignored)
+	_, err = dbConn.GetMoodPlaylist(userId, "2021-01-01")
+	assert.Equal(t, badger.ErrKeyNotFound, err)
+	found, _ := dbConn.SearchNotes(userId, []string{"tired"}, "", "")
+	assert.Empty(t, found)
+	found, _ = dbConn.SearchNotes(userId, []string{"rested"}, "", "")
+	assert.Len(t, found, 1)
+	scan, _ := dbConn.GetLibraryScan(userId)
+	assert.True(t, scan.CompletedScan)
+}
@@ -0,0 +1,212 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+)
+
+//	groups/<id>                        models.Group
+//	groups/<id>/playlists/<date>       models.GroupPlaylist
+//	group_invites/<hash>               <id>, finds the group an invite code is for
+//	users/<id>/groups/<group id>       empty, lets a user's groups be listed
+
+func groupKey(groupId string) []byte {
+	return []byte(fmt.Sprintf("groups/%s", groupId))
+}
+
+func groupPlaylistsPrefix(groupId string) []byte {
+	return []byte(fmt.Sprintf("groups/%s/playlists/", groupId))
+}
+
+func groupPlaylistKey(groupId, date string) []byte {
+	return append(groupPlaylistsPrefix(groupId), date...)
+}
+
+func groupInviteKey(hash string) []byte {
+	return []byte(fmt.Sprintf("group_invites/%s", hash))
+}
+
+func userGroupsPrefix(userId string) []byte {
+	return []byte(userPrefix(userId) + "groups/")
+}
+
+func userGroupKey(userId, groupId string) []byte {
+	return append(userGroupsPrefix(userId), groupId...)
+}
+
+func getGroup(txn *badger.Txn, groupId string) (group *models.Group, err error) {
+	itm, err := txn.Get(groupKey(groupId))
+	if err != nil {
+		return nil, err
+	}
+	err = itm.Value(func(val []byte) error {
+		return gobDecode(val, &group)
+	})
+	return
+}
+
+// setGroup stores group and brings the member and invite indexes of old, nil
+// for a new group, in line with it.
+func setGroup(txn *badger.Txn, old, group *models.Group) error {
+	data, err := gobEncode(group)
+	if err != nil {
+		return err
+	}
+	if err := txn.Set(groupKey(group.Id), data); err != nil {
+		return err
+	}
+
+	if old != nil {
+		for _, member := range old.Members {
+			if group.Member(member.UserId) == nil {
+				if err := txn.Delete(userGroupKey(member.UserId, group.Id)); err != nil {
+					return err
+				}
+			}
+		}
+		if old.InviteHash != "" && old.InviteHash != group.InviteHash {
+			if err := txn.Delete(groupInviteKey(old.InviteHash)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, member := range group.Members {
+		if err := txn.Set(userGroupKey(member.UserId, group.Id), nil); err != nil {
+			return err
+		}
+	}
+	if group.InviteHash != "" {
+		return txn.Set(groupInviteKey(group.InviteHash), []byte(group.Id))
+	}
+	return nil
+}
+
+func (d *Database) PutGroup(group *models.Group) error {
+	return d.Update(func(txn *badger.Txn) error {
+		return setGroup(txn, nil, group)
+	})
+}
+
+func (d *Database) GetGroup(groupId string) (group *models.Group, err error) {
+	err = d.db.View(func(txn *badger.Txn) error {
+		group, err = getGroup(txn, groupId)
+		return err
+	})
+	return
+}
+
+// UpdateGroup changes the group with update in one transaction, the indexes
+// follow its members and invite. Returns badger.ErrKeyNotFound if there is
+// no such group.
+func (d *Database) UpdateGroup(groupId string, update func(group *models.Group) error) (group *models.Group, err error) {
+	err = d.Update(func(txn *badger.Txn) error {
+		old, err := getGroup(txn, groupId)
+		if err != nil {
+			return err
+		}
+		// Decoded again so update can't change old
+		group, err = getGroup(txn, groupId)
+		if err != nil {
+			return err
+		}
+
+		if err := update(group); err != nil {
+			return err
+		}
+		return setGroup(txn, old, group)
+	})
+	return
+}
+
+// GetGroupByInvite returns the group the invite code with hash is for.
+func (d *Database) GetGroupByInvite(hash string) (group *models.Group, err error) {
+	err = d.db.View(func(txn *badger.Txn) error {
+		itm, err := txn.Get(groupInviteKey(hash))
+		if err != nil {
+			return err
+		}
+		groupId, err := itm.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		group, err = getGroup(txn, string(groupId))
+		return err
+	})
+	return
+}
+
+func (d *Database) GetUserGroups(userId string) (groups []*models.Group, err error) {
+	err = d.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefix := userGroupsPrefix(userId)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			group, err := getGroup(txn, string(it.Item().Key()[len(prefix):]))
+			if err == badger.ErrKeyNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+			groups = append(groups, group)
+		}
+		return nil
+	})
+	return
+}
+
+// DeleteGroup removes the group with its playlists, invite and member
+// indexes.
+func (d *Database) DeleteGroup(groupId string) error {
+	return d.Update(func(txn *badger.Txn) error {
+		group, err := getGroup(txn, groupId)
+		if err != nil {
+			return err
+		}
+
+		for _, member := range group.Members {
+			if err := txn.Delete(userGroupKey(member.UserId, groupId)); err != nil {
+				return err
+			}
+		}
+		if group.InviteHash != "" {
+			if err := txn.Delete(groupInviteKey(group.InviteHash)); err != nil {
+				return err
+			}
+		}
+		if err := deletePrefix(txn, groupPlaylistsPrefix(groupId)); err != nil {
+			return err
+		}
+		return txn.Delete(groupKey(groupId))
+	})
+}
+
+func (d *Database) PutGroupPlaylist(playlist *models.GroupPlaylist) error {
+	data, err := gobEncode(playlist)
+	if err != nil {
+		return err
+	}
+
+	return d.Update(func(txn *badger.Txn) error {
+		return txn.Set(groupPlaylistKey(playlist.GroupId, playlist.Date), data)
+	})
+}
+
+func (d *Database) GetGroupPlaylist(groupId, date string) (playlist *models.GroupPlaylist, err error) {
+	err = d.db.View(func(txn *badger.Txn) error {
+		itm, err := txn.Get(groupPlaylistKey(groupId, date))
+		if err != nil {
+			return err
+		}
+		return itm.Value(func(val []byte) error {
+			return gobDecode(val, &playlist)
+		})
+	})
+	return
+}
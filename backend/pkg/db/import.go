@@ -0,0 +1,153 @@
+package db
+
+import (
+	"github.com/dgraph-io/badger/v3"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+)
+
+// importBatchSize is how many changes ImportUserData makes per transaction,
+// small enough to stay well under badger's transaction size limit.
+const importBatchSize = 500
+
+// UserImport is a user's library and mood playlists staged for
+// ImportUserData.
+type UserImport struct {
+	// Scan replaces the user's library scan when it is not nil
+	Scan *models.LibraryScan
+	// Library tracks are added to the library. Ignored tracks are marked
+	// ignored and taken out of the library.
+	Library   map[string]models.MinTrack
+	Ignored   []string
+	Playlists []*models.MoodPlaylist
+}
+
+// playlistDates lists the dates of every one of the user's mood playlists.
+func (d *Database) playlistDates(userId string) (dates []string, err error) {
+	err = d.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefix := keyMoodPlaylistPrefix(userId)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			dates = append(dates, string(it.Item().Key()[len(prefix):]))
+		}
+		return nil
+	})
+	return
+}
+
+// ImportUserData writes data to the user's library and mood playlists in
+// batches. With replace, library tracks, ignored tracks and playlists that
+// are not in data are removed, but only once everything in data has been
+// written, so a failure part way never leaves the user with less than they
+// started with.
+func (d *Database) ImportUserData(userId string, data *UserImport, replace bool) error {
+	var changes []func(txn *badger.Txn) error
+
+	if data.Scan != nil {
+		changes = append(changes, func(txn *badger.Txn) error {
+			return setLibraryScan(txn, userId, data.Scan)
+		})
+	}
+
+	ignored := map[string]bool{}
+	for _, trackId := range data.Ignored {
+		trackId := trackId
+		ignored[trackId] = true
+		changes = append(changes, func(txn *badger.Txn) error {
+			if err := deleteLibraryTrack(txn, userId, trackId); err != nil && err != badger.ErrKeyNotFound {
+				return err
+			}
+			return txn.Set(ignoredKey(userId, trackId), []byte{})
+		})
+	}
+
+	for trackId, track := range data.Library {
+		trackId, track := trackId, track
+		if ignored[trackId] {
+			continue
+		}
+		changes = append(changes, func(txn *badger.Txn) error {
+			return putLibraryTrack(txn, userId, trackId, track)
+		})
+	}
+
+	dates := map[string]bool{}
+	for _, playlist := range data.Playlists {
+		playlist := playlist
+		dates[playlist.Date.Format(models.DateFormat)] = true
+		changes = append(changes, func(txn *badger.Txn) error {
+			return setMoodPlaylist(txn, userId, playlist)
+		})
+	}
+
+	if replace {
+		library, err := d.GetLibraryTracks(userId)
+		if err != nil {
+			return err
+		}
+		for trackId := range library {
+			trackId := trackId
+			if _, ok := data.Library[trackId]; ok && !ignored[trackId] {
+				continue
+			}
+			changes = append(changes, func(txn *badger.Txn) error {
+				if err := deleteLibraryTrack(txn, userId, trackId); err != nil && err != badger.ErrKeyNotFound {
+					return err
+				}
+				return nil
+			})
+		}
+
+		existingIgnored, err := d.GetIgnoredTracks(userId)
+		if err != nil {
+			return err
+		}
+		for _, trackId := range existingIgnored {
+			trackId := trackId
+			if ignored[trackId] {
+				continue
+			}
+			changes = append(changes, func(txn *badger.Txn) error {
+				return txn.Delete(ignoredKey(userId, trackId))
+			})
+		}
+
+		existingDates, err := d.playlistDates(userId)
+		if err != nil {
+			return err
+		}
+		for _, date := range existingDates {
+			date := date
+			if dates[date] {
+				continue
+			}
+			changes = append(changes, func(txn *badger.Txn) error {
+				return deleteMoodPlaylist(txn, userId, date)
+			})
+		}
+	}
+
+	for start := 0; start < len(changes); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(changes) {
+			end = len(changes)
+		}
+
+		err := d.Update(func(txn *badger.Txn) error {
+			for _, change := range changes[start:end] {
+				if err := change(txn); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,428 @@
+package db
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"strings"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+)
+
+// A user's library is stored as one key per track so that syncing a page of
+// saved tracks or removing a single track only touches the keys involved.
+//
+//	users/<id>/scan              models.LibraryScan
+//	users/<id>/lib/<track>       models.MinTrack
+//	users/<id>/ignored/<track>   empty
+//
+// Every library track also has an entry in the mood index, keyed by the
+// position of its mood and energy category, so generation can read only the
+// buckets it needs.
+//
+//	users/<id>/mood/<mood>/<energy>/<track>   models.MinTrack
+
+func gobEncode(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gobDecode(val []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewBuffer(val)).Decode(v)
+}
+
+func userPrefix(userId string) string {
+	return fmt.Sprintf("users/%s/", userId)
+}
+
+func libraryScanKey(userId string) []byte {
+	return []byte(userPrefix(userId) + "scan")
+}
+
+func libraryPrefix(userId string) []byte {
+	return []byte(userPrefix(userId) + "lib/")
+}
+
+func libraryKey(userId, trackId string) []byte {
+	return append(libraryPrefix(userId), trackId...)
+}
+
+func ignoredPrefix(userId string) []byte {
+	return []byte(userPrefix(userId) + "ignored/")
+}
+
+func ignoredKey(userId, trackId string) []byte {
+	return append(ignoredPrefix(userId), trackId...)
+}
+
+func moodIndexPrefix(userId string) []byte {
+	return []byte(userPrefix(userId) + "mood/")
+}
+
+func moodBucketPrefix(userId string, mood models.Mood) []byte {
+	return []byte(fmt.Sprintf("%s%d/", moodIndexPrefix(userId), mood.Index()))
+}
+
+func moodIndexKey(userId, trackId string, track models.MinTrack) []byte {
+	return []byte(fmt.Sprintf(
+		"%s%d/%s", moodBucketPrefix(userId, models.TrackMood(track.Valence)),
+		models.TrackEnergy(track.Energy).Index(), trackId,
+	))
+}
+
+func legacyUserTracksKey(userId string) []byte {
+	return []byte(fmt.Sprintf("user/tracks/%s", userId))
+}
+
+func keyExists(txn *badger.Txn, key []byte) (bool, error) {
+	_, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func deletePrefix(txn *badger.Txn, prefix []byte) error {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+
+	var keys [][]byte
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		keys = append(keys, it.Item().KeyCopy(nil))
+	}
+	it.Close()
+
+	for _, key := range keys {
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getLibraryScan(txn *badger.Txn, userId string) (scan *models.LibraryScan, err error) {
+	itm, err := txn.Get(libraryScanKey(userId))
+	if err != nil {
+		return nil, err
+	}
+	err = itm.Value(func(val []byte) error {
+		return gobDecode(val, &scan)
+	})
+	return
+}
+
+func setLibraryScan(txn *badger.Txn, userId string, scan *models.LibraryScan) error {
+	data, err := gobEncode(scan)
+	if err != nil {
+		return err
+	}
+	return txn.Set(libraryScanKey(userId), data)
+}
+
+func getLibraryTrack(txn *badger.Txn, userId, trackId string) (track models.MinTrack, err error) {
+	itm, err := txn.Get(libraryKey(userId, trackId))
+	if err != nil {
+		return
+	}
+	err = itm.Value(func(val []byte) error {
+		return gobDecode(val, &track)
+	})
+	return
+}
+
+// putLibraryTrack adds or updates a library track along with its mood index
+// entry.
+func putLibraryTrack(txn *badger.Txn, userId, trackId string, track models.MinTrack) error {
+	existing, err := getLibraryTrack(txn, userId, trackId)
+	if err == nil {
+		if err := txn.Delete(moodIndexKey(userId, trackId, existing)); err != nil {
+			return err
+		}
+	} else if err != badger.ErrKeyNotFound {
+		return err
+	}
+
+	data, err := gobEncode(track)
+	if err != nil {
+		return err
+	}
+
+	if err := txn.Set(libraryKey(userId, trackId), data); err != nil {
+		return err
+	}
+
+	return txn.Set(moodIndexKey(userId, trackId, track), data)
+}
+
+// deleteLibraryTrack removes a library track along with its mood index entry.
+// It returns badger.ErrKeyNotFound if the track is not in the library.
+func deleteLibraryTrack(txn *badger.Txn, userId, trackId string) error {
+	existing, err := getLibraryTrack(txn, userId, trackId)
+	if err != nil {
+		return err
+	}
+
+	if err := txn.Delete(moodIndexKey(userId, trackId, existing)); err != nil {
+		return err
+	}
+
+	return txn.Delete(libraryKey(userId, trackId))
+}
+
+func (d *Database) GetLibraryScan(userId string) (scan *models.LibraryScan, err error) {
+	err = d.db.View(func(txn *badger.Txn) error {
+		scan, err = getLibraryScan(txn, userId)
+		return err
+	})
+	return
+}
+
+// SaveLibraryPage adds a page of synced tracks to the user's library and
+// records the scan position in a single transaction. Tracks the user has
+// removed stay removed.
+func (d *Database) SaveLibraryPage(userId string, scan *models.LibraryScan, tracks map[string]models.MinTrack) error {
+	return d.Update(func(txn *badger.Txn) error {
+		for trackId, track := range tracks {
+			ignored, err := keyExists(txn, ignoredKey(userId, trackId))
+			if err != nil {
+				return err
+			}
+			if ignored {
+				continue
+			}
+
+			if err := putLibraryTrack(txn, userId, trackId, track); err != nil {
+				return err
+			}
+		}
+
+		return setLibraryScan(txn, userId, scan)
+	})
+}
+
+func (d *Database) GetLibraryTracks(userId string) (tracks map[string]models.MinTrack, err error) {
+	tracks = make(map[string]models.MinTrack)
+	err = d.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := libraryPrefix(userId)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var track models.MinTrack
+			err := it.Item().Value(func(val []byte) error {
+				return gobDecode(val, &track)
+			})
+			if err != nil {
+				return err
+			}
+			tracks[string(it.Item().Key()[len(prefix):])] = track
+		}
+		return nil
+	})
+	return
+}
+
+// GetLibraryMoodBucket returns the user's library tracks in the given mood
+// category.
+func (d *Database) GetLibraryMoodBucket(userId string, mood models.Mood) (tracks map[string]models.MinTrack, err error) {
+	tracks = make(map[string]models.MinTrack)
+	if mood.Index() < 0 {
+		return
+	}
+
+	err = d.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := moodBucketPrefix(userId, mood)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			// <energy>/<track>
+			key := string(it.Item().Key()[len(prefix):])
+			trackId := key[strings.Index(key, "/")+1:]
+
+			var track models.MinTrack
+			err := it.Item().Value(func(val []byte) error {
+				return gobDecode(val, &track)
+			})
+			if err != nil {
+				return err
+			}
+			tracks[trackId] = track
+		}
+		return nil
+	})
+	return
+}
+
+func (d *Database) LibraryTrackCount(userId string) (count int) {
+	d.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefix := libraryPrefix(userId)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			count++
+		}
+		return nil
+	})
+	return
+}
+
+func (d *Database) GetIgnoredTracks(userId string) (trackIds []string, err error) {
+	err = d.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefix := ignoredPrefix(userId)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			trackIds = append(trackIds, string(it.Item().Key()[len(prefix):]))
+		}
+		return nil
+	})
+	return
+}
+
+// RemoveLibraryTrack moves a track from the user's library to their ignored
+// tracks. It returns badger.ErrKeyNotFound if the track is not in the library.
+func (d *Database) RemoveLibraryTrack(userId, trackId string) error {
+	return d.Update(func(txn *badger.Txn) error {
+		if err := deleteLibraryTrack(txn, userId, trackId); err != nil {
+			return err
+		}
+
+		return txn.Set(ignoredKey(userId, trackId), []byte{})
+	})
+}
+
+// UnremoveLibraryTrack moves a track from the user's ignored tracks back into
+// their library. It returns badger.ErrKeyNotFound if the track is not ignored
+// or its metadata is missing.
+func (d *Database) UnremoveLibraryTrack(userId, trackId string) error {
+	return d.Update(func(txn *badger.Txn) error {
+		if _, err := txn.Get(ignoredKey(userId, trackId)); err != nil {
+			return err
+		}
+
+		itm, err := txn.Get(trackKey(trackId))
+		if err != nil {
+			return err
+		}
+		var track *models.Track
+		err = itm.Value(func(val []byte) error {
+			return gobDecode(val, &track)
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := txn.Delete(ignoredKey(userId, trackId)); err != nil {
+			return err
+		}
+
+		return putLibraryTrack(txn, userId, trackId, models.MinTrack{Valence: track.Valence, Energy: track.Energy})
+	})
+}
+
+func clearUserTracks(txn *badger.Txn, userId string) error {
+	if err := txn.Delete(libraryScanKey(userId)); err != nil {
+		return err
+	}
+
+	if err := txn.Delete(legacyUserTracksKey(userId)); err != nil {
+		return err
+	}
+
+	if err := deletePrefix(txn, libraryPrefix(userId)); err != nil {
+		return err
+	}
+
+	if err := deletePrefix(txn, moodIndexPrefix(userId)); err != nil {
+		return err
+	}
+
+	return deletePrefix(txn, ignoredPrefix(userId))
+}
+
+func setUserTracks(txn *badger.Txn, userId string, userTracks *models.UserTracks) error {
+	if err := clearUserTracks(txn, userId); err != nil {
+		return err
+	}
+
+	err := setLibraryScan(txn, userId, &models.LibraryScan{
+		LastOffset:       userTracks.LastOffset,
+		CompletedScan:    userTracks.CompletedScan,
+		LastTrackScanned: userTracks.LastTrackScanned,
+	})
+	if err != nil {
+		return err
+	}
+
+	for trackId, track := range userTracks.TrackIds {
+		if err := putLibraryTrack(txn, userId, trackId, track); err != nil {
+			return err
+		}
+	}
+
+	for trackId := range userTracks.IgnoredTracks {
+		if err := txn.Set(ignoredKey(userId, trackId), []byte{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetUserTracks replaces the user's whole library.
+func (d *Database) SetUserTracks(userId string, userTracks *models.UserTracks) error {
+	return d.Update(func(txn *badger.Txn) error {
+		return setUserTracks(txn, userId, userTracks)
+	})
+}
+
+// GetUserTracks assembles a full view of the user's library. It returns
+// badger.ErrKeyNotFound if the user's library has never been synced.
+func (d *Database) GetUserTracks(userId string) (*models.UserTracks, error) {
+	scan, err := d.GetLibraryScan(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	trackIds, err := d.GetLibraryTracks(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	ignored, err := d.GetIgnoredTracks(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.UserTracks{
+		UserId:           userId,
+		LastOffset:       scan.LastOffset,
+		TrackIds:         trackIds,
+		IgnoredTracks:    make(map[string]interface{}),
+		CompletedScan:    scan.CompletedScan,
+		LastTrackScanned: scan.LastTrackScanned,
+	}
+	for _, trackId := range ignored {
+		result.IgnoredTracks[trackId] = nil
+	}
+
+	return result, nil
+}
+
+func (d *Database) ClearUserTracks(userId string) error {
+	return d.Update(func(txn *badger.Txn) error {
+		return clearUserTracks(txn, userId)
+	})
+}
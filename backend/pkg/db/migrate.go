@@ -0,0 +1,177 @@
+package db
+
+import (
+	"encoding/binary"
+	"log"
+	"strings"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+)
+
+var schemaVersionKey = []byte("meta/schema_version")
+
+// migrations are run in order on start up, each one exactly once.
+var migrations = []func(d *Database) error{
+	(*Database).migrateLegacyUserTracks,
+	(*Database).buildMoodIndex,
+	(*Database).indexNotes,
+}
+
+func (d *Database) schemaVersion() (version uint64, err error) {
+	err = d.db.View(func(txn *badger.Txn) error {
+		itm, err := txn.Get(schemaVersionKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return itm.Value(func(val []byte) error {
+			version = binary.BigEndian.Uint64(val)
+			return nil
+		})
+	})
+	return
+}
+
+// LatestSchemaVersion is the schema version a database is at once every
+// migration has run.
+func LatestSchemaVersion() uint64 {
+	return uint64(len(migrations))
+}
+
+// SchemaVersion returns the number of migrations run on the database.
+func (d *Database) SchemaVersion() (uint64, error) {
+	return d.schemaVersion()
+}
+
+func (d *Database) migrate() error {
+	version, err := d.schemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for ; version < uint64(len(migrations)); version++ {
+		if err := migrations[version](d); err != nil {
+			return err
+		}
+
+		val := make([]byte, 8)
+		binary.BigEndian.PutUint64(val, version+1)
+		err := d.db.Update(func(txn *badger.Txn) error {
+			return txn.Set(schemaVersionKey, val)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateLegacyUserTracks splits the old single record user/tracks/<id>
+// libraries into per track keys.
+func (d *Database) migrateLegacyUserTracks() error {
+	var legacy []*models.UserTracks
+	err := d.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := []byte("user/tracks/")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			userId := strings.TrimPrefix(string(it.Item().Key()), string(prefix))
+
+			var userTracks *models.UserTracks
+			err := it.Item().Value(func(val []byte) error {
+				return gobDecode(val, &userTracks)
+			})
+			if err != nil {
+				return err
+			}
+			userTracks.UserId = userId
+			legacy = append(legacy, userTracks)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, userTracks := range legacy {
+		if err := d.SetUserTracks(userTracks.UserId, userTracks); err != nil {
+			return err
+		}
+		log.Printf("Migrated library for %s to per track keys", userTracks.UserId)
+	}
+
+	return nil
+}
+
+// buildMoodIndex adds mood index entries for libraries stored before the
+// index existed.
+func (d *Database) buildMoodIndex() error {
+	type libraryTrack struct {
+		userId  string
+		trackId string
+		track   models.MinTrack
+	}
+
+	var tracks []libraryTrack
+	err := d.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := []byte("users/")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			// users/<id>/lib/<track>
+			parts := strings.SplitN(string(it.Item().Key()), "/", 4)
+			if len(parts) != 4 || parts[2] != "lib" {
+				continue
+			}
+
+			var track models.MinTrack
+			err := it.Item().Value(func(val []byte) error {
+				return gobDecode(val, &track)
+			})
+			if err != nil {
+				return err
+			}
+			tracks = append(tracks, libraryTrack{parts[1], parts[3], track})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	const batchSize = 1000
+	for start := 0; start < len(tracks); start += batchSize {
+		end := start + batchSize
+		if end > len(tracks) {
+			end = len(tracks)
+		}
+
+		err := d.Update(func(txn *badger.Txn) error {
+			for _, libraryTrack := range tracks[start:end] {
+				data, err := gobEncode(libraryTrack.track)
+				if err != nil {
+					return err
+				}
+				err = txn.Set(moodIndexKey(libraryTrack.userId, libraryTrack.trackId, libraryTrack.track), data)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(tracks) > 0 {
+		log.Printf("Built mood index for %d library tracks", len(tracks))
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+	"github.com/sardap/TuneNeutral/backend/pkg/search"
+)
+
+// Playlist notes are indexed by the terms in them so they can be searched.
+// The index is written in the same transaction as the playlist so the two
+// never disagree.
+//
+//	users/<id>/notes/<term>/<date>   []int positions of the term in the note
+
+func notesIndexPrefix(userId string) []byte {
+	return []byte(userPrefix(userId) + "notes/")
+}
+
+func notesTermPrefix(userId, term string) []byte {
+	return append(notesIndexPrefix(userId), term+"/"...)
+}
+
+func notesIndexKey(userId, term, date string) []byte {
+	return append(notesTermPrefix(userId, term), date...)
+}
+
+func noteText(note *string) string {
+	if note == nil {
+		return ""
+	}
+	return *note
+}
+
+// indexNote replaces the index entries for the note on date.
+func indexNote(txn *badger.Txn, userId, date string, old, note *string) error {
+	for term := range search.Terms(noteText(old)) {
+		if err := txn.Delete(notesIndexKey(userId, term, date)); err != nil {
+			return err
+		}
+	}
+
+	for term, positions := range search.Terms(noteText(note)) {
+		data, err := gobEncode(positions)
+		if err != nil {
+			return err
+		}
+		if err := txn.Set(notesIndexKey(userId, term, date), data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UpdateMoodPlaylistNote replaces the note of the playlist on date. It
+// returns badger.ErrKeyNotFound if there is no playlist that day.
+func (d *Database) UpdateMoodPlaylistNote(userId, date, note string) (playlist *models.MoodPlaylist, err error) {
+	err = d.Update(func(txn *badger.Txn) error {
+		itm, err := txn.Get(keyMoodPlaylist(userId, date))
+		if err != nil {
+			return err
+		}
+		err = itm.Value(func(val []byte) error {
+			return gobDecode(val, &playlist)
+		})
+		if err != nil {
+			return err
+		}
+
+		playlist.Note = &note
+		return setMoodPlaylist(txn, userId, playlist)
+	})
+	return
+}
+
+// SearchNotes finds the notes from from to to, inclusive dates that may be
+// empty, that have every term. It returns each note's date mapped to the
+// positions of the terms in it.
+func (d *Database) SearchNotes(userId string, terms []string, from, to string) (map[string]map[string][]int, error) {
+	var result map[string]map[string][]int
+	err := d.db.View(func(txn *badger.Txn) error {
+		for _, term := range terms {
+			found := map[string]map[string][]int{}
+
+			prefix := notesTermPrefix(userId, term)
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = prefix
+			it := txn.NewIterator(opts)
+
+			for it.Seek(append(prefix, from...)); it.ValidForPrefix(prefix); it.Next() {
+				date := string(it.Item().Key()[len(prefix):])
+				if to != "" && date > to {
+					break
+				}
+				// Later terms only narrow what the first found
+				if result != nil && result[date] == nil {
+					continue
+				}
+
+				var positions []int
+				err := it.Item().Value(func(val []byte) error {
+					return gobDecode(val, &positions)
+				})
+				if err != nil {
+					it.Close()
+					return err
+				}
+
+				found[date] = map[string][]int{}
+				if result != nil {
+					found[date] = result[date]
+				}
+				found[date][term] = positions
+			}
+			it.Close()
+
+			result = found
+			if len(result) == 0 {
+				break
+			}
+		}
+		return nil
+	})
+	if result == nil {
+		result = map[string]map[string][]int{}
+	}
+	return result, err
+}
+
+// indexNotes builds the note index for playlists stored before it existed.
+func (d *Database) indexNotes() error {
+	type note struct {
+		userId string
+		date   string
+		note   *string
+	}
+
+	var notes []note
+	err := d.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := []byte("user/playlist/")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			// user/playlist/<id>/<date>
+			parts := strings.Split(strings.TrimPrefix(string(it.Item().Key()), string(prefix)), "/")
+			if len(parts) != 2 {
+				continue
+			}
+
+			var playlist models.MoodPlaylist
+			err := it.Item().Value(func(val []byte) error {
+				return gobDecode(val, &playlist)
+			})
+			if err != nil || playlist.Note == nil {
+				continue
+			}
+			notes = append(notes, note{parts[0], parts[1], playlist.Note})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	const batchSize = 100
+	for start := 0; start < len(notes); start += batchSize {
+		end := start + batchSize
+		if end > len(notes) {
+			end = len(notes)
+		}
+
+		err := d.Update(func(txn *badger.Txn) error {
+			for _, note := range notes[start:end] {
+				if err := indexNote(txn, note.userId, note.date, nil, note.note); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("indexing notes: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"github.com/dgraph-io/badger/v3"
+)
+
+//	users/<id>/scopes                  []string, OAuth scopes the user granted
+
+func userScopesKey(userId string) []byte {
+	return []byte(userPrefix(userId) + "scopes")
+}
+
+// GetUserScopes returns the scopes the user last granted. Returns
+// badger.ErrKeyNotFound if they were never recorded.
+func (d *Database) GetUserScopes(userId string) ([]string, error) {
+	var result []string
+	err := d.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(userScopesKey(userId))
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			return gobDecode(val, &result)
+		})
+	})
+
+	return result, err
+}
+
+func (d *Database) SetUserScopes(userId string, scopes []string) error {
+	data, err := gobEncode(scopes)
+	if err != nil {
+		return err
+	}
+
+	return d.Update(func(txn *badger.Txn) error {
+		return txn.Set(userScopesKey(userId), data)
+	})
+}
+
+func (d *Database) ClearUserScopes(userId string) error {
+	return d.Update(func(txn *badger.Txn) error {
+		return txn.Delete(userScopesKey(userId))
+	})
+}
@@ -0,0 +1,174 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+)
+
+//	sessions/<session>                 models.Session
+//	users/<id>/sessions/<session>      empty, lets a user's sessions be listed
+
+func sessionKey(sessionId string) []byte {
+	return []byte(fmt.Sprintf("sessions/%s", sessionId))
+}
+
+func userSessionsPrefix(userId string) []byte {
+	return []byte(userPrefix(userId) + "sessions/")
+}
+
+func userSessionKey(userId, sessionId string) []byte {
+	return append(userSessionsPrefix(userId), sessionId...)
+}
+
+func getSession(txn *badger.Txn, sessionId string) (session *models.Session, err error) {
+	itm, err := txn.Get(sessionKey(sessionId))
+	if err != nil {
+		return nil, err
+	}
+	err = itm.Value(func(val []byte) error {
+		return gobDecode(val, &session)
+	})
+	return
+}
+
+func deleteSession(txn *badger.Txn, sessionId string) error {
+	session, err := getSession(txn, sessionId)
+	if err != nil {
+		return err
+	}
+
+	if session.UserId != "" {
+		if err := txn.Delete(userSessionKey(session.UserId, sessionId)); err != nil {
+			return err
+		}
+	}
+
+	return txn.Delete(sessionKey(sessionId))
+}
+
+func (d *Database) GetSession(sessionId string) (session *models.Session, err error) {
+	err = d.db.View(func(txn *badger.Txn) error {
+		session, err = getSession(txn, sessionId)
+		return err
+	})
+	return
+}
+
+// SetSession stores session until its ExpiresAt. Unless create is set the
+// session must already exist, so a session revoked while a request using it
+// was in flight stays revoked, badger.ErrKeyNotFound is returned instead.
+func (d *Database) SetSession(session *models.Session, create bool) error {
+	return d.Update(func(txn *badger.Txn) error {
+		existing, err := getSession(txn, session.Id)
+		if err == badger.ErrKeyNotFound && create {
+			existing = nil
+		} else if err != nil {
+			return err
+		}
+
+		if existing != nil {
+			session.CreatedAt = existing.CreatedAt
+
+			if existing.UserId != session.UserId && existing.UserId != "" {
+				if err := txn.Delete(userSessionKey(existing.UserId, session.Id)); err != nil {
+					return err
+				}
+			}
+		}
+
+		data, err := gobEncode(session)
+		if err != nil {
+			return err
+		}
+
+		expiresAt := uint64(session.ExpiresAt.Unix())
+
+		entry := badger.NewEntry(sessionKey(session.Id), data)
+		entry.ExpiresAt = expiresAt
+		if err := txn.SetEntry(entry); err != nil {
+			return err
+		}
+
+		if session.UserId == "" {
+			return nil
+		}
+
+		entry = badger.NewEntry(userSessionKey(session.UserId, session.Id), []byte{})
+		entry.ExpiresAt = expiresAt
+		return txn.SetEntry(entry)
+	})
+}
+
+// DeleteSession returns badger.ErrKeyNotFound if there is no such session.
+func (d *Database) DeleteSession(sessionId string) error {
+	return d.Update(func(txn *badger.Txn) error {
+		return deleteSession(txn, sessionId)
+	})
+}
+
+func userSessionIds(txn *badger.Txn, userId string) (sessionIds []string) {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	prefix := userSessionsPrefix(userId)
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		sessionIds = append(sessionIds, string(it.Item().Key()[len(prefix):]))
+	}
+	return
+}
+
+func (d *Database) GetUserSessions(userId string) (sessions []*models.Session, err error) {
+	err = d.db.View(func(txn *badger.Txn) error {
+		for _, sessionId := range userSessionIds(txn, userId) {
+			session, err := getSession(txn, sessionId)
+			if err == badger.ErrKeyNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+			sessions = append(sessions, session)
+		}
+		return nil
+	})
+	return
+}
+
+func (d *Database) DeleteUserSessions(userId string) error {
+	return d.Update(func(txn *badger.Txn) error {
+		for _, sessionId := range userSessionIds(txn, userId) {
+			err := deleteSession(txn, sessionId)
+			if err == badger.ErrKeyNotFound {
+				// The index outlived the session, drop it too
+				err = txn.Delete(userSessionKey(userId, sessionId))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// TouchSession records that the session was used at t.
+func (d *Database) TouchSession(sessionId string, t time.Time) error {
+	return d.Update(func(txn *badger.Txn) error {
+		session, err := getSession(txn, sessionId)
+		if err != nil {
+			return err
+		}
+		session.LastSeen = t
+
+		data, err := gobEncode(session)
+		if err != nil {
+			return err
+		}
+		entry := badger.NewEntry(sessionKey(sessionId), data)
+		entry.ExpiresAt = uint64(session.ExpiresAt.Unix())
+		return txn.SetEntry(entry)
+	})
+}
@@ -0,0 +1,153 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+)
+
+//	shares/<hash>                      models.ShareLink
+//	users/<id>/shares/<share id>       <hash>, lets a user's share links be listed
+
+func shareLinkKey(hash string) []byte {
+	return []byte(fmt.Sprintf("shares/%s", hash))
+}
+
+func userShareLinksPrefix(userId string) []byte {
+	return []byte(userPrefix(userId) + "shares/")
+}
+
+func userShareLinkKey(userId, shareId string) []byte {
+	return append(userShareLinksPrefix(userId), shareId...)
+}
+
+func getShareLink(txn *badger.Txn, hash string) (link *models.ShareLink, err error) {
+	itm, err := txn.Get(shareLinkKey(hash))
+	if err != nil {
+		return nil, err
+	}
+	err = itm.Value(func(val []byte) error {
+		return gobDecode(val, &link)
+	})
+	return
+}
+
+func setShareLink(txn *badger.Txn, hash string, link *models.ShareLink) error {
+	data, err := gobEncode(link)
+	if err != nil {
+		return err
+	}
+	return txn.Set(shareLinkKey(hash), data)
+}
+
+// PutShareLink stores link under the hash of its token.
+func (d *Database) PutShareLink(hash string, link *models.ShareLink) error {
+	return d.Update(func(txn *badger.Txn) error {
+		if err := setShareLink(txn, hash, link); err != nil {
+			return err
+		}
+		return txn.Set(userShareLinkKey(link.UserId, link.Id), []byte(hash))
+	})
+}
+
+func (d *Database) GetShareLink(hash string) (link *models.ShareLink, err error) {
+	err = d.db.View(func(txn *badger.Txn) error {
+		link, err = getShareLink(txn, hash)
+		return err
+	})
+	return
+}
+
+func userShareLinkHashes(txn *badger.Txn, userId string) (map[string]string, error) {
+	result := map[string]string{}
+
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	prefix := userShareLinksPrefix(userId)
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		hash, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		result[string(it.Item().Key()[len(prefix):])] = string(hash)
+	}
+
+	return result, nil
+}
+
+func (d *Database) GetUserShareLinks(userId string) (links []*models.ShareLink, err error) {
+	err = d.db.View(func(txn *badger.Txn) error {
+		hashes, err := userShareLinkHashes(txn, userId)
+		if err != nil {
+			return err
+		}
+
+		for _, hash := range hashes {
+			link, err := getShareLink(txn, hash)
+			if err == badger.ErrKeyNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+			links = append(links, link)
+		}
+		return nil
+	})
+	return
+}
+
+// DeleteShareLink returns badger.ErrKeyNotFound if the user has no such
+// share link.
+func (d *Database) DeleteShareLink(userId, shareId string) error {
+	return d.Update(func(txn *badger.Txn) error {
+		itm, err := txn.Get(userShareLinkKey(userId, shareId))
+		if err != nil {
+			return err
+		}
+		hash, err := itm.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		if err := txn.Delete(shareLinkKey(string(hash))); err != nil {
+			return err
+		}
+		return txn.Delete(userShareLinkKey(userId, shareId))
+	})
+}
+
+func (d *Database) DeleteUserShareLinks(userId string) error {
+	return d.Update(func(txn *badger.Txn) error {
+		hashes, err := userShareLinkHashes(txn, userId)
+		if err != nil {
+			return err
+		}
+
+		for shareId, hash := range hashes {
+			if err := txn.Delete(shareLinkKey(hash)); err != nil {
+				return err
+			}
+			if err := txn.Delete(userShareLinkKey(userId, shareId)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RecordShareAccess counts one more visit to the share link at t.
+func (d *Database) RecordShareAccess(hash string, t time.Time) (link *models.ShareLink, err error) {
+	err = d.Update(func(txn *badger.Txn) error {
+		link, err = getShareLink(txn, hash)
+		if err != nil {
+			return err
+		}
+		link.Accesses++
+		link.LastAccessed = t
+		return setShareLink(txn, hash, link)
+	})
+	return
+}
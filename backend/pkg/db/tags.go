@@ -0,0 +1,131 @@
+package db
+
+import (
+	"github.com/dgraph-io/badger/v3"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+)
+
+// Tagged playlists are indexed by tag so filtering by a tag, or by every
+// value of a tag's key, only reads the matching dates. Like the note index it
+// is written in the same transaction as the playlist.
+//
+//	users/<id>/tagged/<key>/<value>/<date>   empty
+
+func taggedPrefix(userId string) []byte {
+	return []byte(userPrefix(userId) + "tagged/")
+}
+
+// taggedTagPrefix matches the playlists tagged with exactly tag or, when
+// exact isn't set and tag has no value, with any value of tag's key.
+func taggedTagPrefix(userId string, tag models.Tag, exact bool) []byte {
+	result := append(taggedPrefix(userId), tag.Key+"/"...)
+	if tag.Value != "" || exact {
+		result = append(result, tag.Value+"/"...)
+	}
+	return result
+}
+
+func taggedKey(userId string, tag models.Tag, date string) []byte {
+	return append(taggedPrefix(userId), tag.Key+"/"+tag.Value+"/"+date...)
+}
+
+// indexTags replaces the index entries for the tags of the playlist on date.
+func indexTags(txn *badger.Txn, userId, date string, old, tags []string) error {
+	for _, tag := range old {
+		if err := txn.Delete(taggedKey(userId, models.ParseTag(tag), date)); err != nil {
+			return err
+		}
+	}
+
+	for _, tag := range tags {
+		if err := txn.Set(taggedKey(userId, models.ParseTag(tag), date), []byte{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func taggedDates(txn *badger.Txn, userId string, tag models.Tag, exact bool) map[string]bool {
+	prefix := taggedTagPrefix(userId, tag, exact)
+
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	result := map[string]bool{}
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		key := string(it.Item().Key())
+		result[key[len(key)-len(models.DateFormat):]] = true
+	}
+	return result
+}
+
+// SetMoodPlaylistTags replaces the tags of the playlist on date. It returns
+// badger.ErrKeyNotFound if there is no playlist that day.
+func (d *Database) SetMoodPlaylistTags(userId, date string, tags []string) (playlist *models.MoodPlaylist, err error) {
+	err = d.Update(func(txn *badger.Txn) error {
+		itm, err := txn.Get(keyMoodPlaylist(userId, date))
+		if err != nil {
+			return err
+		}
+		err = itm.Value(func(val []byte) error {
+			return gobDecode(val, &playlist)
+		})
+		if err != nil {
+			return err
+		}
+
+		playlist.Tags = tags
+		return setMoodPlaylist(txn, userId, playlist)
+	})
+	return
+}
+
+// ReplaceTag swaps tag for replacement on every playlist that has exactly
+// tag, or removes it when replacement is nil. It returns how many playlists
+// changed.
+func (d *Database) ReplaceTag(userId string, tag models.Tag, replacement *models.Tag) (changed int, err error) {
+	err = d.Update(func(txn *badger.Txn) error {
+		changed = 0
+		for date := range taggedDates(txn, userId, tag, true) {
+			itm, err := txn.Get(keyMoodPlaylist(userId, date))
+			if err != nil {
+				return err
+			}
+			var playlist models.MoodPlaylist
+			err = itm.Value(func(val []byte) error {
+				return gobDecode(val, &playlist)
+			})
+			if err != nil {
+				return err
+			}
+
+			tags := []string{}
+			seen := map[string]bool{}
+			for _, existing := range playlist.Tags {
+				if existing == tag.String() {
+					if replacement == nil {
+						continue
+					}
+					existing = replacement.String()
+				}
+				// Renaming onto a tag the playlist already has merges them
+				if !seen[existing] {
+					seen[existing] = true
+					tags = append(tags, existing)
+				}
+			}
+
+			playlist.Tags = tags
+			if err := setMoodPlaylist(txn, userId, &playlist); err != nil {
+				return err
+			}
+			changed++
+		}
+		return nil
+	})
+	return
+}
@@ -0,0 +1,206 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+)
+
+//	tokens/<hash>                      models.AccessToken
+//	users/<id>/tokens/<token>          <hash>, lets a user's tokens be listed
+//	users/<id>/spotify_token           encoded oauth2 token used for access tokens,
+//	                                   only kept while the user has one
+
+func accessTokenKey(hash string) []byte {
+	return []byte(fmt.Sprintf("tokens/%s", hash))
+}
+
+func userAccessTokensPrefix(userId string) []byte {
+	return []byte(userPrefix(userId) + "tokens/")
+}
+
+func userAccessTokenKey(userId, tokenId string) []byte {
+	return append(userAccessTokensPrefix(userId), tokenId...)
+}
+
+func userSpotifyTokenKey(userId string) []byte {
+	return []byte(userPrefix(userId) + "spotify_token")
+}
+
+// isSpotifyTokenKey reports whether key is a user's stored Spotify token.
+func isSpotifyTokenKey(key []byte) bool {
+	parts := strings.Split(string(key), "/")
+	return len(parts) == 3 && parts[0] == "users" && parts[2] == "spotify_token"
+}
+
+func getAccessToken(txn *badger.Txn, hash string) (token *models.AccessToken, err error) {
+	itm, err := txn.Get(accessTokenKey(hash))
+	if err != nil {
+		return nil, err
+	}
+	err = itm.Value(func(val []byte) error {
+		return gobDecode(val, &token)
+	})
+	return
+}
+
+func setAccessToken(txn *badger.Txn, hash string, token *models.AccessToken) error {
+	data, err := gobEncode(token)
+	if err != nil {
+		return err
+	}
+	return txn.Set(accessTokenKey(hash), data)
+}
+
+// PutAccessToken stores token under the hash of its secret along with the
+// user's encoded Spotify token, which requests made with it use.
+func (d *Database) PutAccessToken(hash string, token *models.AccessToken, spotifyToken []byte) error {
+	return d.Update(func(txn *badger.Txn) error {
+		if err := setAccessToken(txn, hash, token); err != nil {
+			return err
+		}
+		if err := txn.Set(userAccessTokenKey(token.UserId, token.Id), []byte(hash)); err != nil {
+			return err
+		}
+		return txn.Set(userSpotifyTokenKey(token.UserId), spotifyToken)
+	})
+}
+
+func (d *Database) GetAccessToken(hash string) (token *models.AccessToken, err error) {
+	err = d.db.View(func(txn *badger.Txn) error {
+		token, err = getAccessToken(txn, hash)
+		return err
+	})
+	return
+}
+
+func userAccessTokenHashes(txn *badger.Txn, userId string) (map[string]string, error) {
+	result := map[string]string{}
+
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	prefix := userAccessTokensPrefix(userId)
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		hash, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		result[string(it.Item().Key()[len(prefix):])] = string(hash)
+	}
+
+	return result, nil
+}
+
+func (d *Database) GetUserAccessTokens(userId string) (tokens []*models.AccessToken, err error) {
+	err = d.db.View(func(txn *badger.Txn) error {
+		hashes, err := userAccessTokenHashes(txn, userId)
+		if err != nil {
+			return err
+		}
+
+		for _, hash := range hashes {
+			token, err := getAccessToken(txn, hash)
+			if err == badger.ErrKeyNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+			tokens = append(tokens, token)
+		}
+		return nil
+	})
+	return
+}
+
+// DeleteAccessToken returns badger.ErrKeyNotFound if the user has no such
+// token. The user's Spotify token goes with their last access token.
+func (d *Database) DeleteAccessToken(userId, tokenId string) error {
+	return d.Update(func(txn *badger.Txn) error {
+		itm, err := txn.Get(userAccessTokenKey(userId, tokenId))
+		if err != nil {
+			return err
+		}
+		hash, err := itm.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		if err := txn.Delete(accessTokenKey(string(hash))); err != nil {
+			return err
+		}
+		if err := txn.Delete(userAccessTokenKey(userId, tokenId)); err != nil {
+			return err
+		}
+
+		hashes, err := userAccessTokenHashes(txn, userId)
+		if err != nil {
+			return err
+		}
+		if len(hashes) == 0 {
+			return txn.Delete(userSpotifyTokenKey(userId))
+		}
+		return nil
+	})
+}
+
+func (d *Database) DeleteUserAccessTokens(userId string) error {
+	return d.Update(func(txn *badger.Txn) error {
+		hashes, err := userAccessTokenHashes(txn, userId)
+		if err != nil {
+			return err
+		}
+
+		for tokenId, hash := range hashes {
+			if err := txn.Delete(accessTokenKey(hash)); err != nil {
+				return err
+			}
+			if err := txn.Delete(userAccessTokenKey(userId, tokenId)); err != nil {
+				return err
+			}
+		}
+		return txn.Delete(userSpotifyTokenKey(userId))
+	})
+}
+
+// TouchAccessToken records that the token was used at t.
+func (d *Database) TouchAccessToken(hash string, t time.Time) error {
+	return d.Update(func(txn *badger.Txn) error {
+		token, err := getAccessToken(txn, hash)
+		if err != nil {
+			return err
+		}
+		token.LastUsed = t
+		return setAccessToken(txn, hash, token)
+	})
+}
+
+// GetSpotifyToken returns the user's encoded Spotify token, kept while they
+// have access tokens so requests made with one can reach Spotify without a
+// session.
+func (d *Database) GetSpotifyToken(userId string) (result []byte, err error) {
+	err = d.db.View(func(txn *badger.Txn) error {
+		itm, err := txn.Get(userSpotifyTokenKey(userId))
+		if err != nil {
+			return err
+		}
+		result, err = itm.ValueCopy(nil)
+		return err
+	})
+	return
+}
+
+// UpdateSpotifyToken replaces the user's stored Spotify token with a newer
+// one. Nothing is stored for users without access tokens.
+func (d *Database) UpdateSpotifyToken(userId string, token []byte) error {
+	return d.Update(func(txn *badger.Txn) error {
+		hashes, err := userAccessTokenHashes(txn, userId)
+		if err != nil || len(hashes) == 0 {
+			return err
+		}
+		return txn.Set(userSpotifyTokenKey(userId), token)
+	})
+}
@@ -0,0 +1,84 @@
+package feed
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+)
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	Id         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Categories []atomCategory `xml:"category"`
+	Content    *atomText      `xml:"content,omitempty"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Id      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  string      `xml:"author>name"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// WriteAtom writes the newest AtomEntries of entries, which are oldest first,
+// as an Atom feed. selfUrl is where the feed is served from without its token
+// and identifies it.
+func WriteAtom(w io.Writer, selfUrl, userId string, entries []Entry, now time.Time) error {
+	document := atomFeed{
+		Id:      selfUrl,
+		Title:   "Tune Neutral mood journal",
+		Updated: now.UTC().Format(time.RFC3339),
+		Author:  userId,
+		Link:    atomLink{Rel: "self", Href: selfUrl},
+	}
+	if len(entries) > 0 {
+		document.Updated = entries[len(entries)-1].Date.UTC().Format(time.RFC3339)
+	}
+
+	for i := len(entries) - 1; i >= 0 && len(document.Entries) < AtomEntries; i-- {
+		entry := entries[i]
+		result := atomEntry{
+			Id:      selfUrl + "#" + entry.Date.Format(models.DateFormat),
+			Title:   entry.Date.Format(models.DateFormat) + ": " + entry.Title(),
+			Updated: entry.Date.UTC().Format(time.RFC3339),
+		}
+		for _, tag := range entry.Tags {
+			result.Categories = append(result.Categories, atomCategory{Term: tag})
+		}
+		if description := entry.Description(); description != "" {
+			result.Content = &atomText{Type: "text", Body: description}
+		}
+		document.Entries = append(document.Entries, result)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(document); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
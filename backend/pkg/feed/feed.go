@@ -0,0 +1,100 @@
+package feed
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/db"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+)
+
+// AtomEntries is how many of the newest entries the Atom feed carries, feed
+// readers only look at the top.
+const AtomEntries = 50
+
+// Entry is a day of the mood journal with its tracks ready to show.
+type Entry struct {
+	Date      time.Time
+	StartMood float32
+	Note      string
+	Tags      []string
+	// Tracks are "title by artists", tracks no longer stored are left out
+	Tracks []string
+}
+
+// Title sums the entry up in a line.
+func (e Entry) Title() string {
+	return fmt.Sprintf("Mood %+.2f", e.StartMood)
+}
+
+// Description is the note, tags and track list as plain text.
+func (e Entry) Description() string {
+	var sections []string
+	if e.Note != "" {
+		sections = append(sections, e.Note)
+	}
+	if len(e.Tags) > 0 {
+		sections = append(sections, "Tags: "+strings.Join(e.Tags, ", "))
+	}
+	if len(e.Tracks) > 0 {
+		lines := []string{"Tracks:"}
+		for i, track := range e.Tracks {
+			lines = append(lines, fmt.Sprintf("%d. %s", i+1, track))
+		}
+		sections = append(sections, strings.Join(lines, "\n"))
+	}
+	return strings.Join(sections, "\n\n")
+}
+
+func trackLine(track *models.Track) string {
+	var artists []string
+	for _, artist := range track.Artists {
+		artists = append(artists, artist.Name)
+	}
+	if len(artists) == 0 {
+		return track.Name
+	}
+	return track.Name + " by " + strings.Join(artists, ", ")
+}
+
+// Collect reads the user's whole mood journal, oldest first.
+func Collect(dbConn *db.Database, userId string) ([]Entry, error) {
+	playlists, err := dbConn.GetMoodPlaylists(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, playlist := range playlists {
+		ids = append(ids, playlist.Tracks...)
+	}
+	tracks, _, err := dbConn.GetTracks(ids...)
+	if err != nil {
+		return nil, err
+	}
+	byId := map[string]*models.Track{}
+	for _, track := range tracks {
+		byId[track.Id] = track
+	}
+
+	result := []Entry{}
+	for _, playlist := range playlists {
+		entry := Entry{
+			Date:      playlist.Date,
+			StartMood: playlist.StartMood,
+			Tags:      playlist.Tags,
+		}
+		if playlist.Note != nil {
+			entry.Note = *playlist.Note
+		}
+		for _, id := range playlist.Tracks {
+			if track, ok := byId[id]; ok {
+				entry.Tracks = append(entry.Tracks, trackLine(track))
+			}
+		}
+		result = append(result, entry)
+	}
+
+	return result, nil
+}
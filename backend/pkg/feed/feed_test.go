@@ -0,0 +1,87 @@
+package feed_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/feed"
+	"github.com/stretchr/testify/assert"
+)
+
+func day(d int) time.Time {
+	return time.Date(2021, 1, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestWriteICalendar(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	entries := []feed.Entry{
+		{
+			Date:      day(1),
+			StartMood: -0.25,
+			Note:      "Rain; cold, " + strings.Repeat("é", 60),
+			Tags:      []string{"weather:rain", "work"},
+			Tracks:    []string{"A by X, Y"},
+		},
+		{Date: day(31), StartMood: 0.1},
+	}
+	var buf bytes.Buffer
+
+	// Run
+	assert.NoError(t, feed.WriteICalendar(&buf, "paul", entries, time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n")
+	for _, line := range lines {
+		assert.LessOrEqual(t, len(line), 75, line)
+		assert.True(t, utf8.ValidString(line), line)
+	}
+	unfolded := strings.ReplaceAll(buf.String(), "\r\n ", "")
+	assert.Contains(t, unfolded, "\r\nUID:2021-01-01-paul@tune-neutral\r\n")
+	assert.Contains(t, unfolded, "\r\nDTSTAMP:20220101T120000Z\r\n")
+	assert.Contains(t, unfolded, "\r\nSUMMARY:Mood -0.25\r\n")
+	assert.Contains(t, unfolded, "\r\nDESCRIPTION:Rain\\; cold\\, "+strings.Repeat("é", 60)+
+		"\\n\\nTags: weather:rain\\, work\\n\\nTracks:\\n1. A by X\\, Y\r\n")
+	assert.Contains(t, unfolded, "\r\nCATEGORIES:weather:rain,work\r\n")
+	// All day events end the next day, even across months
+	assert.Contains(t, unfolded, "\r\nDTSTART;VALUE=DATE:20210131\r\nDTEND;VALUE=DATE:20210201\r\n")
+	assert.Equal(t, "BEGIN:VCALENDAR", lines[0])
+	assert.Equal(t, "END:VCALENDAR", lines[len(lines)-1])
+}
+
+func TestWriteAtom(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	var entries []feed.Entry
+	for i := 0; i < feed.AtomEntries+5; i++ {
+		entries = append(entries, feed.Entry{Date: day(1).AddDate(0, 0, i), StartMood: 0.125})
+	}
+	entries[len(entries)-1].Note = "<b>bold</b>"
+	var buf bytes.Buffer
+
+	// Run
+	assert.NoError(t, feed.WriteAtom(&buf, "https://tune.example/v1/feeds/mood.atom", "paul", entries, time.Now()))
+
+	var parsed struct {
+		Id      string `xml:"id"`
+		Updated string `xml:"updated"`
+		Entries []struct {
+			Id      string `xml:"id"`
+			Title   string `xml:"title"`
+			Content string `xml:"content"`
+		} `xml:"entry"`
+	}
+	assert.NoError(t, xml.Unmarshal(buf.Bytes(), &parsed))
+	assert.Equal(t, "https://tune.example/v1/feeds/mood.atom", parsed.Id)
+	assert.Equal(t, "2021-02-24T00:00:00Z", parsed.Updated)
+	assert.Len(t, parsed.Entries, feed.AtomEntries)
+	assert.Equal(t, "https://tune.example/v1/feeds/mood.atom#2021-02-24", parsed.Entries[0].Id)
+	assert.Equal(t, "2021-02-24: Mood +0.12", parsed.Entries[0].Title)
+	assert.Equal(t, "<b>bold</b>", parsed.Entries[0].Content)
+	assert.Equal(t, "https://tune.example/v1/feeds/mood.atom#2021-01-06", parsed.Entries[feed.AtomEntries-1].Id)
+}
@@ -0,0 +1,74 @@
+package feed
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+)
+
+const (
+	icalDate      = "20060102"
+	icalTimestamp = "20060102T150405Z"
+	// icalLineLength is the most octets a line may have before it is folded
+	icalLineLength = 75
+)
+
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// icalLine folds a content line as RFC 5545 3.1 asks, without splitting UTF-8
+// sequences.
+func icalLine(w *bufio.Writer, line string) {
+	for len(line) > icalLineLength {
+		cut := icalLineLength
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		w.WriteString(line[:cut] + "\r\n")
+		// The leading space of a continuation counts towards its length
+		line = " " + line[cut:]
+	}
+	w.WriteString(line + "\r\n")
+}
+
+// WriteICalendar writes entries as an iCalendar feed with an all day event
+// per entry.
+func WriteICalendar(w io.Writer, userId string, entries []Entry, now time.Time) error {
+	bw := bufio.NewWriter(w)
+	for _, line := range []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Tune Neutral//Mood Journal//EN",
+		"CALSCALE:GREGORIAN",
+		"X-WR-CALNAME:Tune Neutral mood journal",
+	} {
+		icalLine(bw, line)
+	}
+
+	stamp := now.UTC().Format(icalTimestamp)
+	for _, entry := range entries {
+		icalLine(bw, "BEGIN:VEVENT")
+		icalLine(bw, "UID:"+entry.Date.Format(models.DateFormat)+"-"+icalEscaper.Replace(userId)+"@tune-neutral")
+		icalLine(bw, "DTSTAMP:"+stamp)
+		icalLine(bw, "DTSTART;VALUE=DATE:"+entry.Date.Format(icalDate))
+		icalLine(bw, "DTEND;VALUE=DATE:"+entry.Date.AddDate(0, 0, 1).Format(icalDate))
+		icalLine(bw, "SUMMARY:"+icalEscaper.Replace(entry.Title()))
+		if description := entry.Description(); description != "" {
+			icalLine(bw, "DESCRIPTION:"+icalEscaper.Replace(description))
+		}
+		if len(entry.Tags) > 0 {
+			var tags []string
+			for _, tag := range entry.Tags {
+				tags = append(tags, icalEscaper.Replace(tag))
+			}
+			icalLine(bw, "CATEGORIES:"+strings.Join(tags, ","))
+		}
+		icalLine(bw, "TRANSP:TRANSPARENT")
+		icalLine(bw, "END:VEVENT")
+	}
+
+	icalLine(bw, "END:VCALENDAR")
+	return bw.Flush()
+}
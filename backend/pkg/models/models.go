@@ -2,6 +2,7 @@ package models
 
 import (
 	"math"
+	"strings"
 	"time"
 
 	"github.com/zmb3/spotify"
@@ -21,31 +22,36 @@ const (
 	MoodHappy     Mood = 0.25
 )
 
+// MoodMin and MoodMax bound the moods a user can report.
+const (
+	MoodMin Mood = -0.5
+	MoodMax Mood = 0.5
+)
+
+// Opposite mirrors m around MoodNothing, it works for any mood not only the
+// categories.
 func (m Mood) Opposite() Mood {
-	switch m {
-	case MoodDepressed:
-		return MoodHappy
-	case MoodSad:
-		return MoodGood
-	case MoodNothing:
-		return MoodNothing
-	case MoodGood:
-		return MoodSad
-	case MoodHappy:
-		return MoodDepressed
-	}
+	return MoodNothing - m
+}
+
+var Moods = []Mood{
+	MoodDepressed, MoodSad,
+	MoodNothing,
+	MoodGood, MoodHappy,
+}
 
-	panic("Not Implemented")
+// Index returns the position of m in Moods or -1 if m is not a category.
+func (m Mood) Index() int {
+	return categoryIndex(m, Moods)
 }
 
 func ValenceMoodCategory(valence float32) Mood {
-	moods := []Mood{
-		MoodDepressed, MoodSad,
-		MoodNothing,
-		MoodGood, MoodHappy,
-	}
+	return GetCategory(valence, Moods)
+}
 
-	return GetCategory(valence, moods)
+// TrackMood is the mood category of a track with the given spotify valence.
+func TrackMood(valence float32) Mood {
+	return ValenceMoodCategory(valence - 0.5)
 }
 
 type Energy float32
@@ -58,31 +64,30 @@ const (
 	EnergyHappy     Energy = 0.25
 )
 
+// Opposite mirrors m around EnergyNothing, it works for any energy not only
+// the categories.
 func (m Energy) Opposite() Energy {
-	switch m {
-	case EnergyDepressed:
-		return EnergyHappy
-	case EnergySad:
-		return EnergyGood
-	case EnergyNothing:
-		return EnergyNothing
-	case EnergyGood:
-		return EnergySad
-	case EnergyHappy:
-		return EnergyDepressed
-	}
+	return EnergyNothing - m
+}
 
-	panic("Not Implemented")
+var Energies = []Energy{
+	EnergyDepressed, EnergySad,
+	EnergyNothing,
+	EnergyGood, EnergyHappy,
+}
+
+// Index returns the position of e in Energies or -1 if e is not a category.
+func (e Energy) Index() int {
+	return categoryIndex(e, Energies)
 }
 
 func EnergyMoodCategory(energy float32) Energy {
-	energies := []Energy{
-		EnergyDepressed, EnergySad,
-		EnergyNothing,
-		EnergyGood, EnergyHappy,
-	}
+	return GetCategory(energy, Energies)
+}
 
-	return GetCategory(energy, energies)
+// TrackEnergy is the energy category of a track with the given spotify energy.
+func TrackEnergy(energy float32) Energy {
+	return EnergyMoodCategory(energy - 0.5)
 }
 
 type Category interface {
@@ -104,6 +109,16 @@ func GetCategory[T Category](val float32, values []T) T {
 	return closestVal
 }
 
+func categoryIndex[T Category](val T, values []T) int {
+	for i, other := range values {
+		if other == val {
+			return i
+		}
+	}
+
+	return -1
+}
+
 type Track struct {
 	Id               string
 	Name             string
@@ -120,6 +135,16 @@ type MinTrack struct {
 	Energy  float32
 }
 
+// LibraryScan tracks how far through a user's saved tracks the library sync
+// has got.
+type LibraryScan struct {
+	LastOffset       int
+	CompletedScan    bool
+	LastTrackScanned *string
+}
+
+// UserTracks is a full view of a user's library. It is assembled from the
+// individual library keys and is never stored as a single record.
 type UserTracks struct {
 	UserId           string
 	LastOffset       int
@@ -136,9 +161,133 @@ type MoodPlaylist struct {
 	StartMood float32
 	EndMood   float32
 	Note      *string
+	// Tags are canonical Tag strings
+	Tags []string
+}
+
+// Tag labels a mood entry with some context, like work or sleep:bad.
+type Tag struct {
+	Key string
+	// Value is optional
+	Value string
+}
+
+// ParseTag reads key or key:value, ignoring case and surrounding space.
+func ParseTag(text string) Tag {
+	key, value := text, ""
+	if i := strings.Index(text, ":"); i >= 0 {
+		key, value = text[:i], text[i+1:]
+	}
+	return Tag{
+		Key:   strings.ToLower(strings.TrimSpace(key)),
+		Value: strings.ToLower(strings.TrimSpace(value)),
+	}
+}
+
+// String is the canonical form of t.
+func (t Tag) String() string {
+	if t.Value == "" {
+		return t.Key
+	}
+	return t.Key + ":" + t.Value
 }
 
 type SpotifyRedirect struct {
 	Token          string
 	RedirectTarget string
 }
+
+// AccessToken is a personal API token. Only the hash of its secret is stored.
+type AccessToken struct {
+	Id        string
+	UserId    string
+	Name      string
+	Scopes    []string
+	CreatedAt time.Time
+	LastUsed  time.Time
+}
+
+// ShareLink makes one mood playlist readable by anyone holding its token.
+// Only the hash of the token is stored. A zero ExpiresAt never expires.
+type ShareLink struct {
+	Id           string
+	UserId       string
+	Date         string
+	HideNote     bool
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+	Accesses     int
+	LastAccessed time.Time
+}
+
+// Group links the libraries of several users, like a household sharing a
+// speaker, so playlists can be made for all of them. Only the hash of the
+// invite code is stored.
+type Group struct {
+	Id         string
+	Name       string
+	OwnerId    string
+	CreatedAt  time.Time
+	Members    []GroupMember
+	InviteHash string
+}
+
+// GroupMember is a user in a group. Their library is only used once they have
+// consented, a zero ConsentedAt means they haven't.
+type GroupMember struct {
+	UserId      string
+	JoinedAt    time.Time
+	ConsentedAt time.Time
+}
+
+// Member returns userId's membership, nil if they aren't in the group.
+func (g *Group) Member(userId string) *GroupMember {
+	for i := range g.Members {
+		if g.Members[i].UserId == userId {
+			return &g.Members[i]
+		}
+	}
+	return nil
+}
+
+// GroupPlaylist is a playlist made from the blended libraries of a group.
+type GroupPlaylist struct {
+	GroupId string
+	// Date is YYYY-MM-DD
+	Date string
+	// Mode is how the libraries were blended
+	Mode string
+	// Moods are the moods the members reported, StartMood combines them
+	Moods     map[string]float32
+	StartMood float32
+	EndMood   float32
+	// Members are the users whose libraries were used
+	Members   []string
+	Tracks    []string
+	CreatedBy string
+	CreatedAt time.Time
+}
+
+// AuthState is an OAuth authorization request waiting for its callback. Key
+// binds it to the session that started it, Verifier is the PKCE code
+// verifier sent with the code exchange and Scopes are the scopes requested.
+type AuthState struct {
+	Key      string
+	Verifier string
+	Scopes   []string
+}
+
+// SessionUserKey is the session value holding the id of the logged in user.
+const SessionUserKey = "user"
+
+// Session is a server side login session. The cookie only carries Id.
+type Session struct {
+	Id        string
+	UserId    string
+	CreatedAt time.Time
+	LastSeen  time.Time
+	ExpiresAt time.Time
+	UserAgent string
+	Ip        string
+	Values    map[interface{}]interface{}
+}
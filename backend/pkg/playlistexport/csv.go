@@ -0,0 +1,35 @@
+package playlistexport
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+)
+
+func init() {
+	Register(csvExporter{})
+}
+
+// csvExporter writes a row a track for spreadsheets and converters that take
+// a list of titles and artists.
+type csvExporter struct{}
+
+func (csvExporter) Name() string        { return "csv" }
+func (csvExporter) ContentType() string { return "text/csv; charset=utf-8" }
+func (csvExporter) Extension() string   { return "csv" }
+
+func (csvExporter) Write(w io.Writer, playlist *Playlist) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"title", "artists", "album_art_url", "spotify_uri", "spotify_url"})
+	for _, track := range playlist.Tracks {
+		cw.Write([]string{
+			track.Title,
+			strings.Join(track.Artists, "; "),
+			track.AlbumArtUrl,
+			track.Uri(),
+			track.Url(),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
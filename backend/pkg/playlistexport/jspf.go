@@ -0,0 +1,58 @@
+package playlistexport
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+func init() {
+	Register(jspf{})
+}
+
+// jspf is XSPF written as JSON, https://xspf.org/jspf
+type jspf struct{}
+
+func (jspf) Name() string        { return "jspf" }
+func (jspf) ContentType() string { return "application/json" }
+func (jspf) Extension() string   { return "jspf" }
+
+type jspfTrack struct {
+	Location   []string `json:"location"`
+	Identifier []string `json:"identifier"`
+	Title      string   `json:"title"`
+	Creator    string   `json:"creator,omitempty"`
+	Image      string   `json:"image,omitempty"`
+}
+
+type jspfPlaylist struct {
+	Title      string      `json:"title"`
+	Annotation string      `json:"annotation,omitempty"`
+	Date       string      `json:"date"`
+	Tracks     []jspfTrack `json:"track"`
+}
+
+func (jspf) Write(w io.Writer, playlist *Playlist) error {
+	document := jspfPlaylist{
+		Title:      playlist.Title,
+		Annotation: playlist.Note,
+		Date:       playlist.Date.Format(time.RFC3339),
+		Tracks:     []jspfTrack{},
+	}
+	for _, track := range playlist.Tracks {
+		document.Tracks = append(document.Tracks, jspfTrack{
+			Location:   []string{track.Url()},
+			Identifier: []string{track.Uri()},
+			Title:      track.Title,
+			Creator:    track.Creator(),
+			Image:      track.AlbumArtUrl,
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(struct {
+		Playlist jspfPlaylist `json:"playlist"`
+	}{document})
+}
@@ -0,0 +1,42 @@
+package playlistexport
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+func init() {
+	Register(m3u8{})
+}
+
+// m3u8 is an extended M3U playlist in UTF-8. Entries point at the Spotify web
+// player as there are no local files to point at.
+type m3u8 struct{}
+
+func (m3u8) Name() string        { return "m3u8" }
+func (m3u8) ContentType() string { return "audio/x-mpegurl; charset=utf-8" }
+func (m3u8) Extension() string   { return "m3u8" }
+
+// m3uLine keeps a value on its own line, M3U has no escaping.
+func m3uLine(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
+
+func (m3u8) Write(w io.Writer, playlist *Playlist) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "#EXTM3U\n#PLAYLIST:%s\n", m3uLine(playlist.Title))
+	for _, track := range playlist.Tracks {
+		title := track.Title
+		if creator := track.Creator(); creator != "" {
+			title = creator + " - " + title
+		}
+		fmt.Fprintf(bw, "#EXTINF:-1,%s\n", m3uLine(title))
+		if track.AlbumArtUrl != "" {
+			fmt.Fprintf(bw, "#EXTIMG:%s\n", m3uLine(track.AlbumArtUrl))
+		}
+		fmt.Fprintf(bw, "%s\n", track.Url())
+	}
+	return bw.Flush()
+}
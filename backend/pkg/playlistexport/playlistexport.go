@@ -0,0 +1,106 @@
+package playlistexport
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+)
+
+// Exporter renders a playlist in one file format. Formats register themselves
+// with Register so adding one only needs a new file.
+type Exporter interface {
+	// Name is the value of the format query parameter
+	Name() string
+	ContentType() string
+	Extension() string
+	Write(w io.Writer, playlist *Playlist) error
+}
+
+var exporters = map[string]Exporter{}
+
+// Register makes e available under e.Name(). It panics if the name is taken.
+func Register(e Exporter) {
+	if _, ok := exporters[e.Name()]; ok {
+		panic(fmt.Sprintf("playlistexport: %s registered twice", e.Name()))
+	}
+	exporters[e.Name()] = e
+}
+
+// Get returns the exporter registered as name.
+func Get(name string) (Exporter, bool) {
+	e, ok := exporters[name]
+	return e, ok
+}
+
+// Formats lists the names of every registered exporter.
+func Formats() []string {
+	var result []string
+	for name := range exporters {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+type Track struct {
+	Id          string
+	Title       string
+	Artists     []string
+	AlbumArtUrl string
+}
+
+// Uri opens the track in the Spotify app.
+func (t Track) Uri() string {
+	return "spotify:track:" + t.Id
+}
+
+// Url opens the track in a browser.
+func (t Track) Url() string {
+	return "https://open.spotify.com/track/" + t.Id
+}
+
+// Creator is the track's artists as one string.
+func (t Track) Creator() string {
+	return strings.Join(t.Artists, ", ")
+}
+
+// Playlist is a mood playlist with its tracks hydrated. Tracks that are no
+// longer stored are left out.
+type Playlist struct {
+	Title     string
+	Date      time.Time
+	StartMood float32
+	Note      string
+	Tracks    []Track
+}
+
+// New builds the playlist to export from a stored playlist and its stored
+// tracks.
+func New(playlist *models.MoodPlaylist, tracks []*models.Track) *Playlist {
+	result := &Playlist{
+		Title:     "Tune Neutral " + playlist.Date.Format(models.DateFormat),
+		Date:      playlist.Date,
+		StartMood: playlist.StartMood,
+	}
+	if playlist.Note != nil {
+		result.Note = *playlist.Note
+	}
+
+	for _, track := range tracks {
+		exported := Track{
+			Id:          track.Id,
+			Title:       track.Name,
+			AlbumArtUrl: track.AlbumArtUrl,
+		}
+		for _, artist := range track.Artists {
+			exported.Artists = append(exported.Artists, artist.Name)
+		}
+		result.Tracks = append(result.Tracks, exported)
+	}
+
+	return result
+}
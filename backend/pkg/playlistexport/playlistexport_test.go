@@ -0,0 +1,61 @@
+package playlistexport_test
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+	"github.com/sardap/TuneNeutral/backend/pkg/playlistexport"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestExporters(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	note := "Rain & <thunder>,\n\"cold\""
+	playlist := playlistexport.New(&models.MoodPlaylist{
+		Date:      time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		StartMood: -0.25,
+		Note:      &note,
+		Tracks:    []string{"a", "gone", "b"},
+	}, []*models.Track{
+		{
+			Id:          "a",
+			Name:        "Song, the first",
+			AlbumArtUrl: "https://i.scdn.co/image/a",
+			Artists:     []spotify.SimpleArtist{{ID: "x", Name: "Artist X"}, {ID: "y", Name: "Artist Y"}},
+		},
+		{Id: "b", Name: "B"},
+	})
+
+	assert.Equal(t, []string{"csv", "jspf", "m3u8", "xspf"}, playlistexport.Formats())
+
+	for _, name := range playlistexport.Formats() {
+		exporter, ok := playlistexport.Get(name)
+		assert.True(t, ok)
+		golden := filepath.Join("testdata", "playlist."+exporter.Extension())
+
+		// Run
+		var buf bytes.Buffer
+		assert.NoError(t, exporter.Write(&buf, playlist), name)
+
+		if *update {
+			assert.NoError(t, os.WriteFile(golden, buf.Bytes(), 0644))
+			continue
+		}
+		expected, err := os.ReadFile(golden)
+		assert.NoError(t, err, name)
+		assert.Equal(t, string(expected), buf.String(), name)
+	}
+
+	_, ok := playlistexport.Get("mp3")
+	assert.False(t, ok)
+}
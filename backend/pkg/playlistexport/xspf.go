@@ -0,0 +1,65 @@
+package playlistexport
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+)
+
+func init() {
+	Register(xspf{})
+}
+
+// xspf is the XML shareable playlist format, https://xspf.org/spec
+type xspf struct{}
+
+func (xspf) Name() string        { return "xspf" }
+func (xspf) ContentType() string { return "application/xspf+xml" }
+func (xspf) Extension() string   { return "xspf" }
+
+type xspfTrack struct {
+	Location   string `xml:"location"`
+	Identifier string `xml:"identifier"`
+	Title      string `xml:"title"`
+	Creator    string `xml:"creator,omitempty"`
+	Image      string `xml:"image,omitempty"`
+}
+
+type xspfPlaylist struct {
+	XMLName    xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version    int         `xml:"version,attr"`
+	Title      string      `xml:"title"`
+	Annotation string      `xml:"annotation,omitempty"`
+	Date       string      `xml:"date"`
+	Tracks     []xspfTrack `xml:"trackList>track"`
+}
+
+func (xspf) Write(w io.Writer, playlist *Playlist) error {
+	document := xspfPlaylist{
+		Version:    1,
+		Title:      playlist.Title,
+		Annotation: playlist.Note,
+		Date:       playlist.Date.Format(time.RFC3339),
+		Tracks:     []xspfTrack{},
+	}
+	for _, track := range playlist.Tracks {
+		document.Tracks = append(document.Tracks, xspfTrack{
+			Location:   track.Url(),
+			Identifier: track.Uri(),
+			Title:      track.Title,
+			Creator:    track.Creator(),
+			Image:      track.AlbumArtUrl,
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(document); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,88 @@
+package router
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sardap/TuneNeutral/backend/pkg/api"
+	"github.com/sardap/TuneNeutral/backend/pkg/archive"
+)
+
+func exportEndpoint(c *gin.Context) {
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	export, err := archive.Collect(getDatabase(c), userId)
+	if err != nil {
+		processApiError(c, api.Internal(err))
+		return
+	}
+
+	now := time.Now().UTC()
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(
+		"attachment; filename=tune-neutral-export-%s.zip", now.Format("20060102T150405Z"),
+	))
+
+	if _, err := export.Write(c.Writer, userId, now); err != nil {
+		// Headers may already be on the wire so all we can do is log
+		log.Printf("Export failed: %v", err)
+		c.Abort()
+	}
+}
+
+// maxImportSize bounds an uploaded export archive, which is held in memory
+// while it is read.
+const maxImportSize = 64 << 20
+
+func importEndpoint(c *gin.Context) {
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	mode := archive.Mode(c.DefaultQuery("mode", string(archive.ModeMerge)))
+	if mode != archive.ModeMerge && mode != archive.ModeReplace {
+		processApiError(c, api.Invalid(api.FieldError{Field: "mode", Message: "must be merge or replace"}))
+		return
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, maxImportSize+1))
+	if err != nil {
+		processApiError(c, api.NewError(api.CodeBadRequest, "unable to read body").WithCause(err))
+		return
+	} else if len(data) > maxImportSize {
+		processApiError(c, api.NewError(api.CodeBadRequest, fmt.Sprintf("archive must be at most %d bytes", maxImportSize)))
+		return
+	}
+
+	report, err := archive.Import(getDatabase(c), userId, bytes.NewReader(data), int64(len(data)), mode)
+	if err != nil {
+		switch {
+		case errors.Is(err, archive.ErrOtherUser):
+			processApiError(c, api.NewError(api.CodeForbidden, err.Error()))
+		case errors.Is(err, archive.ErrInvalidArchive),
+			errors.Is(err, archive.ErrUnsupportedVersion),
+			errors.Is(err, archive.ErrChecksumMismatch):
+			processApiError(c, api.NewError(api.CodeBadRequest, err.Error()))
+		default:
+			processApiError(c, api.Internal(err))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": report,
+	})
+}
@@ -0,0 +1,189 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/api"
+	"github.com/sardap/TuneNeutral/backend/pkg/client"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	router := newTestRouter(t, dbConn)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	b := newBrowser(router)
+	b.get(startLogin(t, b, string(api.FeatureGenerate)).RequestURI())
+
+	assert.NoError(t, dbConn.PutTrack(&models.Track{Id: "a", Name: "A", Valence: 0.2, AlbumId: "album"}))
+	note := "rainy"
+	assert.NoError(t, dbConn.SetMoodPlaylist(userId, &models.MoodPlaylist{
+		Date:      time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		StartMood: 0.25,
+		Note:      &note,
+		Tracks:    []string{"a", "gone"},
+	}))
+
+	w := createAccessToken(t, b, "client", api.TokenScopeRead, api.TokenScopeWrite)
+	var created struct {
+		Result createAccessTokenResponse `json:"result"`
+	}
+	json.NewDecoder(w.Body).Decode(&created)
+
+	ctx := context.Background()
+	c := client.New(server.URL+"/", created.Result.Token)
+
+	// Run
+	page, err := c.MoodPlaylists(ctx, client.PlaylistQuery{})
+	assert.NoError(t, err)
+	assert.Len(t, page.Playlists, 1)
+	assert.True(t, page.Playlists[0].Date.Equal(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, &note, page.Playlists[0].Note)
+	assert.Nil(t, page.NextCursor)
+
+	_, err = c.MoodPlaylists(ctx, client.PlaylistQuery{From: "2021-02-01", To: "2021-01-01"})
+	assert.True(t, errors.Is(err, api.Invalid()))
+
+	playlist, err := c.MoodPlaylist(ctx, "2021-01-01")
+	assert.NoError(t, err)
+	assert.Len(t, playlist.Tracks, 1)
+	assert.Equal(t, "A", playlist.Tracks[0].Name)
+	assert.Equal(t, []string{"gone"}, playlist.MissingTracks)
+
+	var m3u bytes.Buffer
+	assert.NoError(t, c.ExportMoodPlaylist(ctx, "2021-01-01", "m3u8", &m3u))
+	assert.Contains(t, m3u.String(), "https://open.spotify.com/track/a\n")
+	err = c.ExportMoodPlaylist(ctx, "2021-01-01", "mp3", &m3u)
+	assert.True(t, errors.Is(err, api.Invalid()))
+
+	_, err = c.MoodPlaylist(ctx, "2021-01-02")
+	assert.True(t, errors.Is(err, api.ErrNotFound))
+	var clientErr *client.Error
+	assert.True(t, errors.As(err, &clientErr))
+	assert.Equal(t, http.StatusNotFound, clientErr.StatusCode)
+
+	err = c.GenerateMoodPlaylist(ctx, client.GenerateMoodPlaylistRequest{Mood: 2})
+	assert.True(t, errors.As(err, &clientErr))
+	assert.Equal(t, api.CodeInvalid, clientErr.Code)
+	assert.Equal(t, "mood", clientErr.Details[0].Field)
+
+	err = c.UpdateSpotifyPlaylist(ctx, "2021-01-01")
+	assert.True(t, errors.Is(err, api.ErrForbidden))
+
+	scopes, err := c.Scopes(ctx)
+	assert.NoError(t, err)
+	assert.Contains(t, scopes.Features, api.FeatureGenerate)
+
+	updated, err := c.UpdateNote(ctx, "2021-01-01", "rainy walk")
+	assert.NoError(t, err)
+	assert.Equal(t, "rainy walk", *updated.Note)
+	results, err := c.SearchNotes(ctx, `"rainy walk"`, "", "", 0)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+
+	stats, err := c.MoodStats(ctx, "2021-01-01", "2021-01-07", 0, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stats.LoggedDays)
+	assert.Equal(t, api.DefaultStatsWindow, stats.Window)
+
+	updated, err = c.SetTags(ctx, "2021-01-01", []string{"Weather:Rain"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"weather:rain"}, updated.Tags)
+	page, err = c.MoodPlaylists(ctx, client.PlaylistQuery{Tag: "weather"})
+	assert.NoError(t, err)
+	assert.Len(t, page.Playlists, 1)
+	stats, err = c.MoodStats(ctx, "2021-01-01", "2021-01-07", 0, "sleep")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, stats.LoggedDays)
+	tags, err := c.Tags(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "weather:rain", tags[0].Tag)
+	changed, err := c.RenameTag(ctx, "weather:rain", "weather:storm")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, changed)
+	changed, err = c.DeleteTag(ctx, "weather:storm")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, changed)
+	_, err = c.DeleteTag(ctx, "weather:storm")
+	assert.True(t, errors.Is(err, api.ErrNotFound))
+
+	share, err := c.CreateShareLink(ctx, "2021-01-01", true, 7)
+	assert.NoError(t, err)
+	assert.NotNil(t, share.ExpiresAt)
+	shared, err := client.New(server.URL, "").SharedPlaylist(ctx, share.Token)
+	assert.NoError(t, err)
+	assert.Nil(t, shared.Note)
+	assert.Len(t, shared.Tracks, 1)
+	shares, err := c.ShareLinks(ctx, "2021-01-01")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, shares[0].Accesses)
+	assert.NoError(t, c.RevokeShareLink(ctx, share.Id))
+	_, err = c.SharedPlaylist(ctx, share.Token)
+	assert.True(t, errors.Is(err, api.ErrNotFound))
+
+	group, err := c.CreateGroup(ctx, "Household")
+	assert.NoError(t, err)
+	assert.Equal(t, userId, group.OwnerId)
+	code, err := c.CreateGroupInvite(ctx, group.Id)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, code)
+	_, err = c.JoinGroup(ctx, code)
+	assert.True(t, errors.Is(err, api.ErrForbidden))
+	_, err = c.SetGroupConsent(ctx, group.Id, true)
+	assert.True(t, errors.Is(err, api.ErrForbidden))
+	_, err = c.GenerateGroupPlaylist(ctx, group.Id, client.GenerateGroupPlaylistRequest{Moods: map[string]float32{userId: 0.1}})
+	assert.True(t, errors.Is(err, api.ErrNoConsent))
+	groups, err := c.Groups(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, groups, 1)
+	assert.False(t, groups[0].Members[0].Consented)
+	assert.NoError(t, c.RemoveGroupMember(ctx, group.Id, userId))
+	_, err = c.Group(ctx, group.Id)
+	assert.True(t, errors.Is(err, api.ErrNotFound))
+
+	id, err := c.SpotifyPlaylist(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, id)
+
+	_, err = c.AccessTokens(ctx)
+	assert.True(t, errors.Is(err, api.ErrForbidden))
+
+	_, err = client.New(server.URL, "tn_nope").Scopes(ctx)
+	assert.True(t, errors.Is(err, api.ErrUnauthorized))
+
+	var export bytes.Buffer
+	assert.NoError(t, c.Export(ctx, &export))
+	assert.Equal(t, "PK", export.String()[:2])
+	report, err := c.Import(ctx, bytes.NewReader(export.Bytes()), client.ImportMerge)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Unchanged)
+
+	// A token cannot remove the user's data
+	assert.True(t, errors.Is(c.RemoveAllUserData(ctx), api.ErrForbidden))
+	_, err = c.Scopes(ctx)
+	assert.NoError(t, err)
+
+	admin := client.New(server.URL, adminToken)
+	var backup bytes.Buffer
+	assert.NoError(t, admin.Backup(ctx, &backup))
+	manifest, err := admin.Restore(ctx, &backup)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, manifest.Sha256)
+
+	// Backups hold no Spotify tokens, the user has to log in again
+	_, err = c.Scopes(ctx)
+	assert.True(t, errors.Is(err, api.ErrUnauthorized))
+}
@@ -0,0 +1,52 @@
+package router
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sardap/TuneNeutral/backend/pkg/api"
+	"github.com/sardap/TuneNeutral/backend/pkg/feed"
+)
+
+func icalFeedEndpoint(c *gin.Context) {
+	accessToken, _ := getAccessToken(c)
+
+	entries, err := feed.Collect(getDatabase(c), accessToken.UserId)
+	if err != nil {
+		processApiError(c, api.Internal(err))
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := feed.WriteICalendar(&buf, accessToken.UserId, entries, time.Now()); err != nil {
+		processApiError(c, api.Internal(err))
+		return
+	}
+
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", buf.Bytes())
+}
+
+// atomFeedEndpoint serves the Atom feed, baseUrl is where the server is
+// reached and identifies the feed.
+func atomFeedEndpoint(baseUrl string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		accessToken, _ := getAccessToken(c)
+
+		entries, err := feed.Collect(getDatabase(c), accessToken.UserId)
+		if err != nil {
+			processApiError(c, api.Internal(err))
+			return
+		}
+
+		var buf bytes.Buffer
+		err = feed.WriteAtom(&buf, baseUrl+c.Request.URL.Path, accessToken.UserId, entries, time.Now())
+		if err != nil {
+			processApiError(c, api.Internal(err))
+			return
+		}
+
+		c.Data(http.StatusOK, "application/atom+xml; charset=utf-8", buf.Bytes())
+	}
+}
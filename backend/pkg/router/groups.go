@@ -0,0 +1,307 @@
+package router
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sardap/TuneNeutral/backend/pkg/api"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+)
+
+type basicGroupMember struct {
+	UserId      string  `json:"user_id"`
+	JoinedAt    string  `json:"joined_at"`
+	Consented   bool    `json:"consented"`
+	ConsentedAt *string `json:"consented_at"`
+}
+
+type basicGroup struct {
+	Id        string             `json:"id"`
+	Name      string             `json:"name"`
+	OwnerId   string             `json:"owner_id"`
+	CreatedAt string             `json:"created_at"`
+	Members   []basicGroupMember `json:"members"`
+}
+
+func toBasicGroup(group *models.Group) basicGroup {
+	result := basicGroup{
+		Id:        group.Id,
+		Name:      group.Name,
+		OwnerId:   group.OwnerId,
+		CreatedAt: group.CreatedAt.Format(time.RFC3339),
+		Members:   []basicGroupMember{},
+	}
+	for _, member := range group.Members {
+		basic := basicGroupMember{
+			UserId:    member.UserId,
+			JoinedAt:  member.JoinedAt.Format(time.RFC3339),
+			Consented: !member.ConsentedAt.IsZero(),
+		}
+		if basic.Consented {
+			consentedAt := member.ConsentedAt.Format(time.RFC3339)
+			basic.ConsentedAt = &consentedAt
+		}
+		result.Members = append(result.Members, basic)
+	}
+	return result
+}
+
+type getGroupsResponse struct {
+	Groups []basicGroup `json:"groups"`
+}
+
+func getGroupsEndpoint(c *gin.Context) {
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	groups, err := api.ListGroups(getDatabase(c), userId)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	response := getGroupsResponse{Groups: []basicGroup{}}
+	for _, group := range groups {
+		response.Groups = append(response.Groups, toBasicGroup(group))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": response,
+	})
+}
+
+type createGroupRequest struct {
+	Name string `json:"name"`
+}
+
+func createGroupEndpoint(c *gin.Context) {
+	var request createGroupRequest
+	if err := decodeJSONBody(c, &request); err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	group, err := api.CreateGroup(getDatabase(c), userId, request.Name)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": toBasicGroup(group),
+	})
+}
+
+func getGroupEndpoint(c *gin.Context) {
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	group, err := api.GetGroup(getDatabase(c), userId, c.Param("group_id"))
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": toBasicGroup(group),
+	})
+}
+
+type groupInviteResponse struct {
+	// Code is the secret, it is only ever shown here
+	Code string `json:"code"`
+}
+
+func createGroupInviteEndpoint(c *gin.Context) {
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	code, err := api.CreateGroupInvite(getDatabase(c), userId, c.Param("group_id"))
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": groupInviteResponse{Code: code},
+	})
+}
+
+type joinGroupRequest struct {
+	Code string `json:"code"`
+}
+
+func joinGroupEndpoint(c *gin.Context) {
+	var request joinGroupRequest
+	if err := decodeJSONBody(c, &request); err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	group, err := api.JoinGroup(getDatabase(c), userId, request.Code)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": toBasicGroup(group),
+	})
+}
+
+type groupConsentRequest struct {
+	Consent *bool `json:"consent"`
+}
+
+func setGroupConsentEndpoint(c *gin.Context) {
+	var request groupConsentRequest
+	if err := decodeJSONBody(c, &request); err != nil {
+		processApiError(c, err)
+		return
+	}
+	if request.Consent == nil {
+		processApiError(c, api.Invalid(api.FieldError{Field: "consent", Message: "is required"}))
+		return
+	}
+
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	group, err := api.SetGroupConsent(getDatabase(c), userId, c.Param("group_id"), *request.Consent)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": toBasicGroup(group),
+	})
+}
+
+func removeGroupMemberEndpoint(c *gin.Context) {
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	err = api.RemoveGroupMember(getDatabase(c), userId, c.Param("group_id"), c.Param("user_id"))
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": "success",
+	})
+}
+
+type groupPlaylistResponse struct {
+	Date          string             `json:"date"`
+	Mode          string             `json:"mode"`
+	Moods         map[string]float32 `json:"moods"`
+	StartMood     float32            `json:"start_mood"`
+	EndMood       float32            `json:"end_mood"`
+	Members       []string           `json:"members"`
+	Tracks        []basicTrack       `json:"tracks"`
+	MissingTracks []string           `json:"missing_tracks"`
+	CreatedBy     string             `json:"created_by"`
+}
+
+func writeGroupPlaylist(c *gin.Context, playlist *models.GroupPlaylist) {
+	tracks, missing, err := hydrateTracks(getDatabase(c), playlist.Tracks)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": groupPlaylistResponse{
+			Date:          playlist.Date,
+			Mode:          playlist.Mode,
+			Moods:         playlist.Moods,
+			StartMood:     playlist.StartMood,
+			EndMood:       playlist.EndMood,
+			Members:       playlist.Members,
+			Tracks:        tracks,
+			MissingTracks: missing,
+			CreatedBy:     playlist.CreatedBy,
+		},
+	})
+}
+
+type generateGroupPlaylistRequest struct {
+	Mode string `json:"mode"`
+	// Moods are keyed by member user id
+	Moods map[string]float32 `json:"moods"`
+	Date  string             `json:"date"`
+}
+
+func generateGroupPlaylistEndpoint(c *gin.Context) {
+	var request generateGroupPlaylistRequest
+	if err := decodeJSONBody(c, &request); err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	mode, date, err := api.ValidateGroupPlaylist(request.Mode, request.Moods, request.Date, time.Now())
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	userId, client, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	playlist, err := api.GenerateGroupMoodPlaylist(getDatabase(c), userId, client, c.Param("group_id"), mode, request.Moods, date)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	writeGroupPlaylist(c, playlist)
+}
+
+func getGroupPlaylistEndpoint(c *gin.Context) {
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	playlist, err := api.GetGroupPlaylist(getDatabase(c), userId, c.Param("group_id"), c.Param("date"))
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	writeGroupPlaylist(c, playlist)
+}
@@ -0,0 +1,47 @@
+package router
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// oauthContext is the request context carrying the configured Spotify http
+// client for the oauth2 package.
+func oauthContext(c *gin.Context) context.Context {
+	ctx := c.Request.Context()
+	if client, ok := c.Get(spotifyHTTPClientKey); ok && client.(*http.Client) != nil {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, client)
+	}
+	return ctx
+}
+
+// slowDown delays the end of every request by delay, making guessing whatever
+// the route checks expensive.
+func slowDown(delay time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer time.Sleep(delay)
+		c.Next()
+	}
+}
+
+// newCodeVerifier creates a PKCE code verifier, RFC 7636 section 4.1.
+func newCodeVerifier() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// codeChallenge is the S256 challenge for verifier, RFC 7636 section 4.2.
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
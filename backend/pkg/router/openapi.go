@@ -0,0 +1,18 @@
+package router
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAPISpec describes every route. openapi_test.go checks it against the
+// router so keep them in step.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+func openAPIEndpoint(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", openAPISpec)
+}
@@ -0,0 +1,464 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/api"
+	"github.com/sardap/TuneNeutral/backend/pkg/archive"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify"
+)
+
+type openAPIDocument struct {
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components struct {
+		Responses map[string]openAPIResponse `json:"responses"`
+		Schemas   map[string]interface{}     `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	RequestBody *openAPIRequestBody        `json:"requestBody"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIRequestBody struct {
+	Required bool `json:"required"`
+	Content  map[string]struct {
+		Schema interface{} `json:"schema"`
+	} `json:"content"`
+}
+
+type openAPIResponse struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema interface{} `json:"schema"`
+	} `json:"content"`
+}
+
+func loadOpenAPI(t *testing.T) *openAPIDocument {
+	var result openAPIDocument
+	if err := json.Unmarshal(openAPISpec, &result); err != nil {
+		t.Fatalf("openapi.json is not valid: %v", err)
+	}
+	return &result
+}
+
+// response finds what the document says operation returns with status.
+func (d *openAPIDocument) response(path, method string, status int) (openAPIResponse, bool) {
+	operation, ok := d.Paths[path][strings.ToLower(method)]
+	if !ok {
+		return openAPIResponse{}, false
+	}
+
+	response, ok := operation.Responses[fmt.Sprint(status)]
+	if ok && response.Ref != "" {
+		response, ok = d.Components.Responses[strings.TrimPrefix(response.Ref, "#/components/responses/")]
+	}
+	return response, ok
+}
+
+// validateRequest checks body against the JSON request body the document
+// says operation takes. Other content types are uploads whose format isn't
+// described by a schema.
+func (d *openAPIDocument) validateRequest(path, method, body string) []string {
+	operation, ok := d.Paths[path][strings.ToLower(method)]
+	if !ok || operation.RequestBody == nil {
+		return []string{"request body is not documented"}
+	}
+
+	content, ok := operation.RequestBody.Content["application/json"]
+	if !ok {
+		return nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(body), &value); err != nil {
+		return []string{fmt.Sprintf("request body is not JSON: %v", err)}
+	}
+	return d.validate(content.Schema, value, "request")
+}
+
+// validate checks value against the subset of JSON schema the document uses.
+// Objects are closed unless they set additionalProperties so undocumented
+// fields are caught too.
+func (d *openAPIDocument) validate(schema interface{}, value interface{}, at string) []string {
+	s, _ := schema.(map[string]interface{})
+	if ref, ok := s["$ref"].(string); ok {
+		return d.validate(d.Components.Schemas[strings.TrimPrefix(ref, "#/components/schemas/")], value, at)
+	}
+
+	if value == nil {
+		if nullable, _ := s["nullable"].(bool); nullable {
+			return nil
+		}
+		return []string{fmt.Sprintf("%s is null", at)}
+	}
+
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			found = found || allowed == value
+		}
+		if !found {
+			return []string{fmt.Sprintf("%s is %v which is not in %v", at, value, enum)}
+		}
+	}
+
+	var problems []string
+	switch s["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s is not an object", at)}
+		}
+
+		required, _ := s["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				problems = append(problems, fmt.Sprintf("%s.%s is missing", at, name))
+			}
+		}
+
+		properties, _ := s["properties"].(map[string]interface{})
+		for name, field := range object {
+			if property, ok := properties[name]; ok {
+				problems = append(problems, d.validate(property, field, at+"."+name)...)
+				continue
+			}
+
+			switch additional := s["additionalProperties"].(type) {
+			case nil:
+				problems = append(problems, fmt.Sprintf("%s.%s is not documented", at, name))
+			case map[string]interface{}:
+				problems = append(problems, d.validate(additional, field, at+"."+name)...)
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s is not an array", at)}
+		}
+		for i, item := range array {
+			problems = append(problems, d.validate(s["items"], item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s is not a string", at)}
+		}
+		switch s["format"] {
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				problems = append(problems, fmt.Sprintf("%s is not a date-time: %v", at, err))
+			}
+		case "date":
+			if _, err := time.Parse(models.DateFormat, str); err != nil {
+				problems = append(problems, fmt.Sprintf("%s is not a date: %v", at, err))
+			}
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return []string{fmt.Sprintf("%s is not a number", at)}
+		}
+	case "integer":
+		if number, ok := value.(float64); !ok || number != math.Trunc(number) {
+			return []string{fmt.Sprintf("%s is not an integer", at)}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{fmt.Sprintf("%s is not a boolean", at)}
+		}
+	}
+
+	return problems
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	doc := loadOpenAPI(t)
+
+	// Run
+	served := map[string]bool{}
+	for _, route := range newTestRouter(t, dbConn).Routes() {
+		parts := strings.Split(route.Path, "/")
+		for i, part := range parts {
+			if strings.HasPrefix(part, ":") {
+				parts[i] = "{" + part[1:] + "}"
+			}
+		}
+		served[fmt.Sprintf("%s %s", route.Method, strings.Join(parts, "/"))] = true
+	}
+
+	documented := map[string]bool{}
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			documented[fmt.Sprintf("%s %s", strings.ToUpper(method), path)] = true
+		}
+	}
+
+	for route := range served {
+		assert.True(t, documented[route], "%s is not documented", route)
+	}
+	for route := range documented {
+		assert.True(t, served[route], "%s is documented but not served", route)
+	}
+}
+
+func TestOpenAPIContract(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	router := newTestRouter(t, dbConn)
+	doc := loadOpenAPI(t)
+	b := newBrowser(router)
+	b.get(startLogin(t, b, string(api.FeatureGenerate)).RequestURI())
+
+	for _, track := range []*models.Track{
+		{Id: "a", Name: "A", Valence: 0.2, AlbumId: "album", Artists: []spotify.SimpleArtist{{Name: "Artist", ID: "artist"}}},
+		{Id: "b", Name: "B", Valence: 0.8, AlbumId: "album"},
+	} {
+		assert.NoError(t, dbConn.PutTrack(track))
+	}
+	assert.NoError(t, dbConn.SaveLibraryPage(userId, &models.LibraryScan{}, map[string]models.MinTrack{
+		"a": {Valence: 0.2},
+		"b": {Valence: 0.8},
+	}))
+	assert.NoError(t, dbConn.RemoveLibraryTrack(userId, "b"))
+	note := "rainy"
+	assert.NoError(t, dbConn.SetMoodPlaylist(userId, &models.MoodPlaylist{
+		Date:      time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		StartMood: 0.25,
+		Note:      &note,
+		Tracks:    []string{"a", "gone"},
+	}))
+	assert.NoError(t, dbConn.SetMoodPlaylist(userId, &models.MoodPlaylist{
+		Date: time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC),
+	}))
+
+	export := &bytes.Buffer{}
+	_, err := archive.Write(dbConn, userId, export, time.Now())
+	assert.NoError(t, err)
+
+	w := createAccessToken(t, b, "contract", api.TokenScopeRead, api.TokenScopeWrite)
+	var created struct {
+		Result createAccessTokenResponse `json:"result"`
+	}
+	json.NewDecoder(w.Body).Decode(&created)
+	w = createAccessToken(t, b, "calendar", api.TokenScopeFeed)
+	var feedToken struct {
+		Result createAccessTokenResponse `json:"result"`
+	}
+	json.NewDecoder(w.Body).Decode(&feedToken)
+	shareToken, share, err := api.CreateShareLink(dbConn, userId, "2021-01-01", true, nil)
+	assert.NoError(t, err)
+
+	const member = "ringo"
+	assert.NoError(t, dbConn.SaveLibraryPage(member, &models.LibraryScan{}, map[string]models.MinTrack{"a": {Valence: 0.2}}))
+	assert.NoError(t, dbConn.SetUserFetchLock(userId))
+	household, err := api.CreateGroup(dbConn, member, "Household")
+	assert.NoError(t, err)
+	_, err = api.SetGroupConsent(dbConn, member, household.Id, true)
+	assert.NoError(t, err)
+	inviteCode, err := api.CreateGroupInvite(dbConn, member, household.Id)
+	assert.NoError(t, err)
+	ownGroup, err := api.CreateGroup(dbConn, userId, "Own")
+	assert.NoError(t, err)
+	groupPath := "/v1/api/groups/" + household.Id
+
+	type scenario struct {
+		method         string
+		target         string
+		path           string
+		body           string
+		authorization  string
+		loggedOut      bool
+		expectedStatus int
+	}
+	scenarios := []scenario{
+		{method: http.MethodGet, target: "/v1/api/openapi.json", path: "/v1/api/openapi.json", loggedOut: true, expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/authenticated", path: "/v1/api/authenticated", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/authenticated", path: "/v1/api/authenticated", loggedOut: true, expectedStatus: http.StatusUnauthorized},
+		{method: http.MethodGet, target: "/auth", path: "/auth", loggedOut: true, expectedStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/callback?state=nope&code=nope", path: "/callback", loggedOut: true, expectedStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/v1/api/mood_playlists", path: "/v1/api/mood_playlists", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/mood_playlists", path: "/v1/api/mood_playlists", loggedOut: true, expectedStatus: http.StatusUnauthorized},
+		{method: http.MethodGet, target: "/v1/api/mood_playlists?order=desc&limit=1", path: "/v1/api/mood_playlists", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/mood_playlists?from=2021-01-02&cursor=2021-01-01", path: "/v1/api/mood_playlists", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/mood_playlists?order=sideways", path: "/v1/api/mood_playlists", expectedStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/v1/api/mood_playlist/2021-01-01", path: "/v1/api/mood_playlist/{date}", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/mood_playlist/2021-01-02", path: "/v1/api/mood_playlist/{date}", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/mood_playlist/2021-01-03", path: "/v1/api/mood_playlist/{date}", expectedStatus: http.StatusNotFound},
+		{method: http.MethodGet, target: "/v1/api/mood_playlist/2021-01-01/export?format=m3u8", path: "/v1/api/mood_playlist/{date}/export", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/mood_playlist/2021-01-01/export?format=xspf", path: "/v1/api/mood_playlist/{date}/export", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/mood_playlist/2021-01-01/export?format=jspf", path: "/v1/api/mood_playlist/{date}/export", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/mood_playlist/2021-01-01/export?format=csv", path: "/v1/api/mood_playlist/{date}/export", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/mood_playlist/2021-01-01/export?format=mp3", path: "/v1/api/mood_playlist/{date}/export", expectedStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/v1/api/mood_playlist/2021-01-03/export?format=csv", path: "/v1/api/mood_playlist/{date}/export", expectedStatus: http.StatusNotFound},
+		{method: http.MethodPost, target: "/v1/api/mood_playlist/2021-01-01/shares", path: "/v1/api/mood_playlist/{date}/shares", body: `{"hide_note": true, "expires_in_days": 7}`, expectedStatus: http.StatusOK},
+		{method: http.MethodPost, target: "/v1/api/mood_playlist/2021-01-01/shares", path: "/v1/api/mood_playlist/{date}/shares", body: `{"expires_in_days": 0}`, expectedStatus: http.StatusBadRequest},
+		{method: http.MethodPost, target: "/v1/api/mood_playlist/2021-01-03/shares", path: "/v1/api/mood_playlist/{date}/shares", body: `{}`, expectedStatus: http.StatusNotFound},
+		{method: http.MethodGet, target: "/v1/api/shares", path: "/v1/api/shares", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/shares?date=2021-01-01", path: "/v1/api/shares", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/shared/" + shareToken, path: "/v1/api/shared/{token}", loggedOut: true, expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/shared/tns_nope", path: "/v1/api/shared/{token}", loggedOut: true, expectedStatus: http.StatusNotFound},
+		{method: http.MethodDelete, target: "/v1/api/shares/nope", path: "/v1/api/shares/{share_id}", expectedStatus: http.StatusNotFound},
+		{method: http.MethodDelete, target: "/v1/api/shares/" + share.Id, path: "/v1/api/shares/{share_id}", expectedStatus: http.StatusOK},
+		{method: http.MethodPost, target: "/v1/api/groups", path: "/v1/api/groups", body: `{"name": "Flat"}`, expectedStatus: http.StatusOK},
+		{method: http.MethodPost, target: "/v1/api/groups", path: "/v1/api/groups", body: `{"name": ""}`, expectedStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/v1/api/groups", path: "/v1/api/groups", expectedStatus: http.StatusOK},
+		{method: http.MethodPost, target: "/v1/api/groups/join", path: "/v1/api/groups/join", body: `{"code": "tng_nope"}`, expectedStatus: http.StatusNotFound},
+		{method: http.MethodPost, target: "/v1/api/groups/join", path: "/v1/api/groups/join", body: `{"code": "` + inviteCode + `"}`, authorization: "Bearer " + created.Result.Token, expectedStatus: http.StatusForbidden},
+		{method: http.MethodPost, target: "/v1/api/groups/join", path: "/v1/api/groups/join", body: `{"code": "` + inviteCode + `"}`, expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: groupPath, path: "/v1/api/groups/{group_id}", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/groups/nope", path: "/v1/api/groups/{group_id}", expectedStatus: http.StatusNotFound},
+		{method: http.MethodPost, target: groupPath + "/invite", path: "/v1/api/groups/{group_id}/invite", expectedStatus: http.StatusForbidden},
+		{method: http.MethodPost, target: "/v1/api/groups/" + ownGroup.Id + "/invite", path: "/v1/api/groups/{group_id}/invite", expectedStatus: http.StatusOK},
+		{method: http.MethodPost, target: groupPath + "/playlists", path: "/v1/api/groups/{group_id}/playlists", body: `{"moods": {"paul": 0.2}, "date": "2021-01-05"}`, expectedStatus: http.StatusForbidden},
+		{method: http.MethodPut, target: groupPath + "/consent", path: "/v1/api/groups/{group_id}/consent", body: `{}`, expectedStatus: http.StatusBadRequest},
+		{method: http.MethodPut, target: groupPath + "/consent", path: "/v1/api/groups/{group_id}/consent", body: `{"consent": true}`, expectedStatus: http.StatusOK},
+		{method: http.MethodPost, target: groupPath + "/playlists", path: "/v1/api/groups/{group_id}/playlists", body: `{"moods": {"paul": 0.2, "ringo": -0.1}, "date": "2021-01-05"}`, expectedStatus: http.StatusOK},
+		{method: http.MethodPost, target: groupPath + "/playlists", path: "/v1/api/groups/{group_id}/playlists", body: `{"mode": "sideways", "moods": {}}`, expectedStatus: http.StatusBadRequest},
+		{method: http.MethodPost, target: "/v1/api/groups/" + ownGroup.Id + "/playlists", path: "/v1/api/groups/{group_id}/playlists", body: `{"moods": {"paul": 0.2}}`, expectedStatus: http.StatusForbidden},
+		{method: http.MethodGet, target: groupPath + "/playlists/2021-01-05", path: "/v1/api/groups/{group_id}/playlists/{date}", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: groupPath + "/playlists/2021-01-06", path: "/v1/api/groups/{group_id}/playlists/{date}", expectedStatus: http.StatusNotFound},
+		{method: http.MethodDelete, target: groupPath + "/members/" + member, path: "/v1/api/groups/{group_id}/members/{user_id}", expectedStatus: http.StatusForbidden},
+		{method: http.MethodDelete, target: groupPath + "/members/" + userId, path: "/v1/api/groups/{group_id}/members/{user_id}", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/removed_tracks", path: "/v1/api/removed_tracks", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/spotify_playlist", path: "/v1/api/spotify_playlist", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/all_data", path: "/v1/api/all_data", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/export", path: "/v1/api/export", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/export", path: "/v1/api/export", loggedOut: true, expectedStatus: http.StatusUnauthorized},
+		{method: http.MethodPost, target: "/v1/api/import", path: "/v1/api/import", body: export.String(), expectedStatus: http.StatusOK},
+		{method: http.MethodPost, target: "/v1/api/import?mode=replace", path: "/v1/api/import", body: export.String(), expectedStatus: http.StatusOK},
+		{method: http.MethodPost, target: "/v1/api/import?mode=sideways", path: "/v1/api/import", body: export.String(), expectedStatus: http.StatusBadRequest},
+		{method: http.MethodPost, target: "/v1/api/import", path: "/v1/api/import", body: "not an archive", expectedStatus: http.StatusBadRequest},
+		{method: http.MethodPost, target: "/v1/api/generate_mood_playlist", path: "/v1/api/generate_mood_playlist", body: `{"mood": 2}`, expectedStatus: http.StatusBadRequest},
+		{method: http.MethodPost, target: "/v1/api/update_playlist/2021-01-01", path: "/v1/api/update_playlist/{playlist_id}", expectedStatus: http.StatusForbidden},
+		{method: http.MethodPost, target: "/v1/api/unremove_track/b", path: "/v1/api/unremove_track/{track_id}", expectedStatus: http.StatusOK},
+		{method: http.MethodPost, target: "/v1/api/remove_track/b", path: "/v1/api/remove_track/{track_id}", expectedStatus: http.StatusOK},
+		{method: http.MethodPost, target: "/v1/api/remove_track/nope", path: "/v1/api/remove_track/{track_id}", expectedStatus: http.StatusNotFound},
+		{method: http.MethodGet, target: "/v1/api/scopes", path: "/v1/api/scopes", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/stats/mood?from=2020-12-31&to=2021-01-03", path: "/v1/api/stats/mood", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/stats/mood", path: "/v1/api/stats/mood", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/library/report", path: "/v1/api/library/report", expectedStatus: http.StatusOK},
+		{method: http.MethodPatch, target: "/v1/api/mood_playlist/2021-01-02", path: "/v1/api/mood_playlist/{date}", body: `{"note": "sunny walk"}`, expectedStatus: http.StatusOK},
+		{method: http.MethodPatch, target: "/v1/api/mood_playlist/2021-01-02", path: "/v1/api/mood_playlist/{date}", body: `{"note": 1}`, expectedStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/v1/api/search?q=walk", path: "/v1/api/search", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/search?q=rainy&from=2021-01-01&to=2021-01-31", path: "/v1/api/search", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/search?q=walk&limit=nope", path: "/v1/api/search", expectedStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/v1/api/stats/mood?to=2021-01-01&from=2021-01-02", path: "/v1/api/stats/mood", expectedStatus: http.StatusBadRequest},
+		{method: http.MethodPut, target: "/v1/api/mood_playlist/2021-01-01/tags", path: "/v1/api/mood_playlist/{date}/tags", body: `{"tags": ["Weather:Rain", "work"]}`, expectedStatus: http.StatusOK},
+		{method: http.MethodPut, target: "/v1/api/mood_playlist/2021-01-01/tags", path: "/v1/api/mood_playlist/{date}/tags", body: `{"tags": ["a/b"]}`, expectedStatus: http.StatusBadRequest},
+		{method: http.MethodPut, target: "/v1/api/mood_playlist/2021-01-03/tags", path: "/v1/api/mood_playlist/{date}/tags", body: `{"tags": []}`, expectedStatus: http.StatusNotFound},
+		{method: http.MethodGet, target: "/v1/api/mood_playlists?tag=weather", path: "/v1/api/mood_playlists", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/stats/mood?from=2020-12-31&to=2021-01-03&tag=work", path: "/v1/api/stats/mood", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/stats/mood?tag=:rain", path: "/v1/api/stats/mood", expectedStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/v1/api/tags", path: "/v1/api/tags", expectedStatus: http.StatusOK},
+		{method: http.MethodPatch, target: "/v1/api/tags/work", path: "/v1/api/tags/{tag}", body: `{"tag": "job"}`, expectedStatus: http.StatusOK},
+		{method: http.MethodPatch, target: "/v1/api/tags/job", path: "/v1/api/tags/{tag}", body: `{"tag": ""}`, expectedStatus: http.StatusBadRequest},
+		{method: http.MethodDelete, target: "/v1/api/tags/weather:rain", path: "/v1/api/tags/{tag}", expectedStatus: http.StatusOK},
+		{method: http.MethodDelete, target: "/v1/api/tags/weather:rain", path: "/v1/api/tags/{tag}", expectedStatus: http.StatusNotFound},
+		{method: http.MethodGet, target: "/v1/api/scopes", path: "/v1/api/scopes", authorization: "Bearer " + created.Result.Token, expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/feeds/mood.ics?token=" + feedToken.Result.Token, path: "/v1/feeds/mood.ics", loggedOut: true, expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/feeds/mood.atom?token=" + feedToken.Result.Token, path: "/v1/feeds/mood.atom", loggedOut: true, expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/feeds/mood.ics?token=" + created.Result.Token, path: "/v1/feeds/mood.ics", loggedOut: true, expectedStatus: http.StatusForbidden},
+		{method: http.MethodGet, target: "/v1/feeds/mood.atom", path: "/v1/feeds/mood.atom", loggedOut: true, expectedStatus: http.StatusUnauthorized},
+		{method: http.MethodGet, target: "/v1/api/sessions", path: "/v1/api/sessions", expectedStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/api/sessions", path: "/v1/api/sessions", authorization: "Bearer " + created.Result.Token, expectedStatus: http.StatusForbidden},
+		{method: http.MethodDelete, target: "/v1/api/sessions/nope", path: "/v1/api/sessions/{session_id}", expectedStatus: http.StatusNotFound},
+		{method: http.MethodGet, target: "/v1/api/tokens", path: "/v1/api/tokens", expectedStatus: http.StatusOK},
+		{method: http.MethodPost, target: "/v1/api/tokens", path: "/v1/api/tokens", body: `{"name": "another", "scopes": ["read"]}`, expectedStatus: http.StatusOK},
+		{method: http.MethodPost, target: "/v1/api/tokens", path: "/v1/api/tokens", body: `{"scopes": ["read"]}`, expectedStatus: http.StatusBadRequest},
+		{method: http.MethodDelete, target: "/v1/api/tokens/nope", path: "/v1/api/tokens/{token_id}", expectedStatus: http.StatusNotFound},
+		{method: http.MethodGet, target: "/admin/backup", path: "/admin/backup", loggedOut: true, expectedStatus: http.StatusUnauthorized},
+		{method: http.MethodGet, target: "/admin/backup", path: "/admin/backup", authorization: "Bearer " + adminToken, expectedStatus: http.StatusOK},
+		{method: http.MethodPost, target: "/admin/restore", path: "/admin/restore", body: "not a backup", authorization: "Bearer " + adminToken, expectedStatus: http.StatusBadRequest},
+		{method: http.MethodDelete, target: "/v1/api/remove_all_user_data", path: "/v1/api/remove_all_user_data", authorization: "Bearer " + created.Result.Token, expectedStatus: http.StatusForbidden},
+		{method: http.MethodDelete, target: "/v1/api/tokens/" + created.Result.Id, path: "/v1/api/tokens/{token_id}", expectedStatus: http.StatusOK},
+		{method: http.MethodDelete, target: "/v1/api/remove_all_user_data", path: "/v1/api/remove_all_user_data", expectedStatus: http.StatusOK},
+	}
+	for _, scenario := range scenarios {
+		name := fmt.Sprintf("%s %s", scenario.method, scenario.target)
+		// Bodies of bad requests are meant not to match their schema
+		if scenario.body != "" && scenario.expectedStatus != http.StatusBadRequest {
+			problems := doc.validateRequest(scenario.path, scenario.method, scenario.body)
+			sort.Strings(problems)
+			assert.Empty(t, problems, name)
+		}
+
+		r := httptest.NewRequest(scenario.method, scenario.target, bytes.NewReader([]byte(scenario.body)))
+		if scenario.authorization != "" {
+			r.Header.Set("Authorization", scenario.authorization)
+		}
+
+		// Run
+		var w *httptest.ResponseRecorder
+		if scenario.loggedOut || scenario.authorization != "" {
+			w = newBrowser(router).send(r)
+		} else {
+			w = b.send(r)
+		}
+
+		if !assert.Equal(t, scenario.expectedStatus, w.Code, name) {
+			continue
+		}
+
+		response, ok := doc.response(scenario.path, scenario.method, w.Code)
+		if !assert.True(t, ok, "%s: %d is not documented", name, w.Code) {
+			continue
+		}
+
+		contentType := strings.Split(w.Header().Get("Content-Type"), ";")[0]
+		content, ok := response.Content[contentType]
+		if !assert.True(t, ok, "%s: %s is not documented", name, contentType) || contentType != "application/json" {
+			continue
+		}
+
+		var body interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), name)
+		problems := doc.validate(content.Schema, body, "body")
+		sort.Strings(problems)
+		assert.Empty(t, problems, name)
+	}
+}
+
+func TestOpenAPIBackupRestore(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	router := newTestRouter(t, dbConn)
+	doc := loadOpenAPI(t)
+	backup := withToken(router, http.MethodGet, "/admin/backup", "Bearer "+adminToken)
+	assert.Equal(t, http.StatusOK, backup.Code)
+
+	// Run
+	r := httptest.NewRequest(http.MethodPost, "/admin/restore", backup.Body)
+	r.Header.Set("Authorization", "Bearer "+adminToken)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	response, _ := doc.response("/admin/restore", http.MethodPost, w.Code)
+	var body interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Empty(t, doc.validate(response.Content["application/json"].Schema, body, "body"))
+}
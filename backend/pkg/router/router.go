@@ -2,6 +2,7 @@ package router
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -15,7 +16,6 @@ import (
 	"time"
 
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 	uuid "github.com/nu7hatch/gouuid"
 	"github.com/zmb3/spotify"
@@ -23,18 +23,25 @@ import (
 
 	"github.com/gin-contrib/static"
 	"github.com/sardap/TuneNeutral/backend/pkg/api"
+	"github.com/sardap/TuneNeutral/backend/pkg/backup"
 	"github.com/sardap/TuneNeutral/backend/pkg/config"
 	"github.com/sardap/TuneNeutral/backend/pkg/db"
 	"github.com/sardap/TuneNeutral/backend/pkg/models"
+	"github.com/sardap/TuneNeutral/backend/pkg/playlistexport"
+	"github.com/sardap/TuneNeutral/backend/pkg/sessionstore"
 )
 
 const (
-	spotifyAuthKey = "spotify_auth"
-	databaseKey    = "database"
+	spotifyAuthKey       = "spotify_auth"
+	spotifyHTTPClientKey = "spotify_http_client"
+	databaseKey          = "database"
+	accessTokenKey       = "access_token"
+	sessionName          = "tune"
 )
 
 var (
-	errNotAuth = fmt.Errorf("not auth")
+	errNotAuth      = api.NewError(api.CodeUnauthorized, "not logged in")
+	errInvalidState = api.NewError(api.CodeBadRequest, "invalid state")
 )
 
 func getDatabase(c *gin.Context) *db.Database {
@@ -42,9 +49,9 @@ func getDatabase(c *gin.Context) *db.Database {
 	return inter.(*db.Database)
 }
 
-func getAuth(c *gin.Context) *spotify.Authenticator {
+func getAuth(c *gin.Context) *oauth2.Config {
 	auth, _ := c.Get(spotifyAuthKey)
-	return auth.(*spotify.Authenticator)
+	return auth.(*oauth2.Config)
 }
 
 func decodeToken(encodedToken []byte) *oauth2.Token {
@@ -56,8 +63,48 @@ func decodeToken(encodedToken []byte) *oauth2.Token {
 	return &result
 }
 
-func GetClientFromToken(auth *spotify.Authenticator, token []byte) (spotify.Client, error) {
-	return auth.NewClient(decodeToken(token)), nil
+func newSpotifyClient(c *gin.Context, auth *oauth2.Config, current *oauth2.Token, persist func(*oauth2.Token) error) spotify.Client {
+	tokenSource := newPersistingTokenSource(
+		auth.TokenSource(oauthContext(c), current),
+		current,
+		persist,
+	)
+
+	return spotify.NewClient(oauth2.NewClient(oauthContext(c), tokenSource))
+}
+
+// GetClientFromToken creates a spotify client for the session's token. Any
+// token the client refreshes is written back to the session and the user's
+// stored token.
+func GetClientFromToken(c *gin.Context, auth *oauth2.Config, token []byte) (spotify.Client, error) {
+	session := sessions.Default(c)
+
+	return newSpotifyClient(c, auth, decodeToken(token), func(refreshed *oauth2.Token) error {
+		encoded := encodeToken(refreshed)
+		if userId, ok := session.Get(api.Userkey).(string); ok && userId != "" {
+			if err := getDatabase(c).UpdateSpotifyToken(userId, encoded); err != nil {
+				return err
+			}
+		}
+
+		session.Set(api.TokenKey, encoded)
+		return session.Save()
+	}), nil
+}
+
+// getClientForAccessToken creates a spotify client for the stored token of the
+// access token's user.
+func getClientForAccessToken(c *gin.Context, accessToken *models.AccessToken) (*spotify.Client, error) {
+	db := getDatabase(c)
+	token, err := api.GetSpotifyToken(db, accessToken.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	client := newSpotifyClient(c, getAuth(c), decodeToken(token), func(refreshed *oauth2.Token) error {
+		return db.UpdateSpotifyToken(accessToken.UserId, encodeToken(refreshed))
+	})
+	return &client, nil
 }
 
 func encodeToken(a *oauth2.Token) []byte {
@@ -69,76 +116,183 @@ func encodeToken(a *oauth2.Token) []byte {
 // the user will eventually be redirected back to your redirect URL
 // typically you'll have a handler set up like the following:
 func redirectEndpoint(c *gin.Context) {
-	defer time.Sleep(time.Until(time.Now().Add(1 * time.Second)))
+	if reason := c.Query("error"); reason != "" {
+		processApiError(c, api.NewError(api.CodeBadRequest, fmt.Sprintf("authorization failed: %s", reason)))
+		return
+	}
+
+	state := c.Query("state")
+	code := c.Query("code")
+	if state == "" || code == "" {
+		processApiError(c, api.NewError(api.CodeBadRequest, "missing state or code"))
+		return
+	}
 
 	db := getDatabase(c)
-	// use the same userId string here that you used to generate the URL
-	key, err := db.GetAuthState(c.Request.URL.Query().Get("state"))
-	if err != nil {
-		c.JSON(400, gin.H{
-			"message": "invlaid",
-		})
+	// States are single use, consume it before anything else can fail so it
+	// can't be replayed
+	authState, err := api.ConsumeAuthState(db, state)
+	if errors.Is(err, api.ErrNotFound) {
+		processApiError(c, errInvalidState)
+		return
+	} else if err != nil {
+		processApiError(c, err)
 		return
 	}
 
 	session := sessions.Default(c)
-
-	if session.Get(api.StateKey).(string) != key {
-		c.JSON(400, gin.H{
-			"message": "invlaid",
-		})
+	key, ok := session.Get(api.StateKey).(string)
+	if !ok || subtle.ConstantTimeCompare([]byte(key), []byte(authState.Key)) != 1 {
+		processApiError(c, errInvalidState)
 		return
 	}
-
-	code := c.Request.URL.Query().Get("code")
+	session.Delete(api.StateKey)
 
 	auth := getAuth(c)
-	token, err := auth.Exchange(code)
+	token, err := auth.Exchange(
+		oauthContext(c), code,
+		oauth2.SetAuthURLParam("code_verifier", authState.Verifier),
+	)
 	if err != nil {
-		http.Error(c.Writer, "Couldn't get token", http.StatusNotFound)
+		processApiError(c, api.NewError(api.CodeBadRequest, "unable to exchange authorization code").WithCause(err))
 		return
 	}
 
 	session.Set(api.TokenKey, encodeToken(token))
+	session.Delete(api.Userkey)
+	// Resolve the user now so the session is listed and revocable from the
+	// start
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
 	if err := session.Save(); err != nil {
+		processApiError(c, api.Internal(err))
+		return
+	}
+	// Kept for requests made with the user's access tokens, if they have any
+	if err := db.UpdateSpotifyToken(userId, encodeToken(token)); err != nil {
+		processApiError(c, api.Internal(err))
+		return
+	}
+
+	// The token response only lists scopes when they differ from what was
+	// requested
+	granted := authState.Scopes
+	if scope, ok := token.Extra("scope").(string); ok && scope != "" {
+		granted = strings.Fields(scope)
+	}
+	if err := api.SetGrantedScopes(db, userId, granted); err != nil {
+		processApiError(c, err)
 		return
 	}
 
 	c.Redirect(http.StatusTemporaryRedirect, "/")
 }
 
+func errorStatus(code api.ErrorCode) int {
+	switch code {
+	case api.CodeBadRequest, api.CodeInvalid:
+		return http.StatusBadRequest
+	case api.CodeUnauthorized:
+		return http.StatusUnauthorized
+	case api.CodeForbidden:
+		return http.StatusForbidden
+	case api.CodeNotFound:
+		return http.StatusNotFound
+	case api.CodeConflict:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeError responds with apiErr in the error envelope and stops the request.
+func writeError(c *gin.Context, apiErr *api.Error) {
+	c.AbortWithStatusJSON(errorStatus(apiErr.Code), gin.H{
+		"error": apiErr,
+	})
+}
+
+// processApiError responds with err like writeError. Anything that isn't an
+// api.Error is logged and reported as a server error, and users whose login
+// no longer works are logged out.
 func processApiError(c *gin.Context, err error) {
-	if errors.Is(err, api.ErrNotFound) {
-		c.JSON(http.StatusNotFound, gin.H{})
-	} else {
+	apiErr := *api.AsError(err)
+
+	switch apiErr.Code {
+	case api.CodeUnauthorized:
+		// Force a fresh login rather than failing on every request, requests
+		// made with a token have no session to log out of
+		if c.GetHeader("Authorization") == "" {
+			api.Logout(sessions.Default(c))
+		}
+	case api.CodeServerError:
 		id, _ := uuid.NewV4()
-		log.Printf("Internal server error(%s): %v", id.String(), errors.Unwrap(err))
-		c.JSON(http.StatusInternalServerError, gin.H{
+		log.Printf("Internal server error(%s): %v", id.String(), err)
+		apiErr.Meta = map[string]string{
 			"reference_code": id.String(),
-		})
+		}
 	}
+
+	writeError(c, &apiErr)
 }
 
 func authEndpoint(c *gin.Context) {
 	if c.Query("terms_and_conditions") != "true" {
-		c.JSON(http.StatusUnauthorized, gin.H{})
+		processApiError(c, api.Invalid(api.FieldError{
+			Field:   "terms_and_conditions",
+			Message: "must be accepted",
+		}))
 		return
 	}
 
+	features := []api.Feature{api.FeatureJournal}
+	for _, value := range c.QueryArray("feature") {
+		feature, err := api.ParseFeature(value)
+		if err != nil {
+			processApiError(c, err)
+			return
+		}
+		features = append(features, feature)
+	}
+
 	db := getDatabase(c)
-	state, key, err := db.GenerateAuthState()
+	session := sessions.Default(c)
+	// Logged in users keep what they already granted
+	userId, _ := session.Get(api.Userkey).(string)
+	scopes, err := api.RequestScopes(db, userId, features...)
 	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	verifier, err := newCodeVerifier()
+	if err != nil {
+		processApiError(c, api.Internal(err))
+		return
+	}
+
+	state, key, err := db.GenerateAuthState(verifier, scopes)
+	if err != nil {
+		processApiError(c, api.Internal(err))
 		return
 	}
 
-	session := sessions.Default(c)
 	session.Set(api.StateKey, key)
 	if err := session.Save(); err != nil {
+		processApiError(c, api.Internal(err))
 		return
 	}
 
 	auth := getAuth(c)
-	c.Redirect(http.StatusTemporaryRedirect, auth.AuthURL(state))
+	c.Redirect(http.StatusTemporaryRedirect, auth.AuthCodeURL(
+		state,
+		oauth2.SetAuthURLParam("scope", strings.Join(scopes, " ")),
+		oauth2.SetAuthURLParam("code_challenge", codeChallenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	))
 }
 
 func logoutEndpoint(c *gin.Context) {
@@ -155,7 +309,23 @@ func getToken(session sessions.Session) ([]byte, error) {
 	return result, nil
 }
 
+func getAccessToken(c *gin.Context) (*models.AccessToken, bool) {
+	inter, ok := c.Get(accessTokenKey)
+	if !ok {
+		return nil, false
+	}
+	return inter.(*models.AccessToken), true
+}
+
 func getUser(c *gin.Context) (string, *spotify.Client, error) {
+	if accessToken, ok := getAccessToken(c); ok {
+		client, err := getClientForAccessToken(c, accessToken)
+		if err != nil {
+			return "", nil, err
+		}
+		return accessToken.UserId, client, nil
+	}
+
 	session := sessions.Default(c)
 
 	token, err := getToken(session)
@@ -164,7 +334,7 @@ func getUser(c *gin.Context) (string, *spotify.Client, error) {
 	}
 
 	auth := getAuth(c)
-	client, err := GetClientFromToken(auth, []byte(token))
+	client, err := GetClientFromToken(c, auth, []byte(token))
 	if err != nil {
 		return "", nil, err
 	}
@@ -198,47 +368,190 @@ func authenticatedEndpoint(c *gin.Context) {
 	if isAuthenticated(c) {
 		c.JSON(http.StatusOK, gin.H{})
 	} else {
-		c.JSON(http.StatusUnauthorized, gin.H{})
+		processApiError(c, errNotAuth)
+	}
+}
+
+// accessTokenAuth authenticates a request made with an access token in the
+// Authorization header.
+func accessTokenAuth(c *gin.Context, header string) {
+	raw := strings.TrimPrefix(header, "Bearer ")
+	if raw == header {
+		processApiError(c, api.NewError(api.CodeUnauthorized, "expected a bearer token"))
+		return
+	}
+
+	accessToken, err := api.AuthenticateAccessToken(getDatabase(c), raw)
+	if err != nil {
+		processApiError(c, err)
+		return
 	}
+
+	if !api.TokenAllows(accessToken, c.Request.Method) {
+		processApiError(c, api.NewError(api.CodeForbidden, "token does not have the scope for this request"))
+		return
+	}
+
+	c.Set(accessTokenKey, accessToken)
+	c.Next()
 }
 
 func authMiddleware(c *gin.Context) {
+	if header := c.GetHeader("Authorization"); header != "" {
+		accessTokenAuth(c, header)
+		return
+	}
+
 	if isAuthenticated(c) {
 		c.Next()
 	} else {
-		c.JSON(http.StatusUnauthorized, gin.H{})
+		processApiError(c, errNotAuth)
+	}
+}
+
+// feedTokenAuth authenticates a feed request with the access token in its
+// token query parameter, calendar apps and feed readers can only be given a
+// URL.
+func feedTokenAuth(c *gin.Context) {
+	accessToken, err := api.AuthenticateAccessToken(getDatabase(c), c.Query("token"))
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	if !api.TokenHasScope(accessToken, api.TokenScopeFeed) {
+		processApiError(c, api.NewError(api.CodeForbidden, "token does not have the feed scope"))
+		return
+	}
+
+	c.Set(accessTokenKey, accessToken)
+	c.Next()
+}
+
+// sessionOnly refuses requests made with an access token, so a leaked token
+// can't be used to manage logins or mint more tokens.
+func sessionOnly(c *gin.Context) {
+	if _, ok := getAccessToken(c); ok {
+		processApiError(c, api.NewError(api.CodeForbidden, "requires a browser session"))
+		return
+	}
+	c.Next()
+}
+
+// requireFeature stops requests from users who haven't granted the scopes
+// feature needs and tells them where to grant them.
+func requireFeature(feature api.Feature) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, _, err := getUser(c)
+		if err != nil {
+			processApiError(c, err)
+			return
+		}
+
+		granted, err := api.GrantedScopes(getDatabase(c), userId)
+		if err != nil {
+			processApiError(c, err)
+			return
+		}
+
+		if !api.HasFeature(granted, feature) {
+			apiErr := api.NewError(api.CodeForbidden, fmt.Sprintf("the %s feature has not been enabled", feature))
+			apiErr.Meta = map[string]string{
+				"feature":  string(feature),
+				"auth_url": fmt.Sprintf("/auth?terms_and_conditions=true&feature=%s", feature),
+			}
+			processApiError(c, apiErr)
+			return
+		}
+
+		c.Next()
 	}
 }
 
+type getScopesResponse struct {
+	Scopes   []string      `json:"scopes"`
+	Features []api.Feature `json:"features"`
+}
+
+func getScopesEndpoint(c *gin.Context) {
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	granted, err := api.GrantedScopes(getDatabase(c), userId)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": getScopesResponse{
+			Scopes:   granted,
+			Features: api.EnabledFeatures(granted),
+		},
+	})
+}
+
 type basicPlaylist struct {
-	Date      string  `json:"date"`
-	StartMood float32 `json:"start_mood"`
-	Note      *string `json:"note"`
+	Date      string   `json:"date"`
+	StartMood float32  `json:"start_mood"`
+	Note      *string  `json:"note"`
+	Tags      []string `json:"tags"`
+}
+
+func toBasicPlaylist(playlist *models.MoodPlaylist) basicPlaylist {
+	return basicPlaylist{
+		Date:      playlist.Date.Format(time.RFC3339),
+		StartMood: playlist.StartMood,
+		Note:      playlist.Note,
+		Tags:      playlistTags(playlist),
+	}
+}
+
+func playlistTags(playlist *models.MoodPlaylist) []string {
+	if playlist.Tags == nil {
+		return []string{}
+	}
+	return playlist.Tags
 }
 
 type getPlaylistsResponse struct {
 	Playlists []basicPlaylist `json:"playlists"`
+	// NextCursor fetches the next page, nil on the last page
+	NextCursor *string `json:"next_cursor"`
 }
 
 func getMoodPlaylistsEndpoint(c *gin.Context) {
-	userId, _, _ := getUser(c)
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	query, err := api.ValidatePlaylistQuery(
+		c.Query("from"), c.Query("to"), c.Query("cursor"), c.Query("order"), c.Query("limit"),
+		c.Query("tag"),
+	)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
 
 	db := getDatabase(c)
-	playlists, err := api.GetPlaylists(db, userId)
+	playlists, nextCursor, err := api.GetPlaylists(db, userId, query)
 	if err != nil {
 		processApiError(c, err)
 		return
 	}
 
-	response := getPlaylistsResponse{}
+	response := getPlaylistsResponse{Playlists: []basicPlaylist{}}
+	if nextCursor != "" {
+		response.NextCursor = &nextCursor
+	}
 	for _, playlist := range playlists {
-		basicPlaylist := basicPlaylist{
-			Date:      playlist.Date.Format(time.RFC3339),
-			StartMood: playlist.StartMood,
-			Note:      playlist.Note,
-		}
-
-		response.Playlists = append(response.Playlists, basicPlaylist)
+		response.Playlists = append(response.Playlists, toBasicPlaylist(playlist))
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -263,14 +576,66 @@ type basicTrack struct {
 	Artists []IdNamePair `json:"artists"`
 }
 
+func toBasicTrack(track *models.Track) basicTrack {
+	result := basicTrack{
+		IdNamePair: IdNamePair{
+			Name: track.Name,
+			Id:   track.Id,
+		},
+		Mood: float32(models.TrackMood(track.Valence)),
+		Album: basicAlbum{
+			IdNamePair: IdNamePair{
+				Name: track.AlbumId,
+				Id:   track.AlbumId,
+			},
+			Url: track.AlbumArtUrl,
+		},
+		Artists: []IdNamePair{},
+	}
+
+	for _, artist := range track.Artists {
+		result.Artists = append(result.Artists, IdNamePair{
+			Name: artist.Name,
+			Id:   string(artist.ID),
+		})
+	}
+
+	return result
+}
+
+// hydrateTracks loads the tracks for ids in one batch and maps them to
+// basicTracks, ids with no stored track are returned in missing.
+func hydrateTracks(db *db.Database, ids []string) (tracks []basicTrack, missing []string, err error) {
+	modelTracks, missing, err := api.GetTracks(db, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tracks = []basicTrack{}
+	if missing == nil {
+		missing = []string{}
+	}
+	for _, track := range modelTracks {
+		tracks = append(tracks, toBasicTrack(track))
+	}
+
+	return tracks, missing, nil
+}
+
 type getPlaylistResponse struct {
-	Tracks    []basicTrack `json:"tracks"`
-	StartMood float32      `json:"start_mood"`
-	Note      *string      `json:"note"`
+	Tracks        []basicTrack `json:"tracks"`
+	MissingTracks []string     `json:"missing_tracks"`
+	StartMood     float32      `json:"start_mood"`
+	Note          *string      `json:"note"`
+	Tags          []string     `json:"tags"`
 }
 
 func getMoodPlaylistEndpoint(c *gin.Context) {
-	userId, _, _ := getUser(c)
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
 
 	db := getDatabase(c)
 	playlist, err := api.GetPlaylist(db, userId, c.Param("date"))
@@ -279,51 +644,137 @@ func getMoodPlaylistEndpoint(c *gin.Context) {
 		return
 	}
 
+	tracks, missing, err := hydrateTracks(db, playlist.Tracks)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
 	response := getPlaylistResponse{
-		StartMood: playlist.StartMood,
-		Note:      playlist.Note,
+		Tracks:        tracks,
+		MissingTracks: missing,
+		StartMood:     playlist.StartMood,
+		Note:          playlist.Note,
+		Tags:          playlistTags(playlist),
 	}
-	for _, track := range playlist.Tracks {
-		track, err := db.GetTrack(track)
-		if err != nil {
-			continue
-		}
-		basicTrack := basicTrack{
-			IdNamePair: IdNamePair{
-				Name: track.Name,
-				Id:   track.Id,
-			},
-			Mood: float32(models.ValenceMoodCategory(track.Valence - 0.5)),
-			Album: basicAlbum{
-				IdNamePair: IdNamePair{
-					Name: track.AlbumId,
-					Id:   track.AlbumId,
-				},
-				Url: track.AlbumArtUrl,
-			},
-		}
 
-		for _, artist := range track.Artists {
-			basicTrack.Artists = append(basicTrack.Artists, IdNamePair{
-				Name: artist.Name,
-				Id:   string(artist.ID),
-			})
-		}
+	c.JSON(http.StatusOK, gin.H{
+		"result": response,
+	})
+}
+
+func exportMoodPlaylistEndpoint(c *gin.Context) {
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	exporter, ok := playlistexport.Get(c.Query("format"))
+	if !ok {
+		processApiError(c, api.Invalid(api.FieldError{
+			Field:   "format",
+			Message: "must be one of " + strings.Join(playlistexport.Formats(), ", "),
+		}))
+		return
+	}
+
+	db := getDatabase(c)
+	playlist, err := api.GetPlaylist(db, userId, c.Param("date"))
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
 
-		response.Tracks = append(response.Tracks, basicTrack)
+	tracks, _, err := api.GetTracks(db, playlist.Tracks)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := exporter.Write(&buf, playlistexport.New(playlist, tracks)); err != nil {
+		processApiError(c, api.Internal(err))
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(
+		"attachment; filename=tune-neutral-%s.%s", playlist.Date.Format(models.DateFormat), exporter.Extension(),
+	))
+	c.Data(http.StatusOK, exporter.ContentType(), buf.Bytes())
+}
+
+type updateNoteRequest struct {
+	Note *string `json:"note"`
+}
+
+func updateNoteEndpoint(c *gin.Context) {
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	var request updateNoteRequest
+	if err := decodeJSONBody(c, &request); err != nil {
+		processApiError(c, err)
+		return
+	}
+	if request.Note == nil {
+		processApiError(c, api.Invalid(api.FieldError{Field: "note", Message: "is required"}))
+		return
+	}
+
+	playlist, err := api.UpdatePlaylistNote(getDatabase(c), userId, c.Param("date"), *request.Note)
+	if err != nil {
+		processApiError(c, err)
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"result": response,
+		"result": toBasicPlaylist(playlist),
+	})
+}
+
+type searchNotesResponse struct {
+	Results []api.SearchResult `json:"results"`
+}
+
+func searchNotesEndpoint(c *gin.Context) {
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	query, err := api.ValidateSearchQuery(c.Query("q"), c.Query("from"), c.Query("to"), c.Query("limit"))
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	results, err := api.SearchNotes(getDatabase(c), userId, query)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": searchNotesResponse{Results: results},
 	})
 }
 
 type getRemovedTracksResponse struct {
-	Tracks []basicTrack `json:"tracks"`
+	Tracks        []basicTrack `json:"tracks"`
+	MissingTracks []string     `json:"missing_tracks"`
 }
 
 func getRemovedTracksEndpoint(c *gin.Context) {
-	userId, _, _ := getUser(c)
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
 
 	db := getDatabase(c)
 	tracks, err := api.GetRemovedTracksForUser(db, userId)
@@ -332,35 +783,15 @@ func getRemovedTracksEndpoint(c *gin.Context) {
 		return
 	}
 
-	response := getRemovedTracksResponse{}
-	for _, track := range tracks {
-		track, err := db.GetTrack(track)
-		if err != nil {
-			continue
-		}
-		basicTrack := basicTrack{
-			IdNamePair: IdNamePair{
-				Name: track.Name,
-				Id:   track.Id,
-			},
-			Mood: float32(models.ValenceMoodCategory(track.Valence - 0.5)),
-			Album: basicAlbum{
-				IdNamePair: IdNamePair{
-					Name: track.AlbumId,
-					Id:   track.AlbumId,
-				},
-				Url: track.AlbumArtUrl,
-			},
-		}
-
-		for _, artist := range track.Artists {
-			basicTrack.Artists = append(basicTrack.Artists, IdNamePair{
-				Name: artist.Name,
-				Id:   string(artist.ID),
-			})
-		}
+	hydrated, missing, err := hydrateTracks(db, tracks)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
 
-		response.Tracks = append(response.Tracks, basicTrack)
+	response := getRemovedTracksResponse{
+		Tracks:        hydrated,
+		MissingTracks: missing,
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -373,7 +804,11 @@ type getSpotifyPlaylist struct {
 }
 
 func getSpotifyPlaylistEndpoint(c *gin.Context) {
-	userId, _, _ := getUser(c)
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
 
 	db := getDatabase(c)
 
@@ -395,7 +830,11 @@ type getAllDataResponse struct {
 }
 
 func getAllData(c *gin.Context) {
-	userId, _, _ := getUser(c)
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
 
 	db := getDatabase(c)
 
@@ -414,35 +853,57 @@ func getAllData(c *gin.Context) {
 }
 
 type generateMoodPlaylistRequest struct {
-	Mood float32 `json:"mood"`
-	Date string  `json:"date"`
-	Note string  `json:"note"`
+	Mood *float32 `json:"mood"`
+	Date string   `json:"date"`
+	Note string   `json:"note"`
 }
 
 type generateMoodPlaylistResponse struct {
 }
 
+// decodeJSONBody reads the request body into v, describing what is wrong with
+// it when it doesn't fit.
+func decodeJSONBody(c *gin.Context, v interface{}) error {
+	jsonData, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		return api.NewError(api.CodeBadRequest, "unable to read body").WithCause(err)
+	}
+
+	err = json.Unmarshal(jsonData, v)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return api.Invalid(api.FieldError{
+			Field:   typeErr.Field,
+			Message: fmt.Sprintf("must be a %s", typeErr.Type),
+		})
+	} else if err != nil {
+		return api.NewError(api.CodeBadRequest, "body must be valid json")
+	}
+
+	return nil
+}
+
 func generateMoodPlaylistEndpoint(c *gin.Context) {
 	var request generateMoodPlaylistRequest
-	jsonData, _ := ioutil.ReadAll(c.Request.Body)
-	json.Unmarshal(jsonData, &request)
-
-	if request.Date == "" {
-		request.Date = time.Now().Format(time.RFC3339)
+	if err := decodeJSONBody(c, &request); err != nil {
+		processApiError(c, err)
+		return
 	}
 
-	date, err := time.Parse("2006-01-02", request.Date)
+	date, err := api.ValidateMoodPlaylist(request.Mood, request.Date, request.Note, time.Now())
 	if err != nil {
-		c.JSON(http.StatusOK, gin.H{
-			"error": "invalid date",
-		})
+		processApiError(c, err)
 		return
 	}
 
-	userId, client, _ := getUser(c)
+	userId, client, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
 
 	db := getDatabase(c)
-	_, err = api.GenerateMoodPlaylist(db, userId, client, models.Mood(request.Mood), date, request.Note)
+	_, err = api.GenerateMoodPlaylist(db, userId, client, models.Mood(*request.Mood), date, request.Note)
 	if err != nil {
 		processApiError(c, err)
 		return
@@ -454,10 +915,14 @@ func generateMoodPlaylistEndpoint(c *gin.Context) {
 }
 
 func updateTuneSpotifyPlaylistEndpoint(c *gin.Context) {
-	userId, client, _ := getUser(c)
+	userId, client, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
 
 	dbConn := getDatabase(c)
-	err := api.UpdateSpotifyPlaylist(dbConn, client, userId, c.Param("playlist_id"))
+	err = api.UpdateSpotifyPlaylist(dbConn, client, userId, c.Param("playlist_id"))
 	if err != nil {
 		processApiError(c, err)
 		return
@@ -469,10 +934,14 @@ func updateTuneSpotifyPlaylistEndpoint(c *gin.Context) {
 }
 
 func removeTrackEndpoint(c *gin.Context) {
-	userId, _, _ := getUser(c)
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
 
 	db := getDatabase(c)
-	err := api.RemoveTrackFromUser(db, userId, c.Param("track_id"))
+	err = api.RemoveTrackFromUser(db, userId, c.Param("track_id"))
 	if err != nil {
 		processApiError(c, err)
 		return
@@ -484,10 +953,14 @@ func removeTrackEndpoint(c *gin.Context) {
 }
 
 func unremoveTrackEndpoint(c *gin.Context) {
-	userId, _, _ := getUser(c)
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
 
 	db := getDatabase(c)
-	err := api.UnremoveTrackFromUser(db, userId, c.Param("track_id"))
+	err = api.UnremoveTrackFromUser(db, userId, c.Param("track_id"))
 	if err != nil {
 		processApiError(c, err)
 		return
@@ -499,7 +972,11 @@ func unremoveTrackEndpoint(c *gin.Context) {
 }
 
 func removeAllUserData(c *gin.Context) {
-	userId, _, _ := getUser(c)
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
 
 	db := getDatabase(c)
 	api.ClearUserData(db, userId)
@@ -510,14 +987,321 @@ func removeAllUserData(c *gin.Context) {
 	})
 }
 
+func adminMiddleware(adminToken string) gin.HandlerFunc {
+	expected := []byte("Bearer " + adminToken)
+
+	return func(c *gin.Context) {
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), expected) != 1 {
+			// Not the user's login failing, leave their session alone
+			writeError(c, api.NewError(api.CodeUnauthorized, "invalid admin token"))
+			return
+		}
+		c.Next()
+	}
+}
+
+func backupEndpoint(c *gin.Context) {
+	db := getDatabase(c)
+
+	c.Header("Content-Type", "application/x-tar")
+	c.Header("Content-Disposition", fmt.Sprintf(
+		"attachment; filename=tune-neutral-%s.tar", time.Now().UTC().Format("20060102T150405Z"),
+	))
+
+	if _, err := backup.Write(db, c.Writer); err != nil {
+		// Headers may already be on the wire so all we can do is log
+		log.Printf("Backup failed: %v", err)
+		c.Abort()
+	}
+}
+
+// restoreEndpoint writes a backup of the current database into backupDir,
+// when one is configured, before replacing it.
+func restoreEndpoint(backupDir string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := getDatabase(c)
+
+		if backupDir != "" {
+			path, err := backup.WriteFile(db, backupDir)
+			if err != nil {
+				processApiError(c, err)
+				return
+			}
+			log.Printf("Wrote pre-restore backup %s", path)
+		}
+
+		manifest, err := backup.Restore(db, c.Request.Body)
+		if err != nil {
+			if errors.Is(err, backup.ErrInvalidManifest) || errors.Is(err, backup.ErrChecksumMismatch) {
+				processApiError(c, api.NewError(api.CodeBadRequest, err.Error()))
+				return
+			}
+			processApiError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"result": manifest,
+		})
+	}
+}
+
+func getLibraryReportEndpoint(c *gin.Context) {
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	report, err := api.GetLibraryReport(getDatabase(c), userId, time.Now())
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": report,
+	})
+}
+
+func getMoodStatsEndpoint(c *gin.Context) {
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	from, to, window, err := api.ValidateStatsQuery(c.Query("from"), c.Query("to"), c.Query("window"), time.Now())
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	tag, err := api.ValidateTagFilter(c.Query("tag"))
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	stats, err := api.GetMoodStats(getDatabase(c), userId, from, to, window, tag)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": stats,
+	})
+}
+
+type basicSession struct {
+	Id        string `json:"id"`
+	CreatedAt string `json:"created_at"`
+	LastSeen  string `json:"last_seen"`
+	ExpiresAt string `json:"expires_at"`
+	UserAgent string `json:"user_agent"`
+	Ip        string `json:"ip"`
+	Current   bool   `json:"current"`
+}
+
+type getSessionsResponse struct {
+	Sessions []basicSession `json:"sessions"`
+}
+
+func getSessionsEndpoint(c *gin.Context) {
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	db := getDatabase(c)
+	sessionList, err := api.ListSessions(db, userId)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	currentId := sessions.Default(c).ID()
+	response := getSessionsResponse{Sessions: []basicSession{}}
+	for _, session := range sessionList {
+		response.Sessions = append(response.Sessions, basicSession{
+			Id:        api.SessionHandle(session.Id),
+			CreatedAt: session.CreatedAt.Format(time.RFC3339),
+			LastSeen:  session.LastSeen.Format(time.RFC3339),
+			ExpiresAt: session.ExpiresAt.Format(time.RFC3339),
+			UserAgent: session.UserAgent,
+			Ip:        session.Ip,
+			Current:   session.Id == currentId,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": response,
+	})
+}
+
+func revokeSessionEndpoint(c *gin.Context) {
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	db := getDatabase(c)
+	err = api.RevokeSession(db, userId, c.Param("session_id"))
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": "success",
+	})
+}
+
+func revokeAllSessionsEndpoint(c *gin.Context) {
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	db := getDatabase(c)
+	err = api.RevokeAllSessions(db, userId)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": "success",
+	})
+}
+
 func blockBadIps(c *gin.Context) {
 	db := getDatabase(c)
 
 	if db.IsIpGood(c.ClientIP()) {
 		c.Next()
 	} else {
-		c.JSON(http.StatusUnauthorized, gin.H{})
+		processApiError(c, api.NewError(api.CodeForbidden, "blocked"))
+	}
+}
+
+type basicAccessToken struct {
+	Id        string   `json:"id"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	CreatedAt string   `json:"created_at"`
+	LastUsed  *string  `json:"last_used"`
+}
+
+func toBasicAccessToken(token *models.AccessToken) basicAccessToken {
+	result := basicAccessToken{
+		Id:        token.Id,
+		Name:      token.Name,
+		Scopes:    token.Scopes,
+		CreatedAt: token.CreatedAt.Format(time.RFC3339),
 	}
+	if !token.LastUsed.IsZero() {
+		lastUsed := token.LastUsed.Format(time.RFC3339)
+		result.LastUsed = &lastUsed
+	}
+	return result
+}
+
+type getAccessTokensResponse struct {
+	Tokens []basicAccessToken `json:"tokens"`
+}
+
+func getAccessTokensEndpoint(c *gin.Context) {
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	db := getDatabase(c)
+	tokens, err := api.ListAccessTokens(db, userId)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	response := getAccessTokensResponse{Tokens: []basicAccessToken{}}
+	for _, token := range tokens {
+		response.Tokens = append(response.Tokens, toBasicAccessToken(token))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": response,
+	})
+}
+
+type createAccessTokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type createAccessTokenResponse struct {
+	basicAccessToken
+	// Token is the secret, it is only ever shown here
+	Token string `json:"token"`
+}
+
+func createAccessTokenEndpoint(c *gin.Context) {
+	var request createAccessTokenRequest
+	if err := decodeJSONBody(c, &request); err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	// Requests made with the token reach Spotify with the session's token
+	spotifyToken, err := getToken(sessions.Default(c))
+	if err != nil {
+		processApiError(c, api.ErrUnauthorized.WithCause(err))
+		return
+	}
+
+	db := getDatabase(c)
+	raw, token, err := api.CreateAccessToken(db, userId, request.Name, request.Scopes, spotifyToken)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": createAccessTokenResponse{
+			basicAccessToken: toBasicAccessToken(token),
+			Token:            raw,
+		},
+	})
+}
+
+func revokeAccessTokenEndpoint(c *gin.Context) {
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	db := getDatabase(c)
+	err = api.RevokeAccessToken(db, userId, c.Param("token_id"))
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": "success",
+	})
 }
 
 func getIps() []string {
@@ -534,8 +1318,11 @@ func getIps() []string {
 	return strings.Split(buf.String(), "\n")
 }
 
+// PullBadIps keeps the bad ips the router blocks up to date, it never
+// returns. It is started by the server rather than CreateRouter so routers
+// made in tests don't reach out to the internet.
 // TODO do this by a firewall rule
-func badIpPuller(db *db.Database) {
+func PullBadIps(db *db.Database) {
 	for {
 		ips := getIps()
 		for _, ip := range ips {
@@ -547,46 +1334,54 @@ func badIpPuller(db *db.Database) {
 }
 
 func CreateRouter(cfg *config.Config, db *db.Database) *gin.Engine {
-	go badIpPuller(db)
+	if cfg.BackupDir != "" {
+		go backup.Scheduled(db, cfg.BackupDir, cfg.BackupInterval, cfg.BackupRetention)
+	}
 
 	r := gin.Default()
 
-	auth := spotify.NewAuthenticator(
-		fmt.Sprintf("%s://%s/callback", cfg.Scheme, cfg.Domain),
-		spotify.ScopePlaylistReadPrivate,
-		spotify.ScopePlaylistReadCollaborative,
-		spotify.ScopeUserLibraryRead,
-		spotify.ScopeUserReadPrivate,
-		spotify.ScopeUserReadPlaybackState,
-		spotify.ScopeUserModifyPlaybackState,
-		spotify.ScopeUserTopRead,
-		spotify.ScopeStreaming,
-		spotify.ScopePlaylistModifyPublic,
-	)
-
-	auth.SetAuthInfo(
-		cfg.ClientId,
-		cfg.ClientSecret,
-	)
+	baseUrl := fmt.Sprintf("%s://%s", cfg.Scheme, cfg.Domain)
+	auth := &oauth2.Config{
+		ClientID:     cfg.ClientId,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  baseUrl + "/callback",
+		// Scopes are requested per login from the features being enabled
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  spotify.AuthURL,
+			TokenURL: spotify.TokenURL,
+		},
+	}
 
 	// Add spotify auth binding
 	r.Use(func(c *gin.Context) {
-		c.Set(spotifyAuthKey, &auth)
+		c.Set(spotifyAuthKey, auth)
+		c.Set(spotifyHTTPClientKey, cfg.SpotifyHTTPClient)
 		c.Set(databaseKey, db)
 		c.Next()
 	})
 
-	store := cookie.NewStore([]byte(cfg.CookieAuthSecert), []byte(cfg.CookieEyncSecert))
-	r.Use(sessions.Sessions("tune", store))
+	store := sessionstore.New(db, cfg.CookieKeyPairs()...)
+	r.Use(sessions.Sessions(sessionName, store))
+	r.Use(store.Reencode(sessionName))
 	r.Use(blockBadIps)
 
-	r.GET("/callback", redirectEndpoint)
+	// Slow down every callback to make guessing states expensive
+	r.GET("/callback", slowDown(cfg.CallbackDelay), redirectEndpoint)
 	r.GET("/auth", authEndpoint)
 	r.GET("/logout", logoutEndpoint)
 
 	v1 := r.Group("/v1/api")
 	{
 		v1.GET("/authenticated", authenticatedEndpoint)
+		v1.GET("/openapi.json", openAPIEndpoint)
+		v1.GET("/shared/:token", sharedPlaylistEndpoint)
+	}
+
+	feeds := r.Group("/v1/feeds")
+	feeds.Use(feedTokenAuth)
+	{
+		feeds.GET("/mood.ics", icalFeedEndpoint)
+		feeds.GET("/mood.atom", atomFeedEndpoint(baseUrl))
 	}
 
 	v1Authenticated := r.Group("/v1/api")
@@ -594,14 +1389,53 @@ func CreateRouter(cfg *config.Config, db *db.Database) *gin.Engine {
 	{
 		v1Authenticated.GET("/mood_playlists", getMoodPlaylistsEndpoint)
 		v1Authenticated.GET("/mood_playlist/:date", getMoodPlaylistEndpoint)
+		v1Authenticated.PATCH("/mood_playlist/:date", updateNoteEndpoint)
+		v1Authenticated.PUT("/mood_playlist/:date/tags", setTagsEndpoint)
+		v1Authenticated.GET("/mood_playlist/:date/export", exportMoodPlaylistEndpoint)
+		v1Authenticated.POST("/mood_playlist/:date/shares", createShareLinkEndpoint(baseUrl))
 		v1Authenticated.GET("/removed_tracks", getRemovedTracksEndpoint)
 		v1Authenticated.GET("/spotify_playlist", getSpotifyPlaylistEndpoint)
 		v1Authenticated.GET("/all_data", getAllData)
-		v1Authenticated.POST("/generate_mood_playlist", generateMoodPlaylistEndpoint)
-		v1Authenticated.POST("/update_playlist/:playlist_id", updateTuneSpotifyPlaylistEndpoint)
+		v1Authenticated.GET("/export", exportEndpoint)
+		v1Authenticated.POST("/import", importEndpoint)
+		v1Authenticated.POST("/generate_mood_playlist", requireFeature(api.FeatureGenerate), generateMoodPlaylistEndpoint)
+		v1Authenticated.POST("/update_playlist/:playlist_id", requireFeature(api.FeatureSync), updateTuneSpotifyPlaylistEndpoint)
 		v1Authenticated.POST("/remove_track/:track_id", removeTrackEndpoint)
 		v1Authenticated.POST("/unremove_track/:track_id", unremoveTrackEndpoint)
-		v1Authenticated.DELETE("/remove_all_user_data", removeAllUserData)
+		v1Authenticated.DELETE("/remove_all_user_data", sessionOnly, removeAllUserData)
+		v1Authenticated.GET("/scopes", getScopesEndpoint)
+		v1Authenticated.GET("/stats/mood", getMoodStatsEndpoint)
+		v1Authenticated.GET("/library/report", getLibraryReportEndpoint)
+		v1Authenticated.GET("/search", searchNotesEndpoint)
+		v1Authenticated.GET("/tags", getTagsEndpoint)
+		v1Authenticated.PATCH("/tags/:tag", renameTagEndpoint)
+		v1Authenticated.DELETE("/tags/:tag", deleteTagEndpoint)
+		v1Authenticated.GET("/shares", getShareLinksEndpoint)
+		v1Authenticated.DELETE("/shares/:share_id", revokeShareLinkEndpoint)
+		v1Authenticated.GET("/groups", getGroupsEndpoint)
+		v1Authenticated.POST("/groups", createGroupEndpoint)
+		v1Authenticated.POST("/groups/join", sessionOnly, joinGroupEndpoint)
+		v1Authenticated.GET("/groups/:group_id", getGroupEndpoint)
+		v1Authenticated.POST("/groups/:group_id/invite", createGroupInviteEndpoint)
+		v1Authenticated.PUT("/groups/:group_id/consent", sessionOnly, setGroupConsentEndpoint)
+		v1Authenticated.DELETE("/groups/:group_id/members/:user_id", removeGroupMemberEndpoint)
+		v1Authenticated.POST("/groups/:group_id/playlists", requireFeature(api.FeatureGenerate), generateGroupPlaylistEndpoint)
+		v1Authenticated.GET("/groups/:group_id/playlists/:date", getGroupPlaylistEndpoint)
+		v1Authenticated.GET("/sessions", sessionOnly, getSessionsEndpoint)
+		v1Authenticated.DELETE("/sessions", sessionOnly, revokeAllSessionsEndpoint)
+		v1Authenticated.DELETE("/sessions/:session_id", sessionOnly, revokeSessionEndpoint)
+		v1Authenticated.GET("/tokens", sessionOnly, getAccessTokensEndpoint)
+		v1Authenticated.POST("/tokens", sessionOnly, createAccessTokenEndpoint)
+		v1Authenticated.DELETE("/tokens/:token_id", sessionOnly, revokeAccessTokenEndpoint)
+	}
+
+	if cfg.AdminToken != "" {
+		admin := r.Group("/admin")
+		admin.Use(adminMiddleware(cfg.AdminToken))
+		{
+			admin.GET("/backup", backupEndpoint)
+			admin.POST("/restore", restoreEndpoint(cfg.BackupDir))
+		}
 	}
 
 	_, err := os.Stat(cfg.WebsiteFilesPath)
@@ -0,0 +1,745 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sardap/TuneNeutral/backend/pkg/api"
+	"github.com/sardap/TuneNeutral/backend/pkg/config"
+	"github.com/sardap/TuneNeutral/backend/pkg/db"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	userId       = "paul"
+	clientId     = "client"
+	clientSecret = "secret"
+	adminToken   = "admin"
+)
+
+// fakeSpotify plays Spotify's authorization server and the one web API
+// endpoint the login flow needs.
+type fakeSpotify struct {
+	mu     sync.Mutex
+	next   int
+	grants map[string]fakeGrant
+}
+
+type fakeGrant struct {
+	challenge string
+	scope     string
+}
+
+func (f *fakeSpotify) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/authorize":
+		f.authorize(w, r)
+	case "/api/token":
+		f.token(w, r)
+	case "/v1/me":
+		if r.Header.Get("Authorization") != "Bearer access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": userId})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeSpotify) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != clientId ||
+		query.Get("response_type") != "code" ||
+		query.Get("code_challenge_method") != "S256" ||
+		query.Get("code_challenge") == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	f.next++
+	code := fmt.Sprintf("code-%d", f.next)
+	f.grants[code] = fakeGrant{
+		challenge: query.Get("code_challenge"),
+		scope:     query.Get("scope"),
+	}
+	f.mu.Unlock()
+
+	redirect, _ := url.Parse(query.Get("redirect_uri"))
+	redirect.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (f *fakeSpotify) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	f.mu.Lock()
+	grant, ok := f.grants[r.Form.Get("code")]
+	// Codes are single use
+	delete(f.grants, r.Form.Get("code"))
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ok || r.Form.Get("grant_type") != "authorization_code" ||
+		codeChallenge(r.Form.Get("code_verifier")) != grant.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": "invalid_grant"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  "access",
+		"refresh_token": "refresh",
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+		"scope":         grant.scope,
+	})
+}
+
+// rewriteTransport sends every request to target whatever host it was for.
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (t *rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = t.target.Scheme
+	r.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// fakeSpotifyClient sends every Spotify request made by the test routers to
+// the fake Spotify server.
+var fakeSpotifyClient *http.Client
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+
+	server := httptest.NewServer(&fakeSpotify{grants: map[string]fakeGrant{}})
+	target, _ := url.Parse(server.URL)
+	fakeSpotifyClient = &http.Client{Transport: &rewriteTransport{target: target}}
+
+	code := m.Run()
+	server.Close()
+	os.Exit(code)
+}
+
+func newDatabase(t *testing.T) *db.Database {
+	cfg := &config.Config{
+		DatabasePath: path.Join(t.TempDir(), "database"),
+	}
+
+	return db.ConnectDb(cfg)
+}
+
+func newTestRouter(t *testing.T, dbConn *db.Database) *gin.Engine {
+	return CreateRouter(&config.Config{
+		ClientId:          clientId,
+		ClientSecret:      clientSecret,
+		Scheme:            "https",
+		Domain:            "tune.example",
+		WebsiteFilesPath:  t.TempDir(),
+		AdminToken:        adminToken,
+		SpotifyHTTPClient: fakeSpotifyClient,
+		CookieSecrets: []config.CookieSecret{
+			{Auth: "0123456789abcdef0123456789abcdef", Encrypt: "abcdef0123456789abcdef0123456789"},
+		},
+	}, dbConn)
+}
+
+// browser sends requests to the router keeping cookies between them.
+type browser struct {
+	router  *gin.Engine
+	cookies map[string]*http.Cookie
+}
+
+func newBrowser(router *gin.Engine) *browser {
+	return &browser{router: router, cookies: map[string]*http.Cookie{}}
+}
+
+func (b *browser) get(target string) *httptest.ResponseRecorder {
+	return b.do(http.MethodGet, target)
+}
+
+func (b *browser) do(method, target string) *httptest.ResponseRecorder {
+	return b.send(httptest.NewRequest(method, target, nil))
+}
+
+func (b *browser) send(r *http.Request) *httptest.ResponseRecorder {
+	for _, cookie := range b.cookies {
+		r.AddCookie(cookie)
+	}
+
+	w := httptest.NewRecorder()
+	b.router.ServeHTTP(w, r)
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			delete(b.cookies, cookie.Name)
+		} else {
+			b.cookies[cookie.Name] = cookie
+		}
+	}
+
+	return w
+}
+
+func (b *browser) authenticated() bool {
+	return b.get("/v1/api/authenticated").Code == http.StatusOK
+}
+
+// startLogin begins a login enabling features and lets the fake authorization
+// server approve it. Returns the callback url the authorization server sent
+// the browser back to.
+func startLogin(t *testing.T, b *browser, features ...string) *url.URL {
+	query := url.Values{"terms_and_conditions": {"true"}, "feature": features}
+	w := b.get("/auth?" + query.Encode())
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+
+	client := &http.Client{
+		Transport: fakeSpotifyClient.Transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(w.Header().Get("Location"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/callback", callback.Path)
+
+	return callback
+}
+
+func TestCodeChallenge(t *testing.T) {
+	t.Parallel()
+
+	// RFC 7636 appendix B
+	assert.Equal(t,
+		"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		codeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"),
+	)
+
+	verifier, err := newCodeVerifier()
+	assert.NoError(t, err)
+	assert.Len(t, verifier, 43)
+}
+
+func TestLogin(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	b := newBrowser(newTestRouter(t, dbConn))
+
+	// Run
+	callback := startLogin(t, b)
+	w := b.get(callback.RequestURI())
+
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Equal(t, "/", w.Header().Get("Location"))
+	assert.True(t, b.authenticated())
+
+	sessions, err := api.ListSessions(dbConn, userId)
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 1)
+
+	// The state was used up by the login
+	w = b.get(callback.RequestURI())
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCallbackRejected(t *testing.T) {
+	t.Parallel()
+
+	type scenario struct {
+		name     string
+		callback func(t *testing.T, b *browser, router *gin.Engine) string
+	}
+	scenarios := []scenario{
+		{
+			name: "no session",
+			callback: func(t *testing.T, b *browser, router *gin.Engine) string {
+				return startLogin(t, newBrowser(router)).RequestURI()
+			},
+		},
+		{
+			name: "unknown state",
+			callback: func(t *testing.T, b *browser, router *gin.Engine) string {
+				callback := startLogin(t, b)
+				query := callback.Query()
+				query.Set("state", "made up")
+				callback.RawQuery = query.Encode()
+				return callback.RequestURI()
+			},
+		},
+		{
+			name: "another session's state",
+			callback: func(t *testing.T, b *browser, router *gin.Engine) string {
+				startLogin(t, b)
+				return startLogin(t, newBrowser(router)).RequestURI()
+			},
+		},
+		{
+			name: "code from another login",
+			callback: func(t *testing.T, b *browser, router *gin.Engine) string {
+				other := startLogin(t, newBrowser(router))
+				callback := startLogin(t, b)
+				query := callback.Query()
+				query.Set("code", other.Query().Get("code"))
+				callback.RawQuery = query.Encode()
+				return callback.RequestURI()
+			},
+		},
+		{
+			name: "missing code",
+			callback: func(t *testing.T, b *browser, router *gin.Engine) string {
+				callback := startLogin(t, b)
+				query := callback.Query()
+				query.Del("code")
+				callback.RawQuery = query.Encode()
+				return callback.RequestURI()
+			},
+		},
+		{
+			name: "access denied",
+			callback: func(t *testing.T, b *browser, router *gin.Engine) string {
+				callback := startLogin(t, b)
+				return fmt.Sprintf("/callback?error=access_denied&state=%s", callback.Query().Get("state"))
+			},
+		},
+	}
+	for _, scenario := range scenarios {
+		scenario := scenario
+		t.Run(scenario.name, func(t *testing.T) {
+			t.Parallel()
+
+			// setup
+			dbConn := newDatabase(t)
+			defer dbConn.Close()
+			router := newTestRouter(t, dbConn)
+			b := newBrowser(router)
+
+			// Run
+			w := b.get(scenario.callback(t, b, router))
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.False(t, b.authenticated())
+
+			sessions, err := api.ListSessions(dbConn, userId)
+			assert.NoError(t, err)
+			assert.Len(t, sessions, 0)
+		})
+	}
+}
+
+func grantedFeatures(t *testing.T, b *browser) []api.Feature {
+	w := b.get("/v1/api/scopes")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Result getScopesResponse `json:"result"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+
+	return response.Result.Features
+}
+
+func TestIncrementalConsent(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	router := newTestRouter(t, dbConn)
+	b := newBrowser(router)
+
+	// Run
+	b.get(startLogin(t, b, string(api.FeatureGenerate)).RequestURI())
+	assert.True(t, b.authenticated())
+	assert.Equal(t, []api.Feature{api.FeatureJournal, api.FeatureGenerate}, grantedFeatures(t, b))
+
+	// Syncing needs more than was granted
+	w := b.do(http.MethodPost, "/v1/api/update_playlist/2021-01-01")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	forbidden := decodeError(t, w)
+	assert.Equal(t, api.CodeForbidden, forbidden.Code)
+	assert.Equal(t, string(api.FeatureSync), forbidden.Meta["feature"])
+
+	// Enabling sync keeps generate
+	authUrl, _ := url.Parse(forbidden.Meta["auth_url"])
+	b.get(startLogin(t, b, authUrl.Query()["feature"]...).RequestURI())
+	assert.Equal(t,
+		[]api.Feature{api.FeatureJournal, api.FeatureGenerate, api.FeatureSync},
+		grantedFeatures(t, b),
+	)
+
+	// Unknown features are refused before going to Spotify
+	w = b.get("/auth?terms_and_conditions=true&feature=mind_reading")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestLegacyUserScopes(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+
+	// Run
+	granted, err := api.GrantedScopes(dbConn, userId)
+	assert.NoError(t, err)
+	assert.Equal(t, api.Features, api.EnabledFeatures(granted))
+
+	// A fresh login records exactly what was granted
+	b := newBrowser(newTestRouter(t, dbConn))
+	b.get(startLogin(t, b).RequestURI())
+	assert.Equal(t, []api.Feature{api.FeatureJournal}, grantedFeatures(t, b))
+
+	// Enabling a feature only asks a legacy user for that feature's scopes
+	assert.NoError(t, dbConn.ClearUserScopes(userId))
+	b.get(startLogin(t, b, string(api.FeatureGenerate)).RequestURI())
+	assert.Equal(t, []api.Feature{api.FeatureJournal, api.FeatureGenerate}, grantedFeatures(t, b))
+	granted, err = api.GrantedScopes(dbConn, userId)
+	assert.NoError(t, err)
+	assert.Equal(t, api.FeatureScopes(api.FeatureGenerate), granted)
+}
+
+// withToken sends a request authenticated with an access token instead of a
+// session.
+func withToken(router *gin.Engine, method, target, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	r.Header.Set("Authorization", token)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func createAccessToken(t *testing.T, b *browser, name string, scopes ...string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(createAccessTokenRequest{Name: name, Scopes: scopes})
+	r := httptest.NewRequest(http.MethodPost, "/v1/api/tokens", bytes.NewReader(body))
+	return b.send(r)
+}
+
+func TestAccessTokens(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	router := newTestRouter(t, dbConn)
+	b := newBrowser(router)
+	b.get(startLogin(t, b, string(api.FeatureGenerate)).RequestURI())
+
+	// Run
+	w := createAccessToken(t, b, "shortcut", api.TokenScopeRead)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var created struct {
+		Result createAccessTokenResponse `json:"result"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	raw := created.Result.Token
+	assert.Regexp(t, "^tn_[0-9a-f]{64}$", raw)
+	bearer := "Bearer " + raw
+
+	// Only the hash is stored
+	_, err := dbConn.GetAccessToken(raw)
+	assert.Error(t, err)
+
+	assert.Equal(t, http.StatusOK, withToken(router, http.MethodGet, "/v1/api/scopes", bearer).Code)
+	assert.Equal(t, http.StatusForbidden, withToken(router, http.MethodPost, "/v1/api/remove_track/a", bearer).Code)
+	// Tokens can't manage tokens or sessions
+	assert.Equal(t, http.StatusForbidden, withToken(router, http.MethodGet, "/v1/api/tokens", bearer).Code)
+	assert.Equal(t, http.StatusForbidden, withToken(router, http.MethodDelete, "/v1/api/sessions", bearer).Code)
+
+	w = b.get("/v1/api/tokens")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), raw)
+	var listed struct {
+		Result getAccessTokensResponse `json:"result"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&listed))
+	assert.Len(t, listed.Result.Tokens, 1)
+	assert.Equal(t, "shortcut", listed.Result.Tokens[0].Name)
+	assert.NotNil(t, listed.Result.Tokens[0].LastUsed)
+
+	w = b.do(http.MethodDelete, "/v1/api/tokens/"+created.Result.Id)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusUnauthorized, withToken(router, http.MethodGet, "/v1/api/scopes", bearer).Code)
+	w = b.do(http.MethodDelete, "/v1/api/tokens/"+created.Result.Id)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// The session is unaffected by token failures
+	assert.True(t, b.authenticated())
+}
+
+func TestAccessTokensRejected(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	router := newTestRouter(t, dbConn)
+	b := newBrowser(router)
+	b.get(startLogin(t, b).RequestURI())
+
+	type scenario struct {
+		name   string
+		scopes []string
+	}
+	scenarios := []scenario{
+		{name: "", scopes: []string{api.TokenScopeRead}},
+		{name: string(make([]byte, 65)), scopes: []string{api.TokenScopeRead}},
+		{name: "no scopes"},
+		{name: "admin", scopes: []string{"admin"}},
+	}
+	for _, scenario := range scenarios {
+		w := createAccessToken(t, b, scenario.name, scenario.scopes...)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+
+	for _, header := range []string{"Bearer made up", "Bearer tn_00", "Basic dXNlcjpwYXNz"} {
+		w := withToken(router, http.MethodGet, "/v1/api/scopes", header)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestFeedTokens(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	router := newTestRouter(t, dbConn)
+	b := newBrowser(router)
+	b.get(startLogin(t, b).RequestURI())
+	note := "rainy"
+	assert.NoError(t, dbConn.SetMoodPlaylist(userId, &models.MoodPlaylist{
+		Date:      time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		StartMood: 0.25,
+		Note:      &note,
+	}))
+
+	w := createAccessToken(t, b, "calendar", api.TokenScopeFeed)
+	var created struct {
+		Result createAccessTokenResponse `json:"result"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+
+	// Run
+	w = newBrowser(router).get("/v1/feeds/mood.ics?token=" + created.Result.Token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/calendar; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "DTSTART;VALUE=DATE:20210101\r\n")
+	assert.Contains(t, w.Body.String(), "DESCRIPTION:rainy\r\n")
+
+	w = newBrowser(router).get("/v1/feeds/mood.atom?token=" + created.Result.Token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<id>https://tune.example/v1/feeds/mood.atom</id>")
+	assert.NotContains(t, w.Body.String(), created.Result.Token)
+
+	// Feed tokens can't use the API
+	w = withToken(router, http.MethodGet, "/v1/api/mood_playlists", "Bearer "+created.Result.Token)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = newBrowser(router).get("/v1/feeds/mood.ics?token=tn_00")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestShareLinks(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	router := newTestRouter(t, dbConn)
+	b := newBrowser(router)
+	b.get(startLogin(t, b).RequestURI())
+	assert.NoError(t, dbConn.PutTrack(&models.Track{Id: "a", Name: "A", Valence: 0.2}))
+	note := "rainy"
+	assert.NoError(t, dbConn.SetMoodPlaylist(userId, &models.MoodPlaylist{
+		Date:      time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		StartMood: 0.25,
+		Note:      &note,
+		Tags:      []string{"private"},
+		Tracks:    []string{"a"},
+	}))
+
+	share := func(body string) createShareLinkResponse {
+		r := httptest.NewRequest(http.MethodPost, "/v1/api/mood_playlist/2021-01-01/shares", strings.NewReader(body))
+		w := b.send(r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var created struct {
+			Result createShareLinkResponse `json:"result"`
+		}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+		return created.Result
+	}
+	open := func(token string) (int, sharedPlaylistResponse) {
+		w := newBrowser(router).get("/v1/api/shared/" + token)
+		var shared struct {
+			Result sharedPlaylistResponse `json:"result"`
+		}
+		json.NewDecoder(w.Body).Decode(&shared)
+		return w.Code, shared.Result
+	}
+
+	// Run
+	visible := share(`{}`)
+	assert.Equal(t, "https://tune.example/#/shared/"+visible.Token, visible.Url)
+	assert.Nil(t, visible.ExpiresAt)
+	hidden := share(`{"hide_note": true, "expires_in_days": 7}`)
+	assert.NotNil(t, hidden.ExpiresAt)
+
+	code, shared := open(visible.Token)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "2021-01-01", shared.Date)
+	assert.Equal(t, &note, shared.Note)
+	assert.Len(t, shared.Tracks, 1)
+	assert.Equal(t, "A", shared.Tracks[0].Name)
+	code, shared = open(hidden.Token)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Nil(t, shared.Note)
+	open(hidden.Token)
+
+	w := b.get("/v1/api/shares?date=2021-01-01")
+	var listed struct {
+		Result getShareLinksResponse `json:"result"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&listed))
+	assert.Len(t, listed.Result.Shares, 2)
+	assert.Equal(t, hidden.Id, listed.Result.Shares[0].Id)
+	assert.Equal(t, 2, listed.Result.Shares[0].Accesses)
+	assert.NotNil(t, listed.Result.Shares[0].LastAccessed)
+
+	w = b.do(http.MethodDelete, "/v1/api/shares/"+visible.Id)
+	assert.Equal(t, http.StatusOK, w.Code)
+	code, _ = open(visible.Token)
+	assert.Equal(t, http.StatusNotFound, code)
+	// Access tokens aren't share tokens
+	w = createAccessToken(t, b, "script", api.TokenScopeRead)
+	var created struct {
+		Result createAccessTokenResponse `json:"result"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	code, _ = open(created.Result.Token)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func decodeError(t *testing.T, w *httptest.ResponseRecorder) api.Error {
+	var response struct {
+		Error api.Error `json:"error"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	return response.Error
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	router := newTestRouter(t, dbConn)
+	b := newBrowser(router)
+	b.get(startLogin(t, b, string(api.FeatureGenerate)).RequestURI())
+
+	w := createAccessToken(t, b, "read only", api.TokenScopeRead)
+	var created struct {
+		Result createAccessTokenResponse `json:"result"`
+	}
+	json.NewDecoder(w.Body).Decode(&created)
+
+	type scenario struct {
+		method         string
+		target         string
+		body           string
+		authorization  string
+		loggedOut      bool
+		expectedStatus int
+		expectedCode   api.ErrorCode
+		expectedField  string
+	}
+	scenarios := []scenario{
+		{method: http.MethodGet, target: "/auth", expectedStatus: http.StatusBadRequest, expectedCode: api.CodeInvalid, expectedField: "terms_and_conditions"},
+		{method: http.MethodGet, target: "/auth?terms_and_conditions=true&feature=nope", expectedStatus: http.StatusBadRequest, expectedCode: api.CodeInvalid, expectedField: "feature"},
+		{method: http.MethodGet, target: "/callback", expectedStatus: http.StatusBadRequest, expectedCode: api.CodeBadRequest},
+		{method: http.MethodGet, target: "/callback?state=nope&code=nope", expectedStatus: http.StatusBadRequest, expectedCode: api.CodeBadRequest},
+		{method: http.MethodGet, target: "/v1/api/authenticated", loggedOut: true, expectedStatus: http.StatusUnauthorized, expectedCode: api.CodeUnauthorized},
+		{method: http.MethodGet, target: "/v1/api/mood_playlists", loggedOut: true, expectedStatus: http.StatusUnauthorized, expectedCode: api.CodeUnauthorized},
+		{method: http.MethodGet, target: "/v1/api/mood_playlists?limit=0", expectedStatus: http.StatusBadRequest, expectedCode: api.CodeInvalid, expectedField: "limit"},
+		{method: http.MethodGet, target: "/v1/api/mood_playlist/2021-01-01", expectedStatus: http.StatusNotFound, expectedCode: api.CodeNotFound},
+		{method: http.MethodGet, target: "/v1/api/removed_tracks", expectedStatus: http.StatusNotFound, expectedCode: api.CodeNotFound},
+		{method: http.MethodGet, target: "/v1/api/spotify_playlist", loggedOut: true, expectedStatus: http.StatusUnauthorized, expectedCode: api.CodeUnauthorized},
+		{method: http.MethodGet, target: "/v1/api/all_data", loggedOut: true, expectedStatus: http.StatusUnauthorized, expectedCode: api.CodeUnauthorized},
+		{method: http.MethodPost, target: "/v1/api/generate_mood_playlist", body: `{"mood": 0.1, "date": "yesterday"}`, expectedStatus: http.StatusBadRequest, expectedCode: api.CodeInvalid, expectedField: "date"},
+		{method: http.MethodPost, target: "/v1/api/generate_mood_playlist", body: `{"mood": "happy"}`, expectedStatus: http.StatusBadRequest, expectedCode: api.CodeInvalid, expectedField: "mood"},
+		{method: http.MethodPost, target: "/v1/api/generate_mood_playlist", body: `{"mood": 0.1`, expectedStatus: http.StatusBadRequest, expectedCode: api.CodeBadRequest},
+		{method: http.MethodPost, target: "/v1/api/update_playlist/2021-01-01", expectedStatus: http.StatusForbidden, expectedCode: api.CodeForbidden},
+		{method: http.MethodPost, target: "/v1/api/remove_track/a", expectedStatus: http.StatusNotFound, expectedCode: api.CodeNotFound},
+		{method: http.MethodPost, target: "/v1/api/unremove_track/a", expectedStatus: http.StatusNotFound, expectedCode: api.CodeNotFound},
+		{method: http.MethodDelete, target: "/v1/api/remove_all_user_data", loggedOut: true, expectedStatus: http.StatusUnauthorized, expectedCode: api.CodeUnauthorized},
+		{method: http.MethodDelete, target: "/v1/api/remove_all_user_data", authorization: "Bearer " + created.Result.Token, expectedStatus: http.StatusForbidden, expectedCode: api.CodeForbidden},
+		{method: http.MethodGet, target: "/v1/api/scopes", loggedOut: true, expectedStatus: http.StatusUnauthorized, expectedCode: api.CodeUnauthorized},
+		{method: http.MethodGet, target: "/v1/api/stats/mood?window=91", expectedStatus: http.StatusBadRequest, expectedCode: api.CodeInvalid, expectedField: "window"},
+		{method: http.MethodGet, target: "/v1/api/library/report", expectedStatus: http.StatusNotFound, expectedCode: api.CodeNotFound},
+		{method: http.MethodGet, target: "/v1/api/search?q=%22%22", expectedStatus: http.StatusBadRequest, expectedCode: api.CodeInvalid, expectedField: "q"},
+		{method: http.MethodPatch, target: "/v1/api/mood_playlist/2021-01-01", body: `{"note": "hi"}`, expectedStatus: http.StatusNotFound, expectedCode: api.CodeNotFound},
+		{method: http.MethodPatch, target: "/v1/api/mood_playlist/2021-01-01", body: `{}`, expectedStatus: http.StatusBadRequest, expectedCode: api.CodeInvalid, expectedField: "note"},
+		{method: http.MethodGet, target: "/v1/api/sessions", authorization: "Bearer " + created.Result.Token, expectedStatus: http.StatusForbidden, expectedCode: api.CodeForbidden},
+		{method: http.MethodDelete, target: "/v1/api/sessions", loggedOut: true, expectedStatus: http.StatusUnauthorized, expectedCode: api.CodeUnauthorized},
+		{method: http.MethodDelete, target: "/v1/api/sessions/nope", expectedStatus: http.StatusNotFound, expectedCode: api.CodeNotFound},
+		{method: http.MethodGet, target: "/v1/api/tokens", loggedOut: true, expectedStatus: http.StatusUnauthorized, expectedCode: api.CodeUnauthorized},
+		{method: http.MethodPost, target: "/v1/api/tokens", body: "not json", expectedStatus: http.StatusBadRequest, expectedCode: api.CodeBadRequest},
+		{method: http.MethodPost, target: "/v1/api/tokens", body: `{"scopes": ["read"]}`, expectedStatus: http.StatusBadRequest, expectedCode: api.CodeInvalid, expectedField: "name"},
+		{method: http.MethodDelete, target: "/v1/api/tokens/nope", expectedStatus: http.StatusNotFound, expectedCode: api.CodeNotFound},
+		{method: http.MethodPost, target: "/v1/api/remove_track/a", authorization: "Bearer " + created.Result.Token, expectedStatus: http.StatusForbidden, expectedCode: api.CodeForbidden},
+		{method: http.MethodGet, target: "/v1/api/scopes", authorization: "Bearer tn_nope", expectedStatus: http.StatusUnauthorized, expectedCode: api.CodeUnauthorized},
+		{method: http.MethodGet, target: "/admin/backup", expectedStatus: http.StatusUnauthorized, expectedCode: api.CodeUnauthorized},
+		{method: http.MethodPost, target: "/admin/restore", body: "not a backup", authorization: "Bearer " + adminToken, expectedStatus: http.StatusBadRequest, expectedCode: api.CodeBadRequest},
+	}
+	for _, scenario := range scenarios {
+		r := httptest.NewRequest(scenario.method, scenario.target, bytes.NewReader([]byte(scenario.body)))
+		if scenario.authorization != "" {
+			r.Header.Set("Authorization", scenario.authorization)
+		}
+
+		// Run
+		var w *httptest.ResponseRecorder
+		if scenario.loggedOut || scenario.authorization != "" {
+			w = newBrowser(router).send(r)
+		} else {
+			w = b.send(r)
+		}
+
+		name := fmt.Sprintf("%s %s", scenario.method, scenario.target)
+		assert.Equal(t, scenario.expectedStatus, w.Code, name)
+		apiErr := decodeError(t, w)
+		assert.Equal(t, scenario.expectedCode, apiErr.Code, name)
+		assert.NotEmpty(t, apiErr.Message, name)
+		if scenario.expectedField != "" {
+			assert.Len(t, apiErr.Details, 1, name)
+			assert.Equal(t, scenario.expectedField, apiErr.Details[0].Field, name)
+		}
+	}
+
+	// Client errors never log the browser out
+	assert.True(t, b.authenticated())
+}
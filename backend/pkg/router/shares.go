@@ -0,0 +1,165 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sardap/TuneNeutral/backend/pkg/api"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+)
+
+type basicShareLink struct {
+	Id           string  `json:"id"`
+	Date         string  `json:"date"`
+	HideNote     bool    `json:"hide_note"`
+	CreatedAt    string  `json:"created_at"`
+	ExpiresAt    *string `json:"expires_at"`
+	Accesses     int     `json:"accesses"`
+	LastAccessed *string `json:"last_accessed"`
+}
+
+func toBasicShareLink(link *models.ShareLink) basicShareLink {
+	result := basicShareLink{
+		Id:        link.Id,
+		Date:      link.Date,
+		HideNote:  link.HideNote,
+		CreatedAt: link.CreatedAt.Format(time.RFC3339),
+		Accesses:  link.Accesses,
+	}
+	if !link.ExpiresAt.IsZero() {
+		expiresAt := link.ExpiresAt.Format(time.RFC3339)
+		result.ExpiresAt = &expiresAt
+	}
+	if !link.LastAccessed.IsZero() {
+		lastAccessed := link.LastAccessed.Format(time.RFC3339)
+		result.LastAccessed = &lastAccessed
+	}
+	return result
+}
+
+type getShareLinksResponse struct {
+	Shares []basicShareLink `json:"shares"`
+}
+
+func getShareLinksEndpoint(c *gin.Context) {
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	links, err := api.ListShareLinks(getDatabase(c), userId, c.Query("date"))
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	response := getShareLinksResponse{Shares: []basicShareLink{}}
+	for _, link := range links {
+		response.Shares = append(response.Shares, toBasicShareLink(link))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": response,
+	})
+}
+
+type createShareLinkRequest struct {
+	HideNote bool `json:"hide_note"`
+	// ExpiresInDays is nil for a link that never expires
+	ExpiresInDays *int `json:"expires_in_days"`
+}
+
+type createShareLinkResponse struct {
+	basicShareLink
+	// Token is the secret, it is only ever shown here
+	Token string `json:"token"`
+	Url   string `json:"url"`
+}
+
+// createShareLinkEndpoint shares a mood playlist, baseUrl is where the
+// website is reached and starts the returned link.
+func createShareLinkEndpoint(baseUrl string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request createShareLinkRequest
+		if err := decodeJSONBody(c, &request); err != nil {
+			processApiError(c, err)
+			return
+		}
+
+		userId, _, err := getUser(c)
+		if err != nil {
+			processApiError(c, err)
+			return
+		}
+
+		raw, link, err := api.CreateShareLink(getDatabase(c), userId, c.Param("date"), request.HideNote, request.ExpiresInDays)
+		if err != nil {
+			processApiError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"result": createShareLinkResponse{
+				basicShareLink: toBasicShareLink(link),
+				Token:          raw,
+				Url:            fmt.Sprintf("%s/#/shared/%s", baseUrl, raw),
+			},
+		})
+	}
+}
+
+func revokeShareLinkEndpoint(c *gin.Context) {
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	err = api.RevokeShareLink(getDatabase(c), userId, c.Param("share_id"))
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": "success",
+	})
+}
+
+type sharedPlaylistResponse struct {
+	Date      string       `json:"date"`
+	StartMood float32      `json:"start_mood"`
+	Note      *string      `json:"note"`
+	Tracks    []basicTrack `json:"tracks"`
+	ExpiresAt *string      `json:"expires_at"`
+}
+
+// sharedPlaylistEndpoint is the public view of a shared mood playlist. Tags
+// and the owner are never shown, the note only when the link allows it.
+func sharedPlaylistEndpoint(c *gin.Context) {
+	db := getDatabase(c)
+	link, playlist, err := api.OpenShareLink(db, c.Param("token"), time.Now())
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	tracks, _, err := hydrateTracks(db, playlist.Tracks)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": sharedPlaylistResponse{
+			Date:      link.Date,
+			StartMood: playlist.StartMood,
+			Note:      playlist.Note,
+			Tracks:    tracks,
+			ExpiresAt: toBasicShareLink(link).ExpiresAt,
+		},
+	})
+}
@@ -0,0 +1,112 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sardap/TuneNeutral/backend/pkg/api"
+)
+
+type setTagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+func setTagsEndpoint(c *gin.Context) {
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	var request setTagsRequest
+	if err := decodeJSONBody(c, &request); err != nil {
+		processApiError(c, err)
+		return
+	}
+	if request.Tags == nil {
+		processApiError(c, api.Invalid(api.FieldError{Field: "tags", Message: "is required"}))
+		return
+	}
+
+	playlist, err := api.SetPlaylistTags(getDatabase(c), userId, c.Param("date"), request.Tags)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": toBasicPlaylist(playlist),
+	})
+}
+
+type getTagsResponse struct {
+	Tags []api.TagSummary `json:"tags"`
+}
+
+func getTagsEndpoint(c *gin.Context) {
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	tags, err := api.ListTags(getDatabase(c), userId)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": getTagsResponse{Tags: tags},
+	})
+}
+
+type renameTagRequest struct {
+	Tag string `json:"tag"`
+}
+
+type changedTagResponse struct {
+	Changed int `json:"changed"`
+}
+
+func renameTagEndpoint(c *gin.Context) {
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	var request renameTagRequest
+	if err := decodeJSONBody(c, &request); err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	changed, err := api.RenameTag(getDatabase(c), userId, c.Param("tag"), request.Tag)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": changedTagResponse{Changed: changed},
+	})
+}
+
+func deleteTagEndpoint(c *gin.Context) {
+	userId, _, err := getUser(c)
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	changed, err := api.DeleteTag(getDatabase(c), userId, c.Param("tag"))
+	if err != nil {
+		processApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": changedTagResponse{Changed: changed},
+	})
+}
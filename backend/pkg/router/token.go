@@ -0,0 +1,55 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"golang.org/x/oauth2"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/api"
+)
+
+// persistingTokenSource refreshes an expired access token like any oauth2
+// token source, and hands every refreshed token to persist so the next request
+// starts from it instead of refreshing again.
+type persistingTokenSource struct {
+	mu      sync.Mutex
+	base    oauth2.TokenSource
+	current *oauth2.Token
+	persist func(*oauth2.Token) error
+}
+
+func newPersistingTokenSource(base oauth2.TokenSource, current *oauth2.Token, persist func(*oauth2.Token) error) *persistingTokenSource {
+	return &persistingTokenSource{
+		base:    base,
+		current: current,
+		persist: persist,
+	}
+}
+
+func (s *persistingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, err := s.base.Token()
+	if err != nil {
+		// A refresh token the server rejects, or no refresh token at all,
+		// can only be fixed by logging in again
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) || s.current.RefreshToken == "" {
+			return nil, api.ErrUnauthorized.WithCause(fmt.Errorf("refreshing token: %w", err))
+		}
+		return nil, err
+	}
+
+	if token.AccessToken != s.current.AccessToken {
+		s.current = token
+		if err := s.persist(token); err != nil {
+			log.Printf("Unable to persist refreshed token: %v", err)
+		}
+	}
+
+	return token, nil
+}
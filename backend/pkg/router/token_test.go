@@ -0,0 +1,106 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+func TestPersistingTokenSource(t *testing.T) {
+	t.Parallel()
+
+	type scenario struct {
+		token             *oauth2.Token
+		tokenStatus       int
+		expectedToken     string
+		expectedPersisted int
+		expectedErr       error
+	}
+	scenarios := []scenario{
+		{
+			token: &oauth2.Token{
+				AccessToken:  "still good",
+				RefreshToken: "refresh",
+				Expiry:       time.Now().Add(time.Hour),
+			},
+			expectedToken: "still good",
+		},
+		{
+			token: &oauth2.Token{
+				AccessToken:  "expired",
+				RefreshToken: "refresh",
+				Expiry:       time.Now().Add(-time.Hour),
+			},
+			tokenStatus:       http.StatusOK,
+			expectedToken:     "refreshed",
+			expectedPersisted: 1,
+		},
+		{
+			token: &oauth2.Token{
+				AccessToken:  "expired",
+				RefreshToken: "revoked",
+				Expiry:       time.Now().Add(-time.Hour),
+			},
+			tokenStatus: http.StatusBadRequest,
+			expectedErr: api.ErrUnauthorized,
+		},
+		{
+			token: &oauth2.Token{
+				AccessToken: "expired",
+				Expiry:      time.Now().Add(-time.Hour),
+			},
+			expectedErr: api.ErrUnauthorized,
+		},
+	}
+	for _, scenario := range scenarios {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(scenario.tokenStatus)
+			if scenario.tokenStatus == http.StatusOK {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"access_token":  "refreshed",
+					"refresh_token": "refresh",
+					"token_type":    "Bearer",
+					"expires_in":    3600,
+				})
+			} else {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"error": "invalid_grant",
+				})
+			}
+		}))
+
+		config := &oauth2.Config{
+			Endpoint: oauth2.Endpoint{TokenURL: server.URL},
+		}
+		persisted := 0
+		tokenSource := newPersistingTokenSource(
+			config.TokenSource(context.Background(), scenario.token),
+			scenario.token,
+			func(token *oauth2.Token) error {
+				persisted++
+				return nil
+			},
+		)
+
+		// Asking twice must only refresh and persist once
+		for i := 0; i < 2; i++ {
+			token, err := tokenSource.Token()
+
+			assert.ErrorIs(t, err, scenario.expectedErr)
+			if scenario.expectedErr == nil {
+				assert.Equal(t, scenario.expectedToken, token.AccessToken)
+			}
+		}
+		assert.Equal(t, scenario.expectedPersisted, persisted)
+
+		server.Close()
+	}
+}
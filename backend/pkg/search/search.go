@@ -0,0 +1,237 @@
+// Package search splits journal notes into terms for the note index and
+// matches queries against them.
+package search
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Token is one word of a text.
+type Token struct {
+	Term string
+	// Start and End are the byte offsets of the word in the text
+	Start int
+	End   int
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
+}
+
+// Tokenize splits text into lower cased words. Anything that isn't a letter
+// or a number separates words.
+func Tokenize(text string) []Token {
+	var result []Token
+	start := -1
+	for i, r := range text {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			result = append(result, Token{Term: strings.ToLower(text[start:i]), Start: start, End: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		result = append(result, Token{Term: strings.ToLower(text[start:]), Start: start, End: len(text)})
+	}
+	return result
+}
+
+// Terms maps every term in text to the positions of the words it appears as.
+func Terms(text string) map[string][]int {
+	result := map[string][]int{}
+	for i, token := range Tokenize(text) {
+		result[token.Term] = append(result[token.Term], i)
+	}
+	return result
+}
+
+// Query is a parsed search. A note matches when it has every term and every
+// phrase.
+type Query struct {
+	Terms []string
+	// Phrases must appear as consecutive words
+	Phrases [][]string
+}
+
+// ParseQuery reads a search. Words in double quotes are a phrase, an
+// unclosed quote runs to the end.
+func ParseQuery(q string) Query {
+	var result Query
+	addTerms := func(text string) {
+		for _, token := range Tokenize(text) {
+			result.Terms = append(result.Terms, token.Term)
+		}
+	}
+
+	for i, part := range strings.Split(q, `"`) {
+		// Odd parts are inside quotes
+		if i%2 == 0 {
+			addTerms(part)
+			continue
+		}
+
+		var phrase []string
+		for _, token := range Tokenize(part) {
+			phrase = append(phrase, token.Term)
+		}
+		if len(phrase) == 1 {
+			result.Terms = append(result.Terms, phrase[0])
+		} else if len(phrase) > 1 {
+			result.Phrases = append(result.Phrases, phrase)
+		}
+	}
+
+	return result
+}
+
+func (q Query) Empty() bool {
+	return len(q.Terms) == 0 && len(q.Phrases) == 0
+}
+
+// AllTerms is every distinct term a matching note must have, sorted.
+func (q Query) AllTerms() []string {
+	set := map[string]bool{}
+	for _, term := range q.Terms {
+		set[term] = true
+	}
+	for _, phrase := range q.Phrases {
+		for _, term := range phrase {
+			set[term] = true
+		}
+	}
+
+	result := make([]string, 0, len(set))
+	for term := range set {
+		result = append(result, term)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Matches reports whether a note whose terms are at positions matches q.
+func (q Query) Matches(positions map[string][]int) bool {
+	for _, term := range q.AllTerms() {
+		if len(positions[term]) == 0 {
+			return false
+		}
+	}
+
+	for _, phrase := range q.Phrases {
+		if !hasPhrase(positions, phrase) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func hasPhrase(positions map[string][]int, phrase []string) bool {
+	next := map[int]bool{}
+	for _, position := range positions[phrase[0]] {
+		next[position+1] = true
+	}
+
+	for _, term := range phrase[1:] {
+		found := map[int]bool{}
+		for _, position := range positions[term] {
+			if next[position] {
+				found[position+1] = true
+			}
+		}
+		if len(found) == 0 {
+			return false
+		}
+		next = found
+	}
+
+	return true
+}
+
+// Segment is a piece of a snippet, Match is set on the words the query
+// matched.
+type Segment struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match"`
+}
+
+const ellipsis = "…"
+
+// Snippet cuts about width bytes of text around the first word q matches and
+// splits it into segments so the matched words can be highlighted.
+func Snippet(text string, q Query, width int) []Segment {
+	tokens := Tokenize(text)
+	terms := map[string]bool{}
+	for _, term := range q.AllTerms() {
+		terms[term] = true
+	}
+
+	first := 0
+	for i, token := range tokens {
+		if terms[token.Term] {
+			first = i
+			break
+		}
+	}
+
+	start, end := 0, len(text)
+	if len(text) > width && len(tokens) > 0 {
+		// Lead in with up to a third of the width before the first match and
+		// fill the rest with whole words after it
+		start, end = tokens[first].Start, tokens[first].End
+		for i := first - 1; i >= 0 && tokens[first].Start-tokens[i].Start <= width/3; i-- {
+			start = tokens[i].Start
+		}
+		for i := first + 1; i < len(tokens) && tokens[i].End-start <= width; i++ {
+			end = tokens[i].End
+		}
+	} else if len(text) > width {
+		end = width
+		for end > 0 && !utf8.RuneStart(text[end]) {
+			end--
+		}
+	}
+
+	var result []Segment
+	add := func(segment string, match bool) {
+		if segment == "" {
+			return
+		}
+		if len(result) > 0 && result[len(result)-1].Match == match {
+			result[len(result)-1].Text += segment
+			return
+		}
+		result = append(result, Segment{Text: segment, Match: match})
+	}
+
+	if start > 0 {
+		add(ellipsis, false)
+	}
+	at := start
+	for _, token := range tokens {
+		if token.Start < start || token.End > end {
+			continue
+		}
+		if terms[token.Term] {
+			add(text[at:token.Start], false)
+			add(text[token.Start:token.End], true)
+			at = token.End
+		}
+	}
+	add(text[at:end], false)
+	if end < len(text) {
+		add(ellipsis, false)
+	}
+
+	if result == nil {
+		return []Segment{}
+	}
+	return result
+}
@@ -0,0 +1,126 @@
+package search_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/search"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenize(t *testing.T) {
+	t.Parallel()
+
+	// Run
+	tokens := search.Tokenize("Très tired, can't sleep!! 2am")
+
+	var terms []string
+	for _, token := range tokens {
+		terms = append(terms, token.Term)
+	}
+	assert.Equal(t, []string{"très", "tired", "can", "t", "sleep", "2am"}, terms)
+	assert.Equal(t, search.Token{Term: "tired", Start: 6, End: 11}, tokens[1])
+
+	assert.Equal(t, map[string][]int{"a": {0, 2}, "b": {1}}, search.Terms("A b a"))
+}
+
+func TestQueryMatches(t *testing.T) {
+	t.Parallel()
+
+	note := search.Terms("Woke up tired again. Long walk helped, felt less tired after")
+
+	type scenario struct {
+		q               string
+		expectedQuery   search.Query
+		expectedMatches bool
+	}
+	scenarios := []scenario{
+		{
+			q:               "Tired walk",
+			expectedQuery:   search.Query{Terms: []string{"tired", "walk"}},
+			expectedMatches: true,
+		},
+		{
+			q:               "tired rain",
+			expectedQuery:   search.Query{Terms: []string{"tired", "rain"}},
+			expectedMatches: false,
+		},
+		{
+			q:               `"long walk" helped`,
+			expectedQuery:   search.Query{Terms: []string{"helped"}, Phrases: [][]string{{"long", "walk"}}},
+			expectedMatches: true,
+		},
+		{
+			// Both words are there but not together
+			q:               `"tired walk"`,
+			expectedQuery:   search.Query{Phrases: [][]string{{"tired", "walk"}}},
+			expectedMatches: false,
+		},
+		{
+			q:               `"less tired after`,
+			expectedQuery:   search.Query{Phrases: [][]string{{"less", "tired", "after"}}},
+			expectedMatches: true,
+		},
+		{
+			q:               `"Tired"`,
+			expectedQuery:   search.Query{Terms: []string{"tired"}},
+			expectedMatches: true,
+		},
+	}
+	for _, scenario := range scenarios {
+		// Run
+		query := search.ParseQuery(scenario.q)
+
+		assert.Equal(t, scenario.expectedQuery, query, scenario.q)
+		assert.Equal(t, scenario.expectedMatches, query.Matches(note), scenario.q)
+	}
+
+	assert.True(t, search.ParseQuery(` "" !? `).Empty())
+}
+
+func TestSnippet(t *testing.T) {
+	t.Parallel()
+
+	type scenario struct {
+		text     string
+		q        string
+		width    int
+		expected []search.Segment
+	}
+	scenarios := []scenario{
+		{
+			text:  "Tired but the walk helped, tired",
+			q:     "tired",
+			width: 100,
+			expected: []search.Segment{
+				{Text: "Tired", Match: true},
+				{Text: " but the walk helped, ", Match: false},
+				{Text: "tired", Match: true},
+			},
+		},
+		{
+			text:  strings.Repeat("blah ", 20) + "sunny day at the beach " + strings.Repeat("blah ", 20),
+			q:     `"sunny day"`,
+			width: 30,
+			expected: []search.Segment{
+				{Text: "…blah blah ", Match: false},
+				{Text: "sunny", Match: true},
+				{Text: " ", Match: false},
+				{Text: "day", Match: true},
+				{Text: " at the…", Match: false},
+			},
+		},
+		{
+			text:     "",
+			q:        "anything",
+			width:    10,
+			expected: []search.Segment{},
+		},
+	}
+	for _, scenario := range scenarios {
+		// Run
+		snippet := search.Snippet(scenario.text, search.ParseQuery(scenario.q), scenario.width)
+
+		assert.Equal(t, scenario.expected, snippet, scenario.q)
+	}
+}
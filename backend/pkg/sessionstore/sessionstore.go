@@ -0,0 +1,208 @@
+package sessionstore
+
+import (
+	"encoding/base32"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+
+	"github.com/sardap/TuneNeutral/backend/pkg/db"
+	"github.com/sardap/TuneNeutral/backend/pkg/models"
+)
+
+const (
+	defaultMaxAge = 86400 * 30
+	touchInterval = time.Minute
+)
+
+// Store keeps session values in the database, the cookie only carries the
+// signed and encrypted session id. It implements sessions.Store.
+type Store struct {
+	db      *db.Database
+	codecs  []securecookie.Codec
+	options *gsessions.Options
+}
+
+// New creates a Store. keyPairs are securecookie hash and block key pairs used
+// to protect the session id cookie.
+func New(dbConn *db.Database, keyPairs ...[]byte) *Store {
+	store := &Store{
+		db:     dbConn,
+		codecs: securecookie.CodecsFromPairs(keyPairs...),
+		options: &gsessions.Options{
+			Path:     "/",
+			MaxAge:   defaultMaxAge,
+			HttpOnly: true,
+		},
+	}
+	store.maxAge(defaultMaxAge)
+
+	return store
+}
+
+func (s *Store) maxAge(age int) {
+	for _, codec := range s.codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(age)
+		}
+	}
+}
+
+func (s *Store) Options(options sessions.Options) {
+	s.options = options.ToGorillaOptions()
+	s.maxAge(s.options.MaxAge)
+}
+
+func (s *Store) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+func (s *Store) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	opts := *s.options
+	session.Options = &opts
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	var sessionId string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &sessionId, s.codecs...); err != nil {
+		return session, err
+	}
+
+	record, err := s.db.GetSession(sessionId)
+	if err == badger.ErrKeyNotFound {
+		// Expired or revoked
+		return session, nil
+	} else if err != nil {
+		return session, err
+	}
+
+	session.ID = record.Id
+	session.Values = record.Values
+	session.IsNew = false
+
+	if time.Since(record.LastSeen) > touchInterval {
+		s.db.TouchSession(record.Id, time.Now())
+	}
+
+	return session, nil
+}
+
+func (s *Store) expireCookie(w http.ResponseWriter, session *gsessions.Session) {
+	opts := *session.Options
+	opts.MaxAge = -1
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), "", &opts))
+}
+
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	// Nothing worth keeping, don't hold on to a record for it
+	if session.Options.MaxAge < 0 || len(session.Values) == 0 {
+		if session.ID != "" {
+			if err := s.db.DeleteSession(session.ID); err != nil && err != badger.ErrKeyNotFound {
+				return err
+			}
+		}
+		s.expireCookie(w, session)
+		return nil
+	}
+
+	create := session.ID == ""
+	if create {
+		session.ID = strings.TrimRight(
+			base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=",
+		)
+	}
+
+	maxAge := session.Options.MaxAge
+	if maxAge == 0 {
+		maxAge = defaultMaxAge
+	}
+
+	now := time.Now()
+	userId, _ := session.Values[models.SessionUserKey].(string)
+	record := &models.Session{
+		Id:        session.ID,
+		UserId:    userId,
+		CreatedAt: now,
+		LastSeen:  now,
+		ExpiresAt: now.Add(time.Duration(maxAge) * time.Second),
+		UserAgent: r.UserAgent(),
+		Ip:        clientIp(r),
+		Values:    session.Values,
+	}
+
+	err := s.db.SetSession(record, create)
+	if err == badger.ErrKeyNotFound {
+		// Revoked while this request was being handled
+		s.expireCookie(w, session)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encoded, session.Options))
+
+	return nil
+}
+
+// encodedWithOldKey reports whether the request's session cookie can only be
+// read with one of the older key pairs.
+func (s *Store) encodedWithOldKey(r *http.Request, name string) bool {
+	if len(s.codecs) < 2 {
+		return false
+	}
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return false
+	}
+
+	var sessionId string
+	if s.codecs[0].Decode(name, cookie.Value, &sessionId) == nil {
+		return false
+	}
+
+	return securecookie.DecodeMulti(name, cookie.Value, &sessionId, s.codecs[1:]...) == nil
+}
+
+// Reencode re-issues session cookies that were protected with a previous key
+// pair so they move onto the current one without logging anyone out. It must
+// run after sessions.Sessions.
+func (s *Store) Reencode(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.encodedWithOldKey(c.Request, name) {
+			session, err := s.Get(c.Request, name)
+			if err == nil && !session.IsNew {
+				if err := s.Save(c.Request, c.Writer, session); err != nil {
+					log.Printf("Unable to re-encode session: %v", err)
+				}
+			}
+		}
+
+		c.Next()
+	}
+}
+
+func clientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,185 @@
+package sessionstore_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/sardap/TuneNeutral/backend/pkg/api"
+	"github.com/sardap/TuneNeutral/backend/pkg/config"
+	"github.com/sardap/TuneNeutral/backend/pkg/db"
+	"github.com/sardap/TuneNeutral/backend/pkg/sessionstore"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	userId      = "paul"
+	sessionName = "tune"
+)
+
+var (
+	hashKey  = []byte("0123456789abcdef0123456789abcdef")
+	blockKey = []byte("abcdef0123456789abcdef0123456789")
+)
+
+func newDatabase(t *testing.T) *db.Database {
+	cfg := &config.Config{
+		DatabasePath: path.Join(t.TempDir(), "database"),
+	}
+
+	return db.ConnectDb(cfg)
+}
+
+func requestWithCookies(cookies []*http.Cookie) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+// login saves a session for userId and returns the cookies the browser would
+// get back.
+func login(t *testing.T, store *sessionstore.Store) []*http.Cookie {
+	r := requestWithCookies(nil)
+	session, err := store.New(r, sessionName)
+	assert.NoError(t, err)
+	assert.True(t, session.IsNew)
+
+	session.Values[api.Userkey] = userId
+	session.Values[api.TokenKey] = []byte("token")
+
+	w := httptest.NewRecorder()
+	assert.NoError(t, store.Save(r, w, session))
+
+	return w.Result().Cookies()
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	store := sessionstore.New(dbConn, hashKey, blockKey)
+
+	// Run
+	cookies := login(t, store)
+
+	session, err := store.New(requestWithCookies(cookies), sessionName)
+	assert.NoError(t, err)
+	assert.False(t, session.IsNew)
+	assert.Equal(t, userId, session.Values[api.Userkey])
+	assert.Equal(t, []byte("token"), session.Values[api.TokenKey])
+
+	// The cookie must only carry the id, never the token
+	for _, cookie := range cookies {
+		assert.NotContains(t, cookie.Value, "token")
+	}
+
+	sessions, err := dbConn.GetUserSessions(userId)
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 1)
+	assert.Equal(t, session.ID, sessions[0].Id)
+}
+
+func TestRevokeSessions(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+	store := sessionstore.New(dbConn, hashKey, blockKey)
+
+	first := login(t, store)
+	second := login(t, store)
+	third := login(t, store)
+
+	sessions, _ := api.ListSessions(dbConn, userId)
+	assert.Len(t, sessions, 3)
+
+	// Run
+	firstSession, _ := store.New(requestWithCookies(first), sessionName)
+	assert.NoError(t, api.RevokeSession(dbConn, userId, api.SessionHandle(firstSession.ID)))
+	assert.ErrorIs(t, api.RevokeSession(dbConn, userId, api.SessionHandle(firstSession.ID)), api.ErrNotFound)
+	assert.ErrorIs(t, api.RevokeSession(dbConn, "someone else", api.SessionHandle(firstSession.ID)), api.ErrNotFound)
+
+	revoked, err := store.New(requestWithCookies(first), sessionName)
+	assert.NoError(t, err)
+	assert.True(t, revoked.IsNew)
+	assert.Empty(t, revoked.Values)
+
+	secondSession, _ := store.New(requestWithCookies(second), sessionName)
+	assert.False(t, secondSession.IsNew)
+
+	// Clearing the user's data logs every session out
+	assert.NoError(t, api.ClearUserData(dbConn, userId))
+	for _, cookies := range [][]*http.Cookie{second, third} {
+		session, err := store.New(requestWithCookies(cookies), sessionName)
+		assert.NoError(t, err)
+		assert.True(t, session.IsNew)
+	}
+
+	sessions, _ = api.ListSessions(dbConn, userId)
+	assert.Len(t, sessions, 0)
+
+	// A request that was in flight when its session was revoked must not
+	// bring it back
+	w := httptest.NewRecorder()
+	assert.NoError(t, store.Save(requestWithCookies(second), w, secondSession))
+	sessions, _ = api.ListSessions(dbConn, userId)
+	assert.Len(t, sessions, 0)
+}
+
+func TestKeyRotation(t *testing.T) {
+	t.Parallel()
+
+	// setup
+	dbConn := newDatabase(t)
+	defer dbConn.Close()
+
+	newHashKey := []byte("fedcba9876543210fedcba9876543210")
+	newBlockKey := []byte("9876543210fedcba9876543210fedcba")
+
+	oldStore := sessionstore.New(dbConn, hashKey, blockKey)
+	rotatedStore := sessionstore.New(dbConn, newHashKey, newBlockKey, hashKey, blockKey)
+	newOnlyStore := sessionstore.New(dbConn, newHashKey, newBlockKey)
+
+	oldCookies := login(t, oldStore)
+
+	// Run
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(sessions.Sessions(sessionName, rotatedStore))
+	r.Use(rotatedStore.Reencode(sessionName))
+	r.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "%v", sessions.Default(c).Get(api.Userkey))
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, requestWithCookies(oldCookies))
+
+	// Still logged in with the old cookie
+	assert.Equal(t, userId, w.Body.String())
+
+	// And handed a cookie under the new key
+	newCookies := w.Result().Cookies()
+	assert.Len(t, newCookies, 1)
+	session, err := newOnlyStore.New(requestWithCookies(newCookies), sessionName)
+	assert.NoError(t, err)
+	assert.False(t, session.IsNew)
+	assert.Equal(t, userId, session.Values[api.Userkey])
+
+	// Cookies already on the new key are left alone
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, requestWithCookies(newCookies))
+	assert.Equal(t, userId, w.Body.String())
+	assert.Empty(t, w.Result().Cookies())
+
+	// Once the old key is dropped old cookies stop working
+	_, err = newOnlyStore.New(requestWithCookies(oldCookies), sessionName)
+	assert.Error(t, err)
+}
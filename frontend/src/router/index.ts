@@ -34,6 +34,13 @@ const routes: Array<RouteRecordRaw> = [
     component: () =>
       import(/* webpackChunkName: "about" */ "../views/MyData.vue"),
   },
+  {
+    path: "/shared/:token",
+    name: "Shared",
+    // Public, App shows it without logging in
+    component: () =>
+      import(/* webpackChunkName: "shared" */ "../views/Shared.vue"),
+  },
 ];
 
 const router = createRouter({